@@ -2,6 +2,7 @@ package scimpatch
 
 import (
 	"context"
+	"log/slog"
 
 	"github.com/scim2/filter-parser/v2"
 )
@@ -36,9 +37,11 @@ func (r *adder) addMapSlice(scopedMap map[string]interface{}, scopedAttr string,
 		return true
 	}
 	changed := false
+	idx := newMapIndex(oldMaps)
 	for _, newMap := range newValue {
-		if !containsMap(oldMaps, newMap) {
+		if !idx.contains(newMap) {
 			oldMaps = append(oldMaps, newMap)
+			idx.add(newMap)
 			changed = true
 		}
 	}
@@ -49,7 +52,7 @@ func (r *adder) addMapSlice(scopedMap map[string]interface{}, scopedAttr string,
 }
 
 func (r *adder) addMap(scopedMap map[string]interface{}, scopedAttr string, newValue map[string]interface{}) bool {
-	oldMap, ok := scopedMap[scopedAttr].(map[string]interface{})
+	oldMap, ok := asMap(scopedMap[scopedAttr])
 	if ok {
 		changed := false
 		scopedMap[scopedAttr], changed = mergeMap(oldMap, newValue)
@@ -60,6 +63,13 @@ func (r *adder) addMap(scopedMap map[string]interface{}, scopedAttr string, newV
 }
 
 func (r *adder) addSlice(scopedMap map[string]interface{}, scopedAttr string, newValue []interface{}) bool {
+	// Complex MultiValued
+	// データストアから読み込んだリソースやフィルタによる remove の後は []map[string]interface{} として保存されているため、
+	// 型の確認より先に判断して既存の要素の上書きを防ぎます
+	if newMaps, ok := areEveryItemsMap(newValue); ok {
+		return r.addMapSlice(scopedMap, scopedAttr, newMaps)
+	}
+
 	oldSlice, ok := scopedMap[scopedAttr].([]interface{})
 	// oldSlice is nil
 	if !ok {
@@ -67,16 +77,13 @@ func (r *adder) addSlice(scopedMap map[string]interface{}, scopedAttr string, ne
 		return true
 	}
 
-	// Complex MultiValued
-	if newMaps, ok := areEveryItemsMap(newValue); ok {
-		return r.addMapSlice(scopedMap, scopedAttr, newMaps)
-	}
-
 	// Singular MultiValued
 	changed := false
+	set := newItemSet(oldSlice)
 	for _, newItem := range newValue {
-		if !containsItem(oldSlice, newItem) {
+		if !set.contains(newItem) {
 			oldSlice = append(oldSlice, newItem)
+			set.add(newItem)
 			changed = true
 		}
 	}
@@ -87,7 +94,7 @@ func (r *adder) addSlice(scopedMap map[string]interface{}, scopedAttr string, ne
 }
 
 func (r *adder) addValue(scopedMap map[string]interface{}, scopedAttr string, newValue interface{}) bool {
-	if oldValue, ok := scopedMap[scopedAttr]; !ok || oldValue != newValue {
+	if oldValue, ok := scopedMap[scopedAttr]; !ok || !eqValue(oldValue, newValue) {
 		scopedMap[scopedAttr] = newValue
 		return true
 	}
@@ -95,11 +102,10 @@ func (r *adder) addValue(scopedMap map[string]interface{}, scopedAttr string, ne
 }
 
 func (r *adder) ByValueExpressionForItem(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, value interface{}) ([]map[string]interface{}, bool) {
-	logger := getLogger(ctx)
 	newValue, ok := value.(map[string]interface{})
 
 	if !ok {
-		logger.Debug("unexpected input")
+		logEvent(ctx, slog.LevelWarn, "unexpected input: value for a filtered item is not an object; operation ignored")
 		return scopedMaps, false
 	}
 
@@ -0,0 +1,80 @@
+package scimpatch
+
+import (
+	"strings"
+
+	"github.com/elimity-com/scim"
+)
+
+// AttributeOperators は属性毎に上書きする Operator です。
+// nil の Operator は PatcherOpts の Adder, Replacer, Remover もしくはパッケージデフォルトの Operator が利用されます。
+type AttributeOperators struct {
+	Adder    Operator
+	Replacer Operator
+	Remover  Operator
+}
+
+// newAttributeOperators は PatcherOpts.AttributeOperators のキーを比較しやすいように小文字に変換し、各 Operator に middlewares を適用します。
+func newAttributeOperators(operators map[string]AttributeOperators, middlewares []OperatorMiddleware) map[string]AttributeOperators {
+	if len(operators) == 0 {
+		return nil
+	}
+	normalized := make(map[string]AttributeOperators, len(operators))
+	for key, o := range operators {
+		normalized[strings.ToLower(key)] = AttributeOperators{
+			Adder:    chainOperator(o.Adder, middlewares),
+			Replacer: chainOperator(o.Replacer, middlewares),
+			Remover:  chainOperator(o.Remover, middlewares),
+		}
+	}
+	return normalized
+}
+
+// attributeOperator は属性に対して利用する Operator を取得します。
+// スキーマの URI で修飾されたキー、属性名のみのキーの順に探し、上書きされていない場合は operator を返却します。
+func (p *Patcher) attributeOperator(opName string, schemaURI string, attrName string, operator Operator) Operator {
+	if len(p.attributeOperators) == 0 {
+		return operator
+	}
+	keys := []string{strings.ToLower(attrName)}
+	if schemaURI != "" {
+		keys = append([]string{strings.ToLower(schemaURI + ":" + attrName)}, keys...)
+	}
+	for _, key := range keys {
+		o, ok := p.attributeOperators[key]
+		if !ok {
+			continue
+		}
+		var overridden Operator
+		switch opName {
+		case scim.PatchOperationAdd:
+			overridden = o.Adder
+		case scim.PatchOperationReplace:
+			overridden = o.Replacer
+		case scim.PatchOperationRemove:
+			overridden = o.Remover
+		}
+		if overridden != nil {
+			return overridden
+		}
+	}
+	return operator
+}
+
+// overridesAnyAttribute は value に含まれる schemaURI の属性のいずれかで Operator が上書きされているかを判断します。
+func (p *Patcher) overridesAnyAttribute(opName string, schemaURI string, value interface{}) bool {
+	if len(p.attributeOperators) == 0 {
+		return false
+	}
+	newMap, ok := asMap(value)
+	if !ok {
+		return false
+	}
+	for key := range newMap {
+		attrName, _, _ := strings.Cut(key, ".")
+		if p.attributeOperator(opName, schemaURI, attrName, nil) != nil {
+			return true
+		}
+	}
+	return false
+}
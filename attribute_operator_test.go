@@ -0,0 +1,115 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// markOperator は呼び出されたことがわかるように対象の属性に固定の値を書き込む Operator です
+type markOperator struct {
+	mark string
+}
+
+func (o markOperator) Direct(ctx context.Context, scopedMap map[string]interface{}, scopedAttr string, value interface{}) bool {
+	scopedMap[scopedAttr] = o.mark
+	return true
+}
+
+func (o markOperator) ByValueExpressionForItem(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, value interface{}) ([]map[string]interface{}, bool) {
+	return []map[string]interface{}{{"value": o.mark}}, true
+}
+
+func (o markOperator) ByValueExpressionForAttribute(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, subAttr string, value interface{}) ([]map[string]interface{}, bool) {
+	return []map[string]interface{}{{subAttr: o.mark}}, true
+}
+
+// TestAttributeOperators は属性毎に上書きした Operator が利用されることをテストします
+func TestAttributeOperators(t *testing.T) {
+	testCases := []struct {
+		name     string
+		op       scim.PatchOperation
+		data     map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "overridden attribute",
+			op:       scim.PatchOperation{Op: "replace", Path: path(`emails`), Value: []interface{}{map[string]interface{}{"value": "a@example.com"}}},
+			data:     map[string]interface{}{},
+			expected: map[string]interface{}{"emails": "emails replacer"},
+		},
+		{
+			name: "overridden attribute with filter",
+			op:   scim.PatchOperation{Op: "replace", Path: path(`emails[type eq "work"].value`), Value: "a@example.com"},
+			data: map[string]interface{}{
+				"emails": []interface{}{map[string]interface{}{"type": "work", "value": "b@example.com"}},
+			},
+			expected: map[string]interface{}{
+				"emails": []map[string]interface{}{{"value": "emails replacer"}},
+			},
+		},
+		{
+			name:     "overridden attribute without path",
+			op:       scim.PatchOperation{Op: "replace", Value: map[string]interface{}{"emails": []interface{}{}, "title": "Manager"}},
+			data:     map[string]interface{}{},
+			expected: map[string]interface{}{"emails": "emails replacer", "title": "Manager"},
+		},
+		{
+			name:     "not overridden operation falls back to default",
+			op:       scim.PatchOperation{Op: "remove", Path: path(`emails`)},
+			data:     map[string]interface{}{"emails": []interface{}{map[string]interface{}{"value": "a@example.com"}}, "title": "Manager"},
+			expected: map[string]interface{}{"title": "Manager"},
+		},
+		{
+			name:     "not overridden attribute",
+			op:       scim.PatchOperation{Op: "replace", Path: path(`title`), Value: "Manager"},
+			data:     map[string]interface{}{},
+			expected: map[string]interface{}{"title": "Manager"},
+		},
+		{
+			name: "overridden extension attribute",
+			op:   scim.PatchOperation{Op: "add", Path: path(`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department`), Value: "Sales"},
+			data: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"department": "department adder"},
+			},
+		},
+		{
+			name: "overridden extension attribute without path",
+			op: scim.PatchOperation{Op: "add", Value: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"department": "Sales", "division": "East"},
+			}},
+			data: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"department": "department adder", "division": "East"},
+			},
+		},
+	}
+
+	patcher := scimpatch.NewPatcher(
+		schema.CoreUserSchema(),
+		[]schema.Schema{schema.ExtensionEnterpriseUser()},
+		&scimpatch.PatcherOpts{
+			AttributeOperators: map[string]scimpatch.AttributeOperators{
+				"emails": {Replacer: markOperator{mark: "emails replacer"}},
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department": {Adder: markOperator{mark: "department adder"}},
+			},
+		},
+	)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, _, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
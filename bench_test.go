@@ -0,0 +1,141 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// newGroupMembers は value が prefix + 連番となる n 件の members を作成します
+func newGroupMembers(prefix string, n int) []interface{} {
+	ret := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		ret[i] = map[string]interface{}{
+			"value":   fmt.Sprintf("%s%d", prefix, i),
+			"display": fmt.Sprintf("User %s%d", prefix, i),
+		}
+	}
+	return ret
+}
+
+// benchmarkMembers は 100,000 件の members を持つ Group に op を適用するベンチマークです
+func benchmarkMembers(b *testing.B, op scim.PatchOperation) {
+	patcher := scimpatch.NewPatcher(schema.CoreGroupSchema(), nil, nil)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		data := map[string]interface{}{
+			"displayName": "Large Group",
+			"members":     newGroupMembers("old", 100000),
+		}
+		b.StartTimer()
+		if _, _, err := patcher.Apply(context.TODO(), op, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAddMembers は 100,000 件の members に 1,000 件の members を追加します
+func BenchmarkAddMembers(b *testing.B) {
+	benchmarkMembers(b, scim.PatchOperation{
+		Op:    "add",
+		Path:  path(`members`),
+		Value: newGroupMembers("new", 1000),
+	})
+}
+
+// BenchmarkAddDuplicatedMembers は 100,000 件の members に既に存在する 1,000 件の members を追加します
+func BenchmarkAddDuplicatedMembers(b *testing.B) {
+	benchmarkMembers(b, scim.PatchOperation{
+		Op:    "add",
+		Path:  path(`members`),
+		Value: newGroupMembers("old", 1000),
+	})
+}
+
+// BenchmarkRemoveMembersWithValue は 100,000 件の members から value を指定して 1,000 件の members を削除します
+func BenchmarkRemoveMembersWithValue(b *testing.B) {
+	benchmarkMembers(b, scim.PatchOperation{
+		Op:    "remove",
+		Path:  path(`members`),
+		Value: newGroupMembers("old", 1000),
+	})
+}
+
+// BenchmarkRemoveMemberByFilter は 100,000 件の members から filter を指定して 1 件の member を削除します
+func BenchmarkRemoveMemberByFilter(b *testing.B) {
+	benchmarkMembers(b, scim.PatchOperation{
+		Op:   "remove",
+		Path: path(`members[value eq "old50000"]`),
+	})
+}
+
+// BenchmarkReplaceMembers は 100,000 件の members を同一の members で置換します
+func BenchmarkReplaceMembers(b *testing.B) {
+	benchmarkMembers(b, scim.PatchOperation{
+		Op:    "replace",
+		Path:  path(`members`),
+		Value: newGroupMembers("old", 100000),
+	})
+}
+
+// BenchmarkApplyUser は Entra ID などから送られる一般的な User の PATCH を適用するベンチマークです
+func BenchmarkApplyUser(b *testing.B) {
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), []schema.Schema{schema.ExtensionEnterpriseUser()}, nil)
+	ops := []scim.PatchOperation{
+		{Op: "Replace", Path: path(`displayName`), Value: "Alice Green"},
+		{Op: "Replace", Path: path(`name.familyName`), Value: "Green"},
+		{Op: "Replace", Path: path(`emails[type eq "work"].value`), Value: "alice@example.com"},
+		{Op: "Add", Path: path(`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department`), Value: "Sales"},
+		{Op: "Replace", Value: map[string]interface{}{"active": false, "title": "Manager"}},
+		{Op: "Remove", Path: path(`phoneNumbers[type eq "fax"]`)},
+	}
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		data := map[string]interface{}{
+			"userName":    "alice@example.com",
+			"displayName": "Alice",
+			"active":      true,
+			"name": map[string]interface{}{
+				"familyName": "Brown",
+				"givenName":  "Alice",
+			},
+			"emails": []interface{}{
+				map[string]interface{}{"type": "work", "value": "alice.brown@example.com", "primary": true},
+			},
+			"phoneNumbers": []interface{}{
+				map[string]interface{}{"type": "work", "value": "+81-3-0000-0000"},
+				map[string]interface{}{"type": "fax", "value": "+81-3-0000-0001"},
+			},
+		}
+		b.StartTimer()
+		if _, _, err := patcher.ApplyOperations(context.TODO(), ops, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkApplyGroup は 50,000 件の members を持つ Group にメンバーの追加・削除を含む PATCH を適用するベンチマークです
+func BenchmarkApplyGroup(b *testing.B) {
+	patcher := scimpatch.NewPatcher(schema.CoreGroupSchema(), nil, nil)
+	ops := []scim.PatchOperation{
+		{Op: "Replace", Path: path(`displayName`), Value: "Large Group"},
+		{Op: "Add", Path: path(`members`), Value: newGroupMembers("new", 500)},
+		{Op: "Remove", Path: path(`members`), Value: newGroupMembers("old", 500)},
+		{Op: "Remove", Path: path(`members[value eq "old25000"]`)},
+	}
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		data := map[string]interface{}{
+			"displayName": "Group",
+			"members":     newGroupMembers("old", 50000),
+		}
+		b.StartTimer()
+		if _, _, err := patcher.ApplyOperations(context.TODO(), ops, data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// 出力形式です。
+const (
+	outputResult  = "result"
+	outputDiff    = "diff"
+	outputExplain = "explain"
+)
+
+// runApply は apply サブコマンドを実行します。
+// op の適用には Patcher.Explain を利用するため、入力されたリソースは変更されません。
+// Explain の結果では writeOnly の属性 (e.g. password) の値が伏せられるため、出力に含まれません。
+func runApply(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("scim-patch apply", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var schemas schemaFlags
+	schemas.register(fs, true)
+	resourceFile := fs.String("resource", "", `resource JSON file ("-" for stdin)`)
+	patchFile := fs.String("patch", "", `PatchOp request JSON file ("-" for stdin)`)
+	output := fs.String("output", outputResult, `output format: "result", "diff" or "explain"`)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if *resourceFile == "" || *patchFile == "" {
+		fmt.Fprintln(stderr, "scim-patch apply: -resource and -patch are required")
+		fs.Usage()
+		return exitUsage
+	}
+	switch *output {
+	case outputResult, outputDiff, outputExplain:
+	default:
+		fmt.Fprintf(stderr, "scim-patch apply: unknown output format %q\n", *output)
+		return exitUsage
+	}
+	if err := checkStdin(*resourceFile, *patchFile); err != nil {
+		fmt.Fprintf(stderr, "scim-patch apply: %v\n", err)
+		return exitUsage
+	}
+
+	patcher, err := schemas.patcher()
+	if err != nil {
+		fmt.Fprintf(stderr, "scim-patch apply: %v\n", err)
+		return exitUsage
+	}
+	resource, err := readResource(*resourceFile, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "scim-patch apply: %v\n", err)
+		return exitUsage
+	}
+	body, err := readInput(*patchFile, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "scim-patch apply: failed to read patch: %v\n", err)
+		return exitUsage
+	}
+	ops, err := scimpatch.ParsePatchRequest(body)
+	if err != nil {
+		fmt.Fprintf(stderr, "scim-patch apply: invalid patch: %v\n", err)
+		return exitUsage
+	}
+
+	explanation := patcher.Explain(context.Background(), ops, resource)
+	switch *output {
+	case outputResult:
+		if explanation.Err == nil {
+			if err := writeJSON(stdout, explanation.Result); err != nil {
+				fmt.Fprintf(stderr, "scim-patch apply: %v\n", err)
+				return exitUsage
+			}
+		}
+	case outputDiff:
+		writeChanges(stdout, "", explanation.Changes)
+	case outputExplain:
+		writeExplanation(stdout, ops, explanation)
+	}
+	if explanation.Err != nil {
+		fmt.Fprintf(stderr, "scim-patch apply: %v\n", explanation.Err)
+		return exitFailed
+	}
+	return exitOK
+}
+
+// writeJSON は v をインデントした JSON として出力します。
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(v)
+}
+
+// compactJSON は v を 1 行の JSON に変換します。変換できない場合は fmt の形式で返却します。
+func compactJSON(v interface{}) string {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// writeChanges は属性単位の変更を、削除された値を "-"、追加された値を "+" として出力します。
+// writeOnly の属性の変更は値を含めずに "~" として出力します。
+func writeChanges(w io.Writer, indent string, changes []scimpatch.AttributeChange) {
+	for _, change := range changes {
+		if change.Redacted {
+			fmt.Fprintf(w, "%s~ %s: %s\n", indent, change.Path, scimpatch.RedactedValue)
+			continue
+		}
+		if change.Old != nil {
+			fmt.Fprintf(w, "%s- %s: %s\n", indent, change.Path, compactJSON(change.Old))
+		}
+		if change.New != nil {
+			fmt.Fprintf(w, "%s+ %s: %s\n", indent, change.Path, compactJSON(change.New))
+		}
+	}
+}
+
+// writeExplanation は op 毎の適用内容の説明を出力します。
+func writeExplanation(w io.Writer, ops []scim.PatchOperation, explanation scimpatch.Explanation) {
+	for _, e := range explanation.Operations {
+		op := ops[e.Index]
+		fmt.Fprintf(w, "[%d] %s", e.Index, strings.ToLower(op.Op))
+		if op.Path != nil {
+			fmt.Fprintf(w, " %s", op.Path.String())
+		}
+		fmt.Fprintln(w)
+		if e.Operation.Op != "" && e.Operation.Op != strings.ToLower(op.Op) {
+			fmt.Fprintf(w, "    applied as: %s\n", e.Operation.Op)
+		}
+		if e.Attribute != "" {
+			fmt.Fprintf(w, "    attribute:  %s:%s\n", e.SchemaURI, e.Attribute)
+		}
+		if e.Branch != scimpatch.ExplainBranchNone {
+			fmt.Fprintf(w, "    branch:     %s\n", e.Branch)
+		}
+		if e.Matched != nil {
+			fmt.Fprintf(w, "    matched:    %d element(s)\n", len(e.Matched))
+			for _, m := range e.Matched {
+				fmt.Fprintf(w, "      %s\n", compactJSON(m))
+			}
+		}
+		if e.Err != nil {
+			fmt.Fprintf(w, "    error:      %v\n", e.Err)
+			continue
+		}
+		if !e.Changed {
+			fmt.Fprintln(w, "    no change")
+			continue
+		}
+		fmt.Fprintln(w, "    changes:")
+		writeChanges(w, "      ", e.Changes)
+	}
+	if explanation.Err != nil && (len(explanation.Operations) == 0 || explanation.Operations[len(explanation.Operations)-1].Err == nil) {
+		fmt.Fprintf(w, "error: %v\n", explanation.Err)
+	}
+}
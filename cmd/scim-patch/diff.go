@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// patchRequest は出力する PATCH リクエストのボディです。
+type patchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []patchOperation `json:"Operations"`
+}
+
+// patchOperation は出力する PATCH リクエストの Operations の各要素です。
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// runDiff は diff サブコマンドを実行します。
+// 変更がない場合は RFC7644 の通り空の Operations を持つリクエストは作成できないため、何も出力しません。
+func runDiff(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	fs := flag.NewFlagSet("scim-patch diff", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var schemas schemaFlags
+	schemas.register(fs, false)
+	fromFile := fs.String("from", "", `resource JSON file before the change ("-" for stdin)`)
+	toFile := fs.String("to", "", `resource JSON file after the change ("-" for stdin)`)
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if *fromFile == "" || *toFile == "" {
+		fmt.Fprintln(stderr, "scim-patch diff: -from and -to are required")
+		fs.Usage()
+		return exitUsage
+	}
+	if err := checkStdin(*fromFile, *toFile); err != nil {
+		fmt.Fprintf(stderr, "scim-patch diff: %v\n", err)
+		return exitUsage
+	}
+
+	patcher, err := schemas.patcher()
+	if err != nil {
+		fmt.Fprintf(stderr, "scim-patch diff: %v\n", err)
+		return exitUsage
+	}
+	before, err := readResource(*fromFile, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "scim-patch diff: %v\n", err)
+		return exitUsage
+	}
+	after, err := readResource(*toFile, stdin)
+	if err != nil {
+		fmt.Fprintf(stderr, "scim-patch diff: %v\n", err)
+		return exitUsage
+	}
+
+	ops, err := patcher.Diff(before, after)
+	if err != nil {
+		fmt.Fprintf(stderr, "scim-patch diff: %v\n", err)
+		return exitFailed
+	}
+	if len(ops) == 0 {
+		fmt.Fprintln(stderr, "scim-patch diff: no differences")
+		return exitOK
+	}
+	req := patchRequest{Schemas: []string{scimpatch.PatchOpSchema}, Operations: make([]patchOperation, 0, len(ops))}
+	for _, op := range ops {
+		req.Operations = append(req.Operations, patchOperation{Op: op.Op, Path: op.Path.String(), Value: op.Value})
+	}
+	if err := writeJSON(stdout, req); err != nil {
+		fmt.Fprintf(stderr, "scim-patch diff: %v\n", err)
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// builtinSchemas は -schema に指定できる組み込みのスキーマです。
+var builtinSchemas = map[string]func() schema.Schema{
+	"user":  schema.CoreUserSchema,
+	"group": schema.CoreGroupSchema,
+}
+
+// builtinExtensions は -extension に指定できる組み込みの拡張スキーマです。
+var builtinExtensions = map[string]func() schema.Schema{
+	"enterprise": schema.ExtensionEnterpriseUser,
+}
+
+// schemaFlags はスキーマとプロファイルを指定するフラグです。
+type schemaFlags struct {
+	schema     string
+	extensions []string
+	profile    string
+}
+
+// register は fs にフラグを登録します。profile が false の場合、-profile は登録しません。
+func (f *schemaFlags) register(fs *flag.FlagSet, profile bool) {
+	fs.StringVar(&f.schema, "schema", "user", `resource schema: "user", "group" or a JSON schema file`)
+	fs.Func("extension", `schema extension: "enterprise" or a JSON schema file (repeatable)`, func(s string) error {
+		f.extensions = append(f.extensions, s)
+		return nil
+	})
+	if !profile {
+		return
+	}
+	fs.StringVar(&f.profile, "profile", "", "IdP compatibility profile: "+strings.Join(profileNames(), ", "))
+}
+
+// patcher はフラグに応じた Patcher を作成します。
+func (f *schemaFlags) patcher() (*scimpatch.Patcher, error) {
+	s, err := loadSchema(f.schema)
+	if err != nil {
+		return nil, err
+	}
+	var extensions []schema.Schema
+	for _, name := range f.extensions {
+		loaded, err := loadExtensions(name)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, loaded...)
+	}
+	opts := &scimpatch.PatcherOpts{}
+	if f.profile != "" {
+		profile, ok := scimpatch.Profiles[f.profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q; available profiles are %s", f.profile, strings.Join(profileNames(), ", "))
+		}
+		opts.Profile = &profile
+	}
+	return scimpatch.NewPatcher(s, extensions, opts), nil
+}
+
+// loadSchema は組み込みのスキーマ名もしくはファイルからスキーマを読み込みます。
+// ファイルは LoadSchemas と同様に ListResponse などの形式を指定できますが、スキーマを 1 つだけ含む必要があります。
+func loadSchema(name string) (schema.Schema, error) {
+	if builtin, ok := builtinSchemas[name]; ok {
+		return builtin(), nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return schema.Schema{}, fmt.Errorf("failed to read schema: %w", err)
+	}
+	schemas, err := scimpatch.LoadSchemas(data)
+	if err != nil {
+		return schema.Schema{}, err
+	}
+	if len(schemas) != 1 {
+		return schema.Schema{}, fmt.Errorf("schema file %q must contain exactly one schema, but contains %d", name, len(schemas))
+	}
+	return schemas[0], nil
+}
+
+// loadExtensions は組み込みの拡張スキーマ名もしくはファイルから拡張スキーマを読み込みます。
+// ファイルには複数のスキーマを含めることができます。
+func loadExtensions(name string) ([]schema.Schema, error) {
+	if builtin, ok := builtinExtensions[name]; ok {
+		return []schema.Schema{builtin()}, nil
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema extension: %w", err)
+	}
+	return scimpatch.LoadSchemas(data)
+}
+
+// profileNames は指定できるプロファイルの名前をソートして返却します。
+func profileNames() []string {
+	names := make([]string, 0, len(scimpatch.Profiles))
+	for name := range scimpatch.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readInput は name のファイルを読み込みます。name が "-" の場合は標準入力から読み込みます。
+func readInput(name string, stdin io.Reader) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// readResource は name のファイルからリソースの JSON を読み込みます。
+func readResource(name string, stdin io.Reader) (map[string]interface{}, error) {
+	data, err := readInput(name, stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read resource: %w", err)
+	}
+	var resource map[string]interface{}
+	if err := json.Unmarshal(data, &resource); err != nil {
+		return nil, fmt.Errorf("failed to decode resource %s: %w", name, err)
+	}
+	return resource, nil
+}
+
+// checkStdin は標準入力から読み込む入力が 1 つ以下であることを確認します。
+func checkStdin(names ...string) error {
+	n := 0
+	for _, name := range names {
+		if name == "-" {
+			n++
+		}
+	}
+	if n > 1 {
+		return fmt.Errorf("only one input can be read from stdin")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+// scim-patch は SCIM の PATCH リクエストをリソースに適用し、適用後のリソース、差分、もしくは op 毎の適用内容の説明を出力するコマンドです。
+// また、2 つのリソースの差分から PATCH リクエストを作成することもできます。
+// 問い合わせのあったリクエストを手元で再現するためのものであり、入力されたファイルは変更しません。
+//
+//	scim-patch apply -resource user.json -patch patch.json [-schema user] [-extension enterprise] [-profile entra] [-output result|diff|explain]
+//	scim-patch diff -from before.json -to after.json [-schema user] [-extension enterprise]
+//
+// diff は before.json を after.json に変換する PatchOp のリクエストボディを出力します。
+// -resource, -patch, -from, -to に "-" を指定すると標準入力から読み込みます。
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// 終了コードです。
+const (
+	exitOK = 0
+	// exitFailed は op の適用に失敗したことを示します
+	exitFailed = 1
+	// exitUsage は引数や入力が不正であることを示します
+	exitUsage = 2
+)
+
+const usage = `Usage: scim-patch <command> [flags]
+
+Commands:
+  apply    apply a PatchOp request to a resource and print the result, a diff or an explanation
+  diff     print a PatchOp request that converts one resource into another
+
+Run "scim-patch <command> -h" for the flags of each command.
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run はサブコマンドを実行し、終了コードを返却します。
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+	switch args[0] {
+	case "apply":
+		return runApply(args[1:], stdin, stdout, stderr)
+	case "diff":
+		return runDiff(args[1:], stdin, stdout, stderr)
+	case "-h", "-help", "--help", "help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	}
+	fmt.Fprintf(stderr, "scim-patch: unknown command %q\n\n%s", args[0], usage)
+	return exitUsage
+}
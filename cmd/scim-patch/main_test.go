@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testResource = `{
+  "schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+  "userName": "alice",
+  "title": "Engineer",
+  "emails": [{"type": "work", "value": "a@example.com"}, {"type": "home", "value": "h@example.com"}]
+}`
+
+const testPatch = `{
+  "schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+  "Operations": [
+    {"op": "Replace", "path": "emails[type eq \"work\"].value", "value": "b@example.com"},
+    {"op": "add", "value": {"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department": "Sales", "active": "True"}}
+  ]
+}`
+
+const testInvalidPatch = `{
+  "schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+  "Operations": [{"op": "remove", "path": "unknown"}]
+}`
+
+const testSchemaListResponse = `{
+  "schemas": ["urn:ietf:params:scim:api:messages:2.0:ListResponse"],
+  "totalResults": 1,
+  "Resources": [{
+    "id": "urn:ietf:params:scim:schemas:core:2.0:User",
+    "name": "User",
+    "attributes": [
+      {"name": "userName", "type": "string", "multiValued": false, "required": true},
+      {"name": "title", "type": "string", "multiValued": false, "required": false}
+    ]
+  }]
+}`
+
+// writeFile は dir に content を書き込んだファイルのパスを返却します
+func writeFile(t *testing.T, dir string, name string, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// TestApply は apply サブコマンドの出力と終了コードをテストします
+func TestApply(t *testing.T) {
+	dir := t.TempDir()
+	resource := writeFile(t, dir, "resource.json", testResource)
+	patch := writeFile(t, dir, "patch.json", testPatch)
+	invalidPatch := writeFile(t, dir, "invalid.json", testInvalidPatch)
+	titlePatch := writeFile(t, dir, "title.json", `{
+  "schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+  "Operations": [{"op": "replace", "path": "title", "value": "Manager"}]
+}`)
+	listSchema := writeFile(t, dir, "schemas.json", testSchemaListResponse)
+	multipleSchemas := writeFile(t, dir, "multiple.json", strings.Replace(testSchemaListResponse, `"Resources": [{`, `"Resources": [{"id": "urn:acme:Device", "name": "Device", "attributes": []}, {`, 1))
+
+	testCases := []struct {
+		name         string
+		args         []string
+		stdin        string
+		expectedCode int
+		expectedOut  string
+		expectedErr  string
+	}{
+		{
+			name:         "result",
+			args:         []string{"apply", "-resource", resource, "-patch", patch, "-extension", "enterprise", "-profile", "entra"},
+			expectedCode: exitOK,
+			expectedOut: `{
+  "active": true,
+  "emails": [
+    {
+      "type": "work",
+      "value": "b@example.com"
+    },
+    {
+      "type": "home",
+      "value": "h@example.com"
+    }
+  ],
+  "schemas": [
+    "urn:ietf:params:scim:schemas:core:2.0:User"
+  ],
+  "title": "Engineer",
+  "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {
+    "department": "Sales"
+  },
+  "userName": "alice"
+}
+`,
+		},
+		{
+			name:         "diff",
+			args:         []string{"apply", "-resource", "-", "-patch", patch, "-extension", "enterprise", "-profile", "entra", "-output", "diff"},
+			stdin:        testResource,
+			expectedCode: exitOK,
+			expectedOut: `+ active: true
+- emails: [{"type":"work","value":"a@example.com"},{"type":"home","value":"h@example.com"}]
++ emails: [{"type":"work","value":"b@example.com"},{"type":"home","value":"h@example.com"}]
++ urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department: "Sales"
+`,
+		},
+		{
+			name:         "explain",
+			args:         []string{"apply", "-resource", resource, "-patch", patch, "-extension", "enterprise", "-profile", "entra", "-output", "explain"},
+			expectedCode: exitOK,
+			expectedOut: `[0] replace emails[type eq "work"].value
+    attribute:  urn:ietf:params:scim:schemas:core:2.0:User:emails
+    branch:     byValueExpressionForAttribute
+    matched:    1 element(s)
+      {"type":"work","value":"a@example.com"}
+    changes:
+      - emails: [{"type":"work","value":"a@example.com"},{"type":"home","value":"h@example.com"}]
+      + emails: [{"type":"work","value":"b@example.com"},{"type":"home","value":"h@example.com"}]
+[1] add
+    branch:     pathUnspecified
+    changes:
+      + active: true
+      + urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department: "Sales"
+`,
+		},
+		{
+			name:         "failed operation",
+			args:         []string{"apply", "-resource", resource, "-patch", invalidPatch, "-output", "explain"},
+			expectedCode: exitFailed,
+			expectedOut: `[0] remove unknown
+    error:      400 (invalidPath) - The "path" attribute was invalid or malformed.
+`,
+			expectedErr: "invalidPath",
+		},
+		{
+			name:         "schema file in ListResponse",
+			args:         []string{"apply", "-resource", resource, "-patch", titlePatch, "-schema", listSchema, "-output", "diff"},
+			expectedCode: exitOK,
+			expectedOut: `- title: "Engineer"
++ title: "Manager"
+`,
+		},
+		{
+			name:         "schema file with multiple schemas",
+			args:         []string{"apply", "-resource", resource, "-patch", titlePatch, "-schema", multipleSchemas},
+			expectedCode: exitUsage,
+			expectedErr:  "must contain exactly one schema, but contains 2",
+		},
+		{
+			name:         "missing flags",
+			args:         []string{"apply", "-resource", resource},
+			expectedCode: exitUsage,
+			expectedErr:  "-resource and -patch are required",
+		},
+		{
+			name:         "unknown profile",
+			args:         []string{"apply", "-resource", resource, "-patch", patch, "-profile", "unknown"},
+			expectedCode: exitUsage,
+			expectedErr:  `unknown profile "unknown"`,
+		},
+		{
+			name:         "both from stdin",
+			args:         []string{"apply", "-resource", "-", "-patch", "-"},
+			expectedCode: exitUsage,
+			expectedErr:  "only one input can be read from stdin",
+		},
+		{
+			name:         "unknown command",
+			args:         []string{"unknown"},
+			expectedCode: exitUsage,
+			expectedErr:  `unknown command "unknown"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run(tc.args, strings.NewReader(tc.stdin), &stdout, &stderr)
+			if code != tc.expectedCode {
+				t.Errorf("exit code:\n    actual  : %d\n    expected: %d\n    stderr  : %s", code, tc.expectedCode, stderr.String())
+			}
+			if stdout.String() != tc.expectedOut {
+				t.Errorf("stdout:\n    actual  :\n%s\n    expected:\n%s", stdout.String(), tc.expectedOut)
+			}
+			if !strings.Contains(stderr.String(), tc.expectedErr) {
+				t.Errorf("stderr:\n    actual  : %s\n    expected: %s", stderr.String(), tc.expectedErr)
+			}
+		})
+	}
+}
+
+// TestDiff は diff サブコマンドが出力した PATCH リクエストを apply すると変更後のリソースになることをテストします
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	before := writeFile(t, dir, "before.json", testResource)
+	after := writeFile(t, dir, "after.json", `{
+  "schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+  "userName": "alice",
+  "title": "Manager",
+  "emails": [{"type": "home", "value": "h@example.com", "primary": true}],
+  "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": {"department": "Sales"}
+}`)
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"diff", "-from", before, "-to", after, "-extension", "enterprise"}, strings.NewReader(""), &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("exit code: %d, stderr: %s", code, stderr.String())
+	}
+	expected := `{
+  "schemas": [
+    "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+  ],
+  "Operations": [
+    {
+      "op": "remove",
+      "path": "emails[value eq \"a@example.com\"]"
+    },
+    {
+      "op": "replace",
+      "path": "emails[value eq \"h@example.com\"].primary",
+      "value": true
+    },
+    {
+      "op": "replace",
+      "path": "title",
+      "value": "Manager"
+    },
+    {
+      "op": "add",
+      "path": "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department",
+      "value": "Sales"
+    }
+  ]
+}
+`
+	if stdout.String() != expected {
+		t.Errorf("stdout:\n    actual  :\n%s\n    expected:\n%s", stdout.String(), expected)
+	}
+
+	patch := writeFile(t, dir, "patch.json", stdout.String())
+	var applied bytes.Buffer
+	if code := run([]string{"apply", "-resource", before, "-patch", patch, "-extension", "enterprise"}, strings.NewReader(""), &applied, &stderr); code != exitOK {
+		t.Fatalf("exit code of apply: %d, stderr: %s", code, stderr.String())
+	}
+	var expectedResource bytes.Buffer
+	if code := run([]string{"diff", "-from", after, "-to", "-", "-extension", "enterprise"}, strings.NewReader(applied.String()), &expectedResource, &stderr); code != exitOK || expectedResource.Len() != 0 {
+		t.Errorf("the applied resource differs from the expected resource:\n%s", expectedResource.String())
+	}
+	if !strings.Contains(stderr.String(), "no differences") {
+		t.Errorf("stderr: %s", stderr.String())
+	}
+}
+
+// TestDiffUsage は diff サブコマンドの引数が不正な場合の終了コードをテストします
+func TestDiffUsage(t *testing.T) {
+	testCases := []struct {
+		name        string
+		args        []string
+		expectedErr string
+	}{
+		{name: "missing flags", args: []string{"diff", "-from", "before.json"}, expectedErr: "-from and -to are required"},
+		{name: "both from stdin", args: []string{"diff", "-from", "-", "-to", "-"}, expectedErr: "only one input can be read from stdin"},
+		{name: "profile is not supported", args: []string{"diff", "-from", "a.json", "-to", "b.json", "-profile", "okta"}, expectedErr: "flag provided but not defined: -profile"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			if code := run(tc.args, strings.NewReader(""), &stdout, &stderr); code != exitUsage {
+				t.Errorf("exit code: %d", code)
+			}
+			if !strings.Contains(stderr.String(), tc.expectedErr) {
+				t.Errorf("stderr:\n    actual  : %s\n    expected: %s", stderr.String(), tc.expectedErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package scimpatch_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// conformanceCase は testdata/conformance/*.jsonl の1行に記録された、IdP から実際に送信された PATCH リクエストのケースです。
+type conformanceCase struct {
+	// Name はケースの名前です
+	Name string `json:"name"`
+	// Profile は利用する CompatibilityProfile の名前です。空の場合はプロファイルを指定しません
+	Profile string `json:"profile"`
+	// SchemaSet は testdata/conformance/schemas 配下のスキーマファイルの名前 (拡張子を除く) です。
+	// ファイルは LoadSchemas で読み込める形式で、最初のスキーマをリソースのスキーマ、残りを拡張スキーマとして利用します
+	SchemaSet string `json:"schemaSet"`
+	// Resource は PATCH 適用前のリソースです
+	Resource map[string]interface{} `json:"resource"`
+	// Request は IdP から送信された PATCH リクエストのボディです
+	Request json.RawMessage `json:"request"`
+	// Expected は PATCH 適用後のリソースです
+	Expected map[string]interface{} `json:"expected"`
+	// ExpectedError は期待されるエラーの scimType です。空の場合はエラーが発生しないことを期待します
+	ExpectedError string `json:"expectedError"`
+}
+
+// loadConformanceSchemas は SchemaSet で指定されたスキーマファイルを読み込みます。
+func loadConformanceSchemas(t *testing.T, name string) (schema.Schema, []schema.Schema) {
+	t.Helper()
+	if name == "" || filepath.Base(name) != name {
+		t.Fatalf("invalid schemaSet %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join("testdata", "conformance", "schemas", name+".json"))
+	if err != nil {
+		t.Fatalf("unknown schemaSet %q: %v", name, err)
+	}
+	schemas, err := scimpatch.LoadSchemas(data)
+	if err != nil {
+		t.Fatalf("LoadSchemas(%s) returned an unexpected error: %v", name, err)
+	}
+	if len(schemas) == 0 {
+		t.Fatalf("schemaSet %q contains no schemas", name)
+	}
+	return schemas[0], schemas[1:]
+}
+
+// TestConformance は testdata/conformance 配下に記録された IdP の PATCH リクエストを再生し、結果をテストします
+func TestConformance(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "conformance", "*.jsonl"))
+	if err != nil {
+		t.Fatalf("filepath.Glob() returned an unexpected error: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no conformance cases found")
+	}
+	for _, file := range files {
+		cases := loadConformanceCases(t, file)
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".jsonl"), func(t *testing.T) {
+			for _, tc := range cases {
+				t.Run(tc.Name, func(t *testing.T) {
+					runConformanceCase(t, tc)
+				})
+			}
+		})
+	}
+}
+
+// loadConformanceCases は JSONL ファイルからケースを読み込みます。空行は無視します。
+func loadConformanceCases(t *testing.T, file string) []conformanceCase {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("os.Open(%s) returned an unexpected error: %v", file, err)
+	}
+	defer f.Close()
+
+	cases := []conformanceCase{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var tc conformanceCase
+		if err := json.Unmarshal(scanner.Bytes(), &tc); err != nil {
+			t.Fatalf("%s:%d: failed to decode case: %v", file, line, err)
+		}
+		cases = append(cases, tc)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read %s: %v", file, err)
+	}
+	return cases
+}
+
+func runConformanceCase(t *testing.T, tc conformanceCase) {
+	resourceSchema, extensions := loadConformanceSchemas(t, tc.SchemaSet)
+	opts := &scimpatch.PatcherOpts{}
+	if tc.Profile != "" {
+		profile, ok := scimpatch.Profiles[tc.Profile]
+		if !ok {
+			t.Fatalf("unknown profile %q", tc.Profile)
+		}
+		opts.Profile = &profile
+	}
+	patcher := scimpatch.NewPatcher(resourceSchema, extensions, opts)
+
+	actual, _, err := patcher.ApplyRequest(context.TODO(), tc.Request, tc.Resource)
+	if tc.ExpectedError != "" {
+		scimErr, ok := err.(errors.ScimError)
+		if !ok || scimErr.ScimType != errors.ScimType(tc.ExpectedError) {
+			t.Fatalf("error:\n    actual  : %v\n    expected: scimType %s", err, tc.ExpectedError)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("ApplyRequest() returned an unexpected error: %v", err)
+	}
+
+	// 期待値は JSON から読み込んでいるため、結果も JSON を経由させて型を揃えてから比較します
+	b, err := json.Marshal(actual)
+	if err != nil {
+		t.Fatalf("failed to encode result: %v", err)
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(b, &normalized); err != nil {
+		t.Fatalf("failed to decode result: %v", err)
+	}
+	if !reflect.DeepEqual(normalized, tc.Expected) {
+		expected, _ := json.Marshal(tc.Expected)
+		t.Errorf("result:\n    actual  : %s\n    expected: %s", b, expected)
+	}
+}
@@ -0,0 +1,342 @@
+package scimpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// Diff は before を after に変換する op を返却します。返却した op を ApplyOperations で before に適用すると after と等しいリソースになります。
+// 下流の SCIM サーバーに送信する PATCH リクエストの作成に利用することを想定しています。
+//
+// op は属性毎に作成され、変更されたサブ属性や複数値属性の要素のみを対象とします。
+//   - 単一値の複合属性はサブ属性毎に `attr.sub` を replace, remove します
+//   - 複数値の複合属性の要素は `attr[sub eq "x"]` のように、その要素のみに一致するフィルタを利用して remove, replace し、追加された要素は add します
+//   - サブ属性を持たない複数値属性は、要素が追加されたのみの場合は追加された要素を add し、それ以外の場合は属性全体を replace します
+//
+// 要素を一意に特定できる文字列のサブ属性が存在しない場合は、属性全体を replace します。
+// caseExact ではないサブ属性は、下流の SCIM サーバーが大文字小文字を区別せずにフィルタを評価しても一意となる場合のみ利用します。
+// before と after は変更されません。スキーマに定義されていない属性と readOnly の属性は対象外です。
+func (p *Patcher) Diff(before map[string]interface{}, after map[string]interface{}) ([]scim.PatchOperation, error) {
+	p = p.snapshot()
+	d := &differ{ops: []scim.PatchOperation{}}
+	for _, key := range unionKeys(before, after) {
+		_, oldValue, _ := lookupKey(before, key)
+		_, newValue, _ := lookupKey(after, key)
+		if extensionID, ok := p.extensionKey(key); ok {
+			extension, ok := p.schemas[extensionID]
+			if !ok {
+				continue
+			}
+			oldMap, _ := asMap(oldValue)
+			newMap, _ := asMap(newValue)
+			for _, extKey := range unionKeys(oldMap, newMap) {
+				attr, ok := extension.Attributes.ContainsAttribute(extKey)
+				if !ok {
+					continue
+				}
+				_, oldExtValue, _ := lookupKey(oldMap, extKey)
+				_, newExtValue, _ := lookupKey(newMap, extKey)
+				if err := d.attribute(extensionID+":"+extKey, attr, oldExtValue, newExtValue); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		attr, ok := p.schema.Attributes.ContainsAttribute(key)
+		if !ok && key == externalIdAttr.Name() {
+			attr, ok = externalIdAttr, true
+		}
+		if !ok {
+			continue
+		}
+		if err := d.attribute(key, attr, oldValue, newValue); err != nil {
+			return nil, err
+		}
+	}
+	return d.ops, nil
+}
+
+// differ は Diff で作成した op を保持します。
+type differ struct {
+	ops []scim.PatchOperation
+}
+
+// append は path を解析した op を追加します。
+func (d *differ) append(opName string, path string, value interface{}) error {
+	parsed, err := filter.ParsePath([]byte(path))
+	if err != nil {
+		return fmt.Errorf("scimpatch: failed to build path %q: %w", path, err)
+	}
+	d.ops = append(d.ops, scim.PatchOperation{Op: opName, Path: &parsed, Value: copyValue(value)})
+	return nil
+}
+
+// attribute は path の属性の変更を op に変換します。
+func (d *differ) attribute(path string, attr schema.CoreAttribute, oldValue interface{}, newValue interface{}) error {
+	oldValue, newValue = emptyToNil(normalizeValue(oldValue)), emptyToNil(normalizeValue(newValue))
+	switch {
+	case isReadOnly(attr), eqValue(oldValue, newValue):
+		return nil
+	case newValue == nil:
+		return d.append(scim.PatchOperationRemove, path, nil)
+	case oldValue == nil:
+		return d.append(scim.PatchOperationAdd, path, newValue)
+	case attr.MultiValued() && attr.AttributeType() == "complex":
+		return d.complexMultiValued(path, attr, oldValue, newValue)
+	case attr.MultiValued():
+		return d.simpleMultiValued(path, oldValue, newValue)
+	case attr.AttributeType() == "complex":
+		oldMap, oldOk := asMap(oldValue)
+		newMap, newOk := asMap(newValue)
+		if !oldOk || !newOk {
+			return d.append(scim.PatchOperationReplace, path, newValue)
+		}
+		return d.subAttributes(path, oldMap, newMap)
+	}
+	return d.append(scim.PatchOperationReplace, path, newValue)
+}
+
+// subAttributes は複合属性の値 oldMap を newMap に変換する op をサブ属性毎に追加します。
+// path にフィルタが含まれる場合は、フィルタに一致する要素のサブ属性が対象となります。
+func (d *differ) subAttributes(path string, oldMap map[string]interface{}, newMap map[string]interface{}) error {
+	for _, key := range unionKeys(oldMap, newMap) {
+		_, oldValue, _ := lookupKey(oldMap, key)
+		_, newValue, _ := lookupKey(newMap, key)
+		if eqValue(normalizeValue(oldValue), normalizeValue(newValue)) {
+			continue
+		}
+		var err error
+		if newValue == nil {
+			err = d.append(scim.PatchOperationRemove, path+"."+key, nil)
+		} else {
+			err = d.append(scim.PatchOperationReplace, path+"."+key, newValue)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// simpleMultiValued はサブ属性を持たない複数値属性の変更を op に変換します。
+// 要素が追加されたのみの場合は追加された要素を add し、要素が削除された場合は属性全体を replace します。
+// サブ属性を持たない複数値属性のフィルタは RFC7644 で定義されていないため、要素の remove には利用しません。
+func (d *differ) simpleMultiValued(path string, oldValue interface{}, newValue interface{}) error {
+	oldItems, oldOk := sliceItems(oldValue)
+	newItems, newOk := sliceItems(newValue)
+	if !oldOk || !newOk {
+		return d.append(scim.PatchOperationReplace, path, newValue)
+	}
+	newSet, oldSet := newItemSet(newItems), newItemSet(oldItems)
+	for _, item := range oldItems {
+		if !newSet.contains(item) {
+			return d.append(scim.PatchOperationReplace, path, newValue)
+		}
+	}
+	added := []interface{}{}
+	for _, item := range newItems {
+		if !oldSet.contains(item) {
+			added = append(added, item)
+		}
+	}
+	if len(added) != 0 {
+		return d.append(scim.PatchOperationAdd, path, added)
+	}
+	return nil
+}
+
+// complexMultiValued は複数値の複合属性の変更を要素毎の op に変換します。
+//
+// value サブ属性の値が等しい要素の組は同じ要素の変更として、サブ属性毎に `attr[filter].sub` を replace, remove します。
+// 組にならなかった要素のうち、削除された要素は `attr[filter]` で remove し、追加された要素はまとめて add します。
+// op は remove, replace, add の順に追加されるため、フィルタは before の要素のうち対象の要素のみに一致し、
+// かつ after の要素のうち対象の要素の変更後の値以外に一致しないものを選択します。
+func (d *differ) complexMultiValued(path string, attr schema.CoreAttribute, oldValue interface{}, newValue interface{}) error {
+	oldItems, oldOk := areEveryItemsMap(oldValue)
+	newItems, newOk := areEveryItemsMap(newValue)
+	if !oldOk || !newOk {
+		return d.append(scim.PatchOperationReplace, path, newValue)
+	}
+
+	// 変更前後で等しい要素と、value が等しい要素を組にします
+	pairs := make(map[int]int, len(oldItems))
+	pairedNew := make(map[int]bool, len(newItems))
+	for i, oldItem := range oldItems {
+		for j, newItem := range newItems {
+			if !pairedNew[j] && eqMap(oldItem, newItem) {
+				pairs[i], pairedNew[j] = j, true
+				break
+			}
+		}
+	}
+	for i, oldItem := range oldItems {
+		if _, ok := pairs[i]; ok {
+			continue
+		}
+		j, ok := uniqueIdentityPair(attr, oldItem, oldItems, newItems)
+		if ok && !pairedNew[j] {
+			pairs[i], pairedNew[j] = j, true
+		}
+	}
+
+	type itemChange struct {
+		filter  string
+		oldItem map[string]interface{}
+		newItem map[string]interface{}
+	}
+	var removed []string
+	var changed []itemChange
+	for i, oldItem := range oldItems {
+		j, paired := pairs[i]
+		if paired && eqMap(oldItem, newItems[j]) {
+			continue
+		}
+		var own map[string]interface{}
+		if paired {
+			own = newItems[j]
+		}
+		expr, ok := uniqueFilter(attr, oldItem, own, oldItems, newItems)
+		if !ok {
+			return d.append(scim.PatchOperationReplace, path, newValue)
+		}
+		if paired {
+			changed = append(changed, itemChange{filter: expr, oldItem: oldItem, newItem: own})
+		} else {
+			removed = append(removed, expr)
+		}
+	}
+	added := []interface{}{}
+	for j, newItem := range newItems {
+		if !pairedNew[j] {
+			added = append(added, newItem)
+		}
+	}
+
+	for _, expr := range removed {
+		if err := d.append(scim.PatchOperationRemove, path+"["+expr+"]", nil); err != nil {
+			return err
+		}
+	}
+	for _, c := range changed {
+		if err := d.subAttributes(path+"["+c.filter+"]", c.oldItem, c.newItem); err != nil {
+			return err
+		}
+	}
+	if len(added) != 0 {
+		return d.append(scim.PatchOperationAdd, path, added)
+	}
+	return nil
+}
+
+// uniqueIdentityPair は oldItem と value が等しい要素が oldItems, newItems にそれぞれ 1 つのみ存在する場合に、newItems での位置を返却します。
+func uniqueIdentityPair(attr schema.CoreAttribute, oldItem map[string]interface{}, oldItems []map[string]interface{}, newItems []map[string]interface{}) (int, bool) {
+	value, ok := oldItem[identityKey].(string)
+	if !ok || countMatches(attr, oldItems, identityKey, value) != 1 {
+		return 0, false
+	}
+	found := -1
+	for j, newItem := range newItems {
+		if matchesValue(attr, identityKey, newItem[identityKey], value) {
+			if found >= 0 {
+				return 0, false
+			}
+			found = j
+		}
+	}
+	return found, found >= 0
+}
+
+// uniqueFilter は oldItem のみに一致するフィルタを返却します。
+// フィルタは oldItems のうち oldItem のみに一致し、newItems のうち own (oldItem の変更後の値) 以外に一致しない `sub eq "x"` の形式です。
+// value サブ属性を優先し、それ以外はサブ属性名の順に、文字列のサブ属性から選択します。
+func uniqueFilter(attr schema.CoreAttribute, oldItem map[string]interface{}, own map[string]interface{}, oldItems []map[string]interface{}, newItems []map[string]interface{}) (string, bool) {
+	keys := make([]string, 0, len(oldItem))
+	for key := range oldItem {
+		if key != identityKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if _, ok := oldItem[identityKey]; ok {
+		keys = append([]string{identityKey}, keys...)
+	}
+	for _, key := range keys {
+		value, ok := oldItem[key].(string)
+		if !ok || countMatches(attr, oldItems, key, value) != 1 {
+			continue
+		}
+		// 変更された要素は複数の op で同じフィルタを利用するため、値が変わらないサブ属性のみを利用します
+		if own != nil && own[key] != value {
+			continue
+		}
+		conflicts := countMatches(attr, newItems, key, value)
+		if own != nil {
+			conflicts--
+		}
+		if conflicts == 0 {
+			return equalityFilter(key, value), true
+		}
+	}
+	return "", false
+}
+
+// countMatches は items のうち key の値が value に一致する要素の数を返却します。isMatchExpression と同じく、key は大文字小文字を区別します。
+func countMatches(attr schema.CoreAttribute, items []map[string]interface{}, key string, value string) int {
+	n := 0
+	for _, item := range items {
+		if matchesValue(attr, key, item[key], value) {
+			n++
+		}
+	}
+	return n
+}
+
+// matchesValue は複数値の複合属性 attr のサブ属性 key の値 itemValue が、`key eq value` のフィルタに一致しうるかを判断します。
+// サブ属性が caseExact ではない場合は、大文字小文字を区別せずに比較します。
+func matchesValue(attr schema.CoreAttribute, key string, itemValue interface{}, value string) bool {
+	s, ok := itemValue.(string)
+	if !ok {
+		return false
+	}
+	if subAttr, ok := attr.SubAttributes().ContainsAttribute(key); ok && subAttr.CaseExact() {
+		return s == value
+	}
+	return strings.EqualFold(s, value)
+}
+
+// equalityFilter は `key eq "value"` 形式のフィルタを作成します。
+func equalityFilter(key string, value string) string {
+	quoted, _ := json.Marshal(value)
+	return key + " eq " + string(quoted)
+}
+
+// emptyToNil は空のスライスと map を nil に変換します。Patcher は空になった複数値属性や複合属性を削除するため、存在しない値として扱います。
+func emptyToNil(value interface{}) interface{} {
+	if v := reflect.ValueOf(value); (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.Len() == 0 {
+		return nil
+	}
+	return value
+}
+
+// sliceItems はスライスの各要素を取得します。
+func sliceItems(value interface{}) ([]interface{}, bool) {
+	if items, ok := value.([]interface{}); ok {
+		return items, true
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice {
+		return nil, false
+	}
+	items := make([]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		items = append(items, v.Index(i).Interface())
+	}
+	return items, true
+}
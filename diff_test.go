@@ -0,0 +1,188 @@
+package scimpatch_test
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestDiff は Diff が返却する op と、その op を before に適用すると after と等しくなることをテストします
+func TestDiff(t *testing.T) {
+	testCases := []struct {
+		name     string
+		before   map[string]interface{}
+		after    map[string]interface{}
+		expected []string
+	}{
+		{
+			name:     "no change",
+			before:   map[string]interface{}{"id": "1", "userName": "alice"},
+			after:    map[string]interface{}{"id": "2", "userName": "alice", "unknown": "x"},
+			expected: []string{},
+		},
+		{
+			name:     "singular attributes",
+			before:   map[string]interface{}{"title": "Engineer", "nickName": "Al", "active": true},
+			after:    map[string]interface{}{"title": "Manager", "displayName": "Alice", "active": true},
+			expected: []string{`add displayName "Alice"`, `remove nickName`, `replace title "Manager"`},
+		},
+		{
+			name:     "complex attribute",
+			before:   map[string]interface{}{"name": map[string]interface{}{"givenName": "Alice", "middleName": "B", "familyName": "Smith"}},
+			after:    map[string]interface{}{"name": map[string]interface{}{"givenName": "Alice", "familyName": "Jones"}},
+			expected: []string{`replace name.familyName "Jones"`, `remove name.middleName`},
+		},
+		{
+			name: "multi-valued complex attribute",
+			before: map[string]interface{}{"emails": []interface{}{
+				map[string]interface{}{"type": "work", "value": "a@example.com", "primary": true},
+				map[string]interface{}{"type": "home", "value": "h@example.com"},
+				map[string]interface{}{"type": "other", "value": "o@example.com"},
+			}},
+			after: map[string]interface{}{"emails": []interface{}{
+				map[string]interface{}{"type": "work", "value": "a@example.com"},
+				map[string]interface{}{"type": "other", "value": "o@example.com"},
+				map[string]interface{}{"type": "home", "value": "new@example.com"},
+			}},
+			expected: []string{
+				`remove emails[value eq "h@example.com"]`,
+				`remove emails[value eq "a@example.com"].primary`,
+				`add emails [{"type":"home","value":"new@example.com"}]`,
+			},
+		},
+		{
+			name: "values differ only in case",
+			before: map[string]interface{}{"emails": []interface{}{
+				map[string]interface{}{"type": "work", "value": "Alice@example.com"},
+				map[string]interface{}{"type": "home", "value": "alice@example.com"},
+			}},
+			after: map[string]interface{}{"emails": []interface{}{
+				map[string]interface{}{"type": "work", "value": "Alice@example.com"},
+			}},
+			expected: []string{`remove emails[type eq "home"]`},
+		},
+		{
+			name:     "simple multi-valued attribute - added",
+			before:   map[string]interface{}{"urn:ivixvi:testSchema": map[string]interface{}{"testString": []interface{}{"a"}}},
+			after:    map[string]interface{}{"urn:ivixvi:testSchema": map[string]interface{}{"testString": []interface{}{"a", "b"}}},
+			expected: []string{`add urn:ivixvi:testSchema:testString ["b"]`},
+		},
+		{
+			name:     "simple multi-valued attribute - removed",
+			before:   map[string]interface{}{"urn:ivixvi:testSchema": map[string]interface{}{"testString": []interface{}{"a", "b"}}},
+			after:    map[string]interface{}{"urn:ivixvi:testSchema": map[string]interface{}{"testString": []interface{}{"b", "c"}}},
+			expected: []string{`replace urn:ivixvi:testSchema:testString ["b","c"]`},
+		},
+		{
+			name: "filtered by another sub-attribute",
+			before: map[string]interface{}{"addresses": []interface{}{
+				map[string]interface{}{"type": "work", "locality": "Tokyo"},
+				map[string]interface{}{"type": "home", "locality": "Osaka"},
+			}},
+			after: map[string]interface{}{"addresses": []interface{}{
+				map[string]interface{}{"type": "work", "locality": "Tokyo"},
+			}},
+			expected: []string{`remove addresses[locality eq "Osaka"]`},
+		},
+		{
+			name: "no unique sub-attribute",
+			before: map[string]interface{}{"phoneNumbers": []interface{}{
+				map[string]interface{}{"value": "1", "type": "work"},
+				map[string]interface{}{"value": "1", "type": "work"},
+			}},
+			after: map[string]interface{}{"phoneNumbers": []interface{}{
+				map[string]interface{}{"value": "1", "type": "work"},
+			}},
+			expected: []string{`replace phoneNumbers [{"type":"work","value":"1"}]`},
+		},
+		{
+			name:   "extension",
+			before: map[string]interface{}{"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"department": "Sales", "manager": map[string]interface{}{"value": "1"}}},
+			after: map[string]interface{}{"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+				"department": "Marketing", "manager": map[string]interface{}{"value": "2"}, "employeeNumber": "42",
+			}},
+			expected: []string{
+				`replace urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department "Marketing"`,
+				`add urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:employeeNumber "42"`,
+				`replace urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:manager.value "2"`,
+			},
+		},
+		{
+			name:     "removed attribute",
+			before:   map[string]interface{}{"emails": []interface{}{map[string]interface{}{"value": "a@example.com"}}, "title": "Engineer"},
+			after:    map[string]interface{}{"emails": []interface{}{}},
+			expected: []string{`remove emails`, `remove title`},
+		},
+	}
+
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), []schema.Schema{schema.ExtensionEnterpriseUser(), TestExtensionSchema}, nil)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ops, err := patcher.Diff(tc.before, tc.after)
+			if err != nil {
+				t.Fatalf("Diff() returned an unexpected error: %v", err)
+			}
+			actual := make([]string, 0, len(ops))
+			for _, op := range ops {
+				actual = append(actual, formatOperation(t, op))
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("operations:\n    actual  : %q\n    expected: %q", actual, tc.expected)
+			}
+
+			before := toJSON(t, tc.before)
+			var data map[string]interface{}
+			if err := json.Unmarshal([]byte(before), &data); err != nil {
+				t.Fatal(err)
+			}
+			patched, _, err := patcher.ApplyOperations(context.TODO(), ops, data)
+			if err != nil {
+				t.Fatalf("ApplyOperations() returned an unexpected error: %v", err)
+			}
+			if toJSON(t, tc.before) != before {
+				t.Errorf("Diff() mutated before: %v", tc.before)
+			}
+			expected := map[string]interface{}{}
+			for k, v := range tc.after {
+				expected[k] = v
+			}
+			for _, k := range []string{"id", "unknown"} {
+				if v, ok := tc.before[k]; ok {
+					expected[k] = v
+				} else {
+					delete(expected, k)
+				}
+			}
+			if emails, ok := expected["emails"].([]interface{}); ok && len(emails) == 0 {
+				delete(expected, "emails")
+			}
+			if toJSON(t, patched) != toJSON(t, expected) {
+				t.Errorf("patched:\n    actual  : %s\n    expected: %s", toJSON(t, patched), toJSON(t, expected))
+			}
+		})
+	}
+}
+
+// formatOperation は op を `op path value` の形式の文字列に変換します
+func formatOperation(t *testing.T, op scim.PatchOperation) string {
+	s := op.Op + " " + op.Path.String()
+	if op.Value != nil {
+		s += " " + toJSON(t, op.Value)
+	}
+	return s
+}
+
+// toJSON は v を JSON の文字列に変換します
+func toJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
@@ -2,24 +2,34 @@ package scimpatch
 
 import (
 	"strings"
+
+	"github.com/elimity-com/scim/errors"
 )
 
 // Resolve an attribute name with dot notation ("name.givenName") to a new scopedMap ("name") and scopedAttr ("givenName")
 // This is used prominently by MS Entra. See https://learn.microsoft.com/en-us/entra/identity/app-provisioning/application-provisioning-config-problem-scim-compatibility#flags-to-alter-the-scim-behavior
-func resolveDotNotationAttribute(scopedMap map[string]interface{}, scopedAttr string) (map[string]interface{}, string) {
+// If the stored value of the parent attribute ("name") is not a complex value, an invalidValue error is returned.
+func resolveDotNotationAttribute(scopedMap map[string]interface{}, scopedAttr string) (map[string]interface{}, string, error) {
 	attrParts := strings.SplitN(scopedAttr, ".", 2)
 	if len(attrParts) == 1 {
-		return scopedMap, scopedAttr
+		return scopedMap, scopedAttr, nil
 	}
 
-	if subMap, exists := scopedMap[attrParts[0]]; exists {
-		scopedMap = subMap.(map[string]interface{})
-	} else {
-		subMap := map[string]interface{}{}
-		scopedMap[attrParts[0]] = subMap
-		scopedMap = subMap
+	switch subMap, exists := scopedMap[attrParts[0]]; {
+	case !exists || subMap == nil:
+		newMap := map[string]interface{}{}
+		scopedMap[attrParts[0]] = newMap
+		scopedMap = newMap
+	default:
+		typedMap, ok := asMap(subMap)
+		if !ok {
+			return nil, "", unexpectedTypeError(errors.ScimErrorInvalidValue, attrParts[0], subMap)
+		}
+		// scim.ResourceAttributes などの名前付きの型は map[string]interface{} として格納し直します
+		scopedMap[attrParts[0]] = typedMap
+		scopedMap = typedMap
 	}
 	scopedAttr = attrParts[1]
 
-	return scopedMap, scopedAttr
+	return scopedMap, scopedAttr, nil
 }
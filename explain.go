@@ -0,0 +1,316 @@
+package scimpatch
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// ExplainBranch は op の適用で選択された処理の分岐です。
+type ExplainBranch string
+
+const (
+	// ExplainBranchNone は op が Operator に渡される前に終了したことを示します (e.g. Hook による拒否, 属性の解決の失敗)
+	ExplainBranchNone ExplainBranch = ""
+	// ExplainBranchPathUnspecified は path 未指定の op として value の属性毎に Operator.Direct が呼び出されたことを示します
+	ExplainBranchPathUnspecified ExplainBranch = "pathUnspecified"
+	// ExplainBranchDirect は `attr`, `attr.sub` 形式の path として Operator.Direct が呼び出されたことを示します
+	ExplainBranchDirect ExplainBranch = "direct"
+	// ExplainBranchValueExpressionForItem は `attr[filter]` 形式の path として Operator.ByValueExpressionForItem が呼び出されたことを示します
+	ExplainBranchValueExpressionForItem ExplainBranch = "byValueExpressionForItem"
+	// ExplainBranchValueExpressionForAttribute は `attr[filter].sub` 形式の path として Operator.ByValueExpressionForAttribute が呼び出されたことを示します
+	ExplainBranchValueExpressionForAttribute ExplainBranch = "byValueExpressionForAttribute"
+)
+
+// AttributeChange は op の適用による属性単位の変更です。
+// 拡張スキーマの属性は、Path がスキーマの URI で修飾されます (e.g. "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department")。
+// 変更前後で値が存在しない場合は、それぞれ Old, New が nil となります。
+// writeOnly の属性 (e.g. password) の変更は、Old, New を含めずに Redacted が true となります。
+type AttributeChange struct {
+	Path     string
+	Old      interface{}
+	New      interface{}
+	Redacted bool
+}
+
+// OperationExplanation は 1 つの op の適用内容の説明です。
+type OperationExplanation struct {
+	// Index は ops における op の位置です
+	Index int
+	// Operation は Profile, Normalizers, Hooks を適用した後に Operator に渡された op です。writeOnly の属性の値は RedactedValue に置き換えられます
+	Operation scim.PatchOperation
+	// SchemaURI, Attribute は path から解決された属性のスキーマの URI と属性名です。path 未指定の op の場合は空文字です
+	SchemaURI string
+	Attribute string
+	// Branch は選択された処理の分岐です
+	Branch ExplainBranch
+	// Matched は path のフィルタに一致した、op の適用前の要素です
+	Matched []map[string]interface{}
+	Changed bool
+	// Changes は op の適用による属性単位の変更です。op が失敗した場合は空です
+	Changes []AttributeChange
+	Err     error
+}
+
+// Explanation は Explain の結果です。
+type Explanation struct {
+	// Operations は適用した op 毎の説明です。いずれかの op が失敗した場合は、失敗した op までの説明となります
+	Operations []OperationExplanation
+	// Result は ops を適用した後のリソースです。いずれかの op が失敗した場合は、失敗した op の適用前のリソースです
+	Result  map[string]interface{}
+	Changed bool
+	// Changes は data と Result を比較した属性単位の変更です
+	Changes []AttributeChange
+	// Err は ApplyOperations が返却するエラーです
+	Err error
+}
+
+// Explain は ops を data に適用した場合の内容を説明します。data は変更されません。
+// op 毎に解決された属性とスキーマ、選択された処理の分岐、フィルタに一致した要素、属性単位の変更とエラーを返却します。
+//
+// Hook は Before, After ともに呼び出されないため、Before による拒否や value の書き換えは説明に含まれません。
+// WriteOnlySink は呼び出されず、writeOnly の属性の値は RedactedValue として保存されたものとみなします。
+// WriteOnlySink を指定していない場合は、Patcher と同様に value がそのまま保存されたものとみなします。
+// Result, Changes に含まれる writeOnly の属性の値は、適用前から保存されていたものも含めて RedactedValue で置き換えられます。
+// Instrumentation は呼び出されません。
+// PatcherOpts で指定した Adder, Replacer, Remover, AttributeOperators と Middlewares も呼び出されず、パッケージデフォルトの Operator で適用した内容を説明します。
+func (p *Patcher) Explain(ctx context.Context, ops []scim.PatchOperation, data map[string]interface{}) Explanation {
+	p = p.explainer()
+	explanation := Explanation{Operations: []OperationExplanation{}}
+	current := copyMap(data)
+	if current == nil {
+		current = map[string]interface{}{}
+	}
+	original := copyMap(current)
+	before := p.requiredExtensionValues(current)
+	for i, op := range ops {
+		e := &OperationExplanation{Index: i}
+		previous := copyMap(current)
+		next, changed, err := p.apply(withExplanation(withLogAttrs(ctx, slog.Int("op_index", i)), e), op, current)
+		e.Changed = changed
+		e.Err = err
+		e.Changes = []AttributeChange{}
+		if err == nil {
+			e.Changes = p.attributeChanges(previous, next)
+		}
+		explanation.Operations = append(explanation.Operations, *e)
+		if err != nil {
+			explanation.Result = p.redactedResource(previous)
+			explanation.Changes = p.attributeChanges(original, previous)
+			explanation.Err = err
+			return explanation
+		}
+		current = next
+		if changed {
+			explanation.Changed = true
+		}
+	}
+	explanation.Result = p.redactedResource(current)
+	explanation.Changes = p.attributeChanges(original, current)
+	explanation.Err = p.validateRequiredExtensions(before, current)
+	return explanation
+}
+
+// explainer は Explain で利用する、副作用のある処理を取り除いた Patcher を返却します。
+func (p *Patcher) explainer() *Patcher {
+	cp := *p.snapshot()
+	cp.instrumentation = noopInstrumentation{}
+	cp.adder = adderInstance
+	cp.replacer = replacerInstance
+	cp.remover = removerInstance
+	cp.attributeOperators = nil
+	if cp.writeOnlySink != nil {
+		cp.writeOnlySink = func(ctx context.Context, op HookOperation) (interface{}, error) {
+			return RedactedValue, nil
+		}
+	}
+	cp.hooks = nil
+	return &cp
+}
+
+type explanationKey struct{}
+
+// withExplanation は op の適用内容を e に記録する context を返却します。
+func withExplanation(ctx context.Context, e *OperationExplanation) context.Context {
+	return context.WithValue(ctx, explanationKey{}, e)
+}
+
+// explanationOf は ctx に記録先の OperationExplanation がある場合に取得します。
+func explanationOf(ctx context.Context) (*OperationExplanation, bool) {
+	e, ok := ctx.Value(explanationKey{}).(*OperationExplanation)
+	return e, ok && e != nil
+}
+
+// explainOperation は Operator に渡される op を記録します。
+func (p *Patcher) explainOperation(ctx context.Context, op scim.PatchOperation) {
+	if e, ok := explanationOf(ctx); ok {
+		e.Operation = op
+		e.Operation.Value = p.redactedValue(op)
+	}
+}
+
+// explainBranch は選択された処理の分岐を記録します。
+func explainBranch(ctx context.Context, branch ExplainBranch) {
+	if e, ok := explanationOf(ctx); ok {
+		e.Branch = branch
+	}
+}
+
+// explainAttribute は path から解決された属性を記録します。
+func explainAttribute(ctx context.Context, schemaURI string, attrName string) {
+	if e, ok := explanationOf(ctx); ok {
+		e.SchemaURI = schemaURI
+		e.Attribute = attrName
+	}
+}
+
+// explainMatched は values のうち expr に一致する要素を記録します。
+func explainMatched(ctx context.Context, values []map[string]interface{}, expr filter.Expression) {
+	e, ok := explanationOf(ctx)
+	if !ok {
+		return
+	}
+	e.Matched = []map[string]interface{}{}
+	for _, value := range values {
+		if isMatchExpression(value, expr) {
+			e.Matched = append(e.Matched, copyMap(value))
+		}
+	}
+}
+
+// attributeChanges は before と after を属性単位で比較し、変更された属性を Path の順で返却します。
+// 拡張スキーマは属性毎に比較します。
+func (p *Patcher) attributeChanges(before map[string]interface{}, after map[string]interface{}) []AttributeChange {
+	changes := []AttributeChange{}
+	for _, key := range unionKeys(before, after) {
+		_, oldValue, _ := lookupKey(before, key)
+		_, newValue, _ := lookupKey(after, key)
+		if eqValue(normalizeValue(oldValue), normalizeValue(newValue)) {
+			continue
+		}
+		if extensionID, ok := p.extensionKey(key); ok {
+			oldMap, oldOk := asMap(oldValue)
+			newMap, newOk := asMap(newValue)
+			if (oldOk || oldValue == nil) && (newOk || newValue == nil) {
+				for _, extKey := range unionKeys(oldMap, newMap) {
+					_, oldExtValue, _ := lookupKey(oldMap, extKey)
+					_, newExtValue, _ := lookupKey(newMap, extKey)
+					if eqValue(normalizeValue(oldExtValue), normalizeValue(newExtValue)) {
+						continue
+					}
+					changes = append(changes, p.attributeChange(extensionID, extKey, oldExtValue, newExtValue))
+				}
+				continue
+			}
+		}
+		changes = append(changes, p.attributeChange("", key, oldValue, newValue))
+	}
+	return changes
+}
+
+// attributeChange は属性の変更を作成します。writeOnly の属性の場合は値を含めません。
+func (p *Patcher) attributeChange(schemaURI string, key string, oldValue interface{}, newValue interface{}) AttributeChange {
+	path := key
+	if schemaURI != "" {
+		path = schemaURI + ":" + key
+	}
+	if p.writeOnlyKey(schemaURI, key) {
+		return AttributeChange{Path: path, Redacted: true}
+	}
+	return AttributeChange{
+		Path: path,
+		Old:  p.redactedAttribute(schemaURI, key, oldValue),
+		New:  p.redactedAttribute(schemaURI, key, newValue),
+	}
+}
+
+// redactedResource は data に保存されている writeOnly の属性の値を RedactedValue で置き換えた複製を返却します。
+func (p *Patcher) redactedResource(data map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		if extensionID, ok := p.extensionKey(key); ok {
+			if extMap, ok := asMap(value); ok {
+				redactedExt := make(map[string]interface{}, len(extMap))
+				for extKey, extValue := range extMap {
+					redactedExt[extKey] = p.redactedAttribute(extensionID, extKey, extValue)
+				}
+				redacted[key] = redactedExt
+				continue
+			}
+		}
+		redacted[key] = p.redactedAttribute("", key, value)
+	}
+	return redacted
+}
+
+// redactedAttribute は属性の値のうち、writeOnly の属性もしくはサブ属性の値を RedactedValue で置き換えた値を返却します。
+func (p *Patcher) redactedAttribute(schemaURI string, key string, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	if p.writeOnlyKey(schemaURI, key) {
+		return RedactedValue
+	}
+	// writeOnly のサブ属性を含まない場合は、値をそのまま返却します
+	redactedItem := func(item map[string]interface{}) (map[string]interface{}, bool) {
+		var redacted map[string]interface{}
+		for subKey := range item {
+			if !p.writeOnlyKey(schemaURI, key+"."+subKey) {
+				continue
+			}
+			if redacted == nil {
+				redacted = copyMap(item)
+			}
+			redacted[subKey] = RedactedValue
+		}
+		return redacted, redacted != nil
+	}
+	if m, ok := asMap(value); ok {
+		if redacted, ok := redactedItem(m); ok {
+			return redacted
+		}
+		return value
+	}
+	if items, ok := areEveryItemsMap(value); ok {
+		var redacted []interface{}
+		for i, item := range items {
+			redactedMap, ok := redactedItem(item)
+			if !ok {
+				continue
+			}
+			if redacted == nil {
+				redacted = make([]interface{}, 0, len(items))
+				for _, item := range items {
+					redacted = append(redacted, item)
+				}
+			}
+			redacted[i] = redactedMap
+		}
+		if redacted != nil {
+			return redacted
+		}
+	}
+	return value
+}
+
+// unionKeys は m1, m2 のいずれかに含まれるキーを、大文字小文字を区別せずに重複を除いてソートして返却します。
+func unionKeys(m1 map[string]interface{}, m2 map[string]interface{}) []string {
+	seen := map[string]struct{}{}
+	keys := []string{}
+	for _, m := range []map[string]interface{}{m1, m2} {
+		for k := range m {
+			lower := strings.ToLower(k)
+			if _, ok := seen[lower]; ok {
+				continue
+			}
+			seen[lower] = struct{}{}
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,263 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestExplain は Explain が op 毎の解決された属性、処理の分岐、フィルタに一致した要素と変更を返却することをテストします
+func TestExplain(t *testing.T) {
+	testCases := []struct {
+		name              string
+		op                scim.PatchOperation
+		expectedSchemaURI string
+		expectedAttribute string
+		expectedBranch    scimpatch.ExplainBranch
+		expectedMatched   []map[string]interface{}
+		expectedChanges   []scimpatch.AttributeChange
+		expectedError     bool
+	}{
+		{
+			name:              "attr",
+			op:                scim.PatchOperation{Op: "Replace", Path: path(`title`), Value: "Manager"},
+			expectedSchemaURI: schema.UserSchema,
+			expectedAttribute: "title",
+			expectedBranch:    scimpatch.ExplainBranchDirect,
+			expectedChanges:   []scimpatch.AttributeChange{{Path: "title", Old: "Engineer", New: "Manager"}},
+		},
+		{
+			name:              "attr[filter].sub",
+			op:                scim.PatchOperation{Op: "replace", Path: path(`emails[type eq "work"].value`), Value: "new@example.com"},
+			expectedSchemaURI: schema.UserSchema,
+			expectedAttribute: "emails",
+			expectedBranch:    scimpatch.ExplainBranchValueExpressionForAttribute,
+			expectedMatched:   []map[string]interface{}{{"type": "work", "value": "old@example.com"}},
+			expectedChanges: []scimpatch.AttributeChange{{
+				Path: "emails",
+				Old:  []map[string]interface{}{{"type": "work", "value": "old@example.com"}, {"type": "home", "value": "home@example.com"}},
+				New:  []map[string]interface{}{{"type": "work", "value": "new@example.com"}, {"type": "home", "value": "home@example.com"}},
+			}},
+		},
+		{
+			name:              "attr[filter]",
+			op:                scim.PatchOperation{Op: "remove", Path: path(`emails[type eq "home"]`)},
+			expectedSchemaURI: schema.UserSchema,
+			expectedAttribute: "emails",
+			expectedBranch:    scimpatch.ExplainBranchValueExpressionForItem,
+			expectedMatched:   []map[string]interface{}{{"type": "home", "value": "home@example.com"}},
+			expectedChanges: []scimpatch.AttributeChange{{
+				Path: "emails",
+				Old:  []map[string]interface{}{{"type": "work", "value": "old@example.com"}, {"type": "home", "value": "home@example.com"}},
+				New:  []map[string]interface{}{{"type": "work", "value": "old@example.com"}},
+			}},
+		},
+		{
+			name:           "path not specified",
+			op:             scim.PatchOperation{Op: "add", Value: map[string]interface{}{"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"department": "Sales"}}},
+			expectedBranch: scimpatch.ExplainBranchPathUnspecified,
+			expectedChanges: []scimpatch.AttributeChange{
+				{Path: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department", New: "Sales"},
+			},
+		},
+		{
+			name:              "no change",
+			op:                scim.PatchOperation{Op: "remove", Path: path(`emails[type eq "other"]`)},
+			expectedSchemaURI: schema.UserSchema,
+			expectedAttribute: "emails",
+			expectedBranch:    scimpatch.ExplainBranchValueExpressionForItem,
+			expectedMatched:   []map[string]interface{}{},
+			expectedChanges:   []scimpatch.AttributeChange{},
+		},
+		{
+			name:            "invalid path",
+			op:              scim.PatchOperation{Op: "remove", Path: path(`unknown`)},
+			expectedBranch:  scimpatch.ExplainBranchNone,
+			expectedChanges: []scimpatch.AttributeChange{},
+			expectedError:   true,
+		},
+	}
+
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), []schema.Schema{schema.ExtensionEnterpriseUser()}, nil)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			data := map[string]interface{}{
+				"title": "Engineer",
+				"emails": []scim.ResourceAttributes{
+					{"type": "work", "value": "old@example.com"},
+					{"type": "home", "value": "home@example.com"},
+				},
+			}
+			before := fmt.Sprint(data)
+			explanation := patcher.Explain(context.TODO(), []scim.PatchOperation{tc.op}, data)
+			if fmt.Sprint(data) != before {
+				t.Errorf("Explain() mutated the input:\n    actual  : %v\n    expected: %s", data, before)
+			}
+			if (explanation.Err != nil) != tc.expectedError {
+				t.Fatalf("error: %v", explanation.Err)
+			}
+			if len(explanation.Operations) != 1 {
+				t.Fatalf("operations: %v", explanation.Operations)
+			}
+			actual := explanation.Operations[0]
+			if (actual.Err != nil) != tc.expectedError {
+				t.Errorf("operation error: %v", actual.Err)
+			}
+			if actual.SchemaURI != tc.expectedSchemaURI || actual.Attribute != tc.expectedAttribute {
+				t.Errorf("attribute:\n    actual  : %s %s\n    expected: %s %s", actual.SchemaURI, actual.Attribute, tc.expectedSchemaURI, tc.expectedAttribute)
+			}
+			if actual.Branch != tc.expectedBranch {
+				t.Errorf("branch:\n    actual  : %q\n    expected: %q", actual.Branch, tc.expectedBranch)
+			}
+			if !reflect.DeepEqual(actual.Matched, tc.expectedMatched) {
+				t.Errorf("matched:\n    actual  : %v\n    expected: %v", actual.Matched, tc.expectedMatched)
+			}
+			if !reflect.DeepEqual(actual.Changes, tc.expectedChanges) {
+				t.Errorf("changes:\n    actual  : %v\n    expected: %v", actual.Changes, tc.expectedChanges)
+			}
+			if actual.Changed != (len(tc.expectedChanges) != 0) {
+				t.Errorf("changed: %t", actual.Changed)
+			}
+		})
+	}
+}
+
+// TestExplainSideEffects は Explain が WriteOnlySink と Hook を呼び出さないことをテストします
+func TestExplainSideEffects(t *testing.T) {
+	sinkCalled, beforeCalled, afterCalled := false, false, false
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+		WriteOnlySink: func(ctx context.Context, op scimpatch.HookOperation) (interface{}, error) {
+			sinkCalled = true
+			return op.Value, nil
+		},
+		Hooks: []scimpatch.Hook{{
+			Attribute: "active",
+			Before: func(ctx context.Context, op *scimpatch.HookOperation) error {
+				beforeCalled = true
+				return errors.ScimErrorMutability
+			},
+			After: func(ctx context.Context, op scimpatch.HookOperation, changed bool, err error) {
+				afterCalled = true
+			},
+		}},
+	})
+
+	explanation := patcher.Explain(context.TODO(), []scim.PatchOperation{
+		{Op: "replace", Value: map[string]interface{}{"password": "secret", "active": true}},
+		{Op: "replace", Path: path(`active`), Value: false},
+		{Op: "replace", Path: path(`title`), Value: "Manager"},
+	}, map[string]interface{}{})
+	if sinkCalled || beforeCalled || afterCalled {
+		t.Errorf("side effects: sink %t, before %t, after %t", sinkCalled, beforeCalled, afterCalled)
+	}
+	if explanation.Err != nil {
+		t.Errorf("error: %v", explanation.Err)
+	}
+	if len(explanation.Operations) != 3 {
+		t.Fatalf("operations: %v", explanation.Operations)
+	}
+	first := explanation.Operations[0]
+	if first.Operation.Value.(map[string]interface{})["password"] != scimpatch.RedactedValue {
+		t.Errorf("the password is not redacted: %v", first.Operation.Value)
+	}
+	expected := []scimpatch.AttributeChange{
+		{Path: "active", New: true},
+		{Path: "password", Redacted: true},
+	}
+	if !reflect.DeepEqual(first.Changes, expected) {
+		t.Errorf("changes:\n    actual  : %v\n    expected: %v", first.Changes, expected)
+	}
+	expectedResult := map[string]interface{}{"password": scimpatch.RedactedValue, "active": false, "title": "Manager"}
+	if !reflect.DeepEqual(explanation.Result, expectedResult) {
+		t.Errorf("result:\n    actual  : %v\n    expected: %v", explanation.Result, expectedResult)
+	}
+}
+
+// TestExplainOperators は Explain で PatcherOpts の Operator と Middlewares が呼び出されず、パッケージデフォルトの Operator で説明されることをテストします
+func TestExplainOperators(t *testing.T) {
+	var recorded []string
+	replacer := scimpatch.Operator(markOperator{mark: "replacer"})
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+		Replacer: &replacer,
+		AttributeOperators: map[string]scimpatch.AttributeOperators{
+			"nickName": {Adder: markOperator{mark: "nickName adder"}},
+		},
+		Middlewares: []scimpatch.OperatorMiddleware{func(next scimpatch.Operator) scimpatch.Operator {
+			return recordingOperator{name: "middleware", next: next, recorded: &recorded}
+		}},
+	})
+
+	explanation := patcher.Explain(context.TODO(), []scim.PatchOperation{
+		{Op: "replace", Path: path(`title`), Value: "Manager"},
+		{Op: "add", Path: path(`nickName`), Value: "Bob"},
+	}, map[string]interface{}{"title": "Engineer"})
+	if explanation.Err != nil {
+		t.Fatalf("error: %v", explanation.Err)
+	}
+	if len(recorded) != 0 {
+		t.Errorf("middlewares are called: %v", recorded)
+	}
+	expected := map[string]interface{}{"title": "Manager", "nickName": "Bob"}
+	if !reflect.DeepEqual(explanation.Result, expected) {
+		t.Errorf("result:\n    actual  : %v\n    expected: %v", explanation.Result, expected)
+	}
+	for _, e := range explanation.Operations {
+		if e.Branch != scimpatch.ExplainBranchDirect {
+			t.Errorf("branch of op %d: %v", e.Index, e.Branch)
+		}
+	}
+}
+
+// TestExplainRedaction は WriteOnlySink の有無にかかわらず Explain の Result, Changes に writeOnly の属性の値が含まれないことをテストします
+func TestExplainRedaction(t *testing.T) {
+	sink := func(ctx context.Context, op scimpatch.HookOperation) (interface{}, error) {
+		return fmt.Sprintf("hashed(%v)", op.Value), nil
+	}
+	testCases := []struct {
+		name            string
+		opts            *scimpatch.PatcherOpts
+		expectedResult  map[string]interface{}
+		expectedChanges []scimpatch.AttributeChange
+	}{
+		{
+			name:           "with sink",
+			opts:           &scimpatch.PatcherOpts{WriteOnlySink: sink},
+			expectedResult: map[string]interface{}{"password": scimpatch.RedactedValue, "title": "Manager"},
+			expectedChanges: []scimpatch.AttributeChange{
+				{Path: "password", Redacted: true},
+				{Path: "title", Old: "Engineer", New: "Manager"},
+			},
+		},
+		{
+			name:           "without sink",
+			expectedResult: map[string]interface{}{"password": scimpatch.RedactedValue, "title": "Manager"},
+			expectedChanges: []scimpatch.AttributeChange{
+				{Path: "password", Redacted: true},
+				{Path: "title", Old: "Engineer", New: "Manager"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, tc.opts)
+			explanation := patcher.Explain(context.TODO(), []scim.PatchOperation{
+				{Op: "replace", Value: map[string]interface{}{"password": "secret", "title": "Manager"}},
+			}, map[string]interface{}{"password": "hashed(old)", "title": "Engineer"})
+			if explanation.Err != nil {
+				t.Fatalf("error: %v", explanation.Err)
+			}
+			if !reflect.DeepEqual(explanation.Result, tc.expectedResult) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", explanation.Result, tc.expectedResult)
+			}
+			if !reflect.DeepEqual(explanation.Changes, tc.expectedChanges) {
+				t.Errorf("changes:\n    actual  : %v\n    expected: %v", explanation.Changes, tc.expectedChanges)
+			}
+		})
+	}
+}
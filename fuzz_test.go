@@ -0,0 +1,69 @@
+package scimpatch_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// FuzzPatcherApply は任意の op, path, value, リソースの組み合わせで Patcher.Apply が panic しないことを確認します
+// 見つかった入力は testdata/fuzz/FuzzPatcherApply に追加し、回帰テストとして利用します
+func FuzzPatcherApply(f *testing.F) {
+	seeds := []struct {
+		op       string
+		path     string
+		value    string
+		resource string
+	}{
+		{"add", "displayName", `"Alice Green"`, `{}`},
+		{"replace", "name.givenName", `"Alice"`, `{"name":{"givenName":"Bob"}}`},
+		{"remove", `emails[type eq "work"]`, `null`, `{"emails":[{"type":"work","value":"a@example.com"}]}`},
+		{"add", `emails[type eq "work"].value`, `"a@example.com"`, `{"emails":[]}`},
+		{"replace", "", `{"active":false,"name.familyName":"Green"}`, `{"active":true}`},
+		{"add", "", `{"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User":{"department":"Sales"}}`, `{}`},
+		{"remove", "members", `[{"value":"1"}]`, `{"members":[{"value":"1"},{"value":"2"}]}`},
+		{"Replace", "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:manager.value", `"0001"`, `{}`},
+		{"add", "urn:ivixvi:testSchema:testString", `["a","b"]`, `{"urn:ivixvi:testSchema":{"testString":["a"]}}`},
+	}
+	for _, seed := range seeds {
+		f.Add(seed.op, seed.path, []byte(seed.value), []byte(seed.resource))
+	}
+
+	patcher := scimpatch.NewPatcher(
+		schema.CoreUserSchema(),
+		[]schema.Schema{
+			schema.ExtensionEnterpriseUser(),
+			TestExtensionSchema,
+		}, nil)
+	f.Fuzz(func(t *testing.T, op string, p string, rawValue []byte, rawResource []byte) {
+		var value interface{}
+		if err := json.Unmarshal(rawValue, &value); err != nil {
+			t.Skip()
+		}
+		var resource map[string]interface{}
+		if err := json.Unmarshal(rawResource, &resource); err != nil || resource == nil {
+			t.Skip()
+		}
+		operation := scim.PatchOperation{Op: op, Value: value}
+		if p != "" {
+			parsed, err := filter.ParsePath([]byte(p))
+			if err != nil {
+				t.Skip()
+			}
+			operation.Path = &parsed
+		}
+
+		_, _, err := patcher.Apply(context.TODO(), operation, resource)
+		if err != nil {
+			if _, ok := err.(errors.ScimError); !ok {
+				t.Errorf("Apply() returned not ScimError: %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,266 @@
+package scimpatch
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/scim2/filter-parser/v2"
+)
+
+const (
+	groupSchemaURI   = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	groupMembersAttr = "members"
+)
+
+// MembersDelta は PATCH によって Group の members に追加・削除されたメンバーの value です。
+// 同一の PATCH 内で追加されてから削除されたメンバーはどちらにも含まれません。
+type MembersDelta struct {
+	Added   []string
+	Removed []string
+}
+
+// GroupPatcher は Group リソースに対して Patcher を利用し、members の差分を取得するためのヘルパーです。
+// members 全体を比較せずに、適用された各 op の内容から追加・削除されたメンバーを算出します。
+type GroupPatcher struct {
+	patcher *Patcher
+}
+
+// NewGroupPatcher は GroupPatcher の実態を取得します。
+func NewGroupPatcher(p *Patcher) *GroupPatcher {
+	return &GroupPatcher{patcher: p}
+}
+
+// Apply は ops を data に適用し、更新後のリソースと members の差分を返却します。
+// 差分はプロファイル、Normalizer、Hook によって書き換えられた後の op から算出し、適用後の members に実際に含まれているかを確認します。
+func (g *GroupPatcher) Apply(ctx context.Context, ops []scim.PatchOperation, data map[string]interface{}) (map[string]interface{}, MembersDelta, error) {
+	tracker := newMembersTracker(data)
+	p := g.patcher.snapshot()
+	for i, op := range ops {
+		var applied *scim.PatchOperation
+		var err error
+		data, _, err = p.apply(withAppliedOperation(withLogAttrs(ctx, slog.Int("op_index", i)), &applied), op, data)
+		if err != nil {
+			return data, MembersDelta{}, err
+		}
+		if applied != nil {
+			tracker.track(*applied, data)
+		}
+	}
+	return data, tracker.delta(), nil
+}
+
+type appliedOperationKey struct{}
+
+// withAppliedOperation は Operator に渡された op を applied に記録する context を返却します。
+func withAppliedOperation(ctx context.Context, applied **scim.PatchOperation) context.Context {
+	return context.WithValue(ctx, appliedOperationKey{}, applied)
+}
+
+// recordAppliedOperation は ctx に記録先がある場合に、Operator に渡される op を記録します。
+func recordAppliedOperation(ctx context.Context, op scim.PatchOperation) {
+	if applied, ok := ctx.Value(appliedOperationKey{}).(**scim.PatchOperation); ok && applied != nil {
+		*applied = &op
+	}
+}
+
+// membersTracker は members の value の集合と、追加・削除の状態を保持します。
+type membersTracker struct {
+	current map[string]struct{}
+	// states は value 毎に追加 (1) もしくは削除 (-1) されたかを保持します
+	states map[string]int
+	order  []string
+}
+
+func newMembersTracker(data map[string]interface{}) *membersTracker {
+	t := &membersTracker{
+		current: map[string]struct{}{},
+		states:  map[string]int{},
+	}
+	_, members, _ := lookupKey(data, groupMembersAttr)
+	for _, v := range memberValues(members) {
+		t.current[v] = struct{}{}
+	}
+	return t
+}
+
+// track は適用された op の内容から members の変更の候補を取得し、適用後の data の members と照合して記録します。
+// op の内容のみから候補を判断できない場合は、適用後の data から members を再集計します。
+func (t *membersTracker) track(op scim.PatchOperation, data map[string]interface{}) {
+	if op.Path == nil {
+		newMap, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return
+		}
+		if _, value, ok := lookupKey(newMap, groupMembersAttr); ok {
+			t.trackValues(op.Op, value, data)
+		}
+		return
+	}
+
+	if op.Path.AttributePath.URIPrefix != nil && *op.Path.AttributePath.URIPrefix != groupSchemaURI ||
+		!strings.EqualFold(op.Path.AttributePath.AttributeName, groupMembersAttr) {
+		return
+	}
+	switch {
+	case op.Path.ValueExpression == nil && op.Path.AttributePath.SubAttribute == nil:
+		if op.Op == scim.PatchOperationRemove && op.Value == nil {
+			t.resync(data)
+			return
+		}
+		t.trackValues(op.Op, op.Value, data)
+	case op.Op == scim.PatchOperationRemove && op.Path.SubAttribute == nil:
+		if value, ok := memberFilterValue(op.Path.ValueExpression); ok {
+			t.trackRemoved([]string{value}, data)
+			return
+		}
+		t.resync(data)
+	default:
+		t.resync(data)
+	}
+}
+
+// trackValues は members 全体に対する op の value から変更を記録します。
+func (t *membersTracker) trackValues(opName string, value interface{}, data map[string]interface{}) {
+	switch opName {
+	case scim.PatchOperationAdd:
+		t.trackAdded(memberValues(value), data)
+	case scim.PatchOperationRemove:
+		t.trackRemoved(memberValues(value), data)
+	case scim.PatchOperationReplace:
+		t.resync(data)
+	}
+}
+
+// trackAdded は values のうち、適用後の data の members に含まれているものを追加として記録します。
+func (t *membersTracker) trackAdded(values []string, data map[string]interface{}) {
+	present := presentMembers(data, values)
+	for _, v := range values {
+		if _, ok := present[v]; ok {
+			t.add(v)
+		}
+	}
+}
+
+// trackRemoved は values のうち、適用後の data の members に含まれていないものを削除として記録します。
+func (t *membersTracker) trackRemoved(values []string, data map[string]interface{}) {
+	present := presentMembers(data, values)
+	for _, v := range values {
+		if _, ok := present[v]; !ok {
+			t.remove(v)
+		}
+	}
+}
+
+func (t *membersTracker) add(value string) {
+	if _, ok := t.current[value]; ok {
+		return
+	}
+	t.current[value] = struct{}{}
+	t.record(value, 1)
+}
+
+func (t *membersTracker) remove(value string) {
+	if _, ok := t.current[value]; !ok {
+		return
+	}
+	delete(t.current, value)
+	t.record(value, -1)
+}
+
+// sortedCurrent は差分の順序を安定させるため、保持している value をソートして返却します。
+func (t *membersTracker) sortedCurrent() []string {
+	values := make([]string, 0, len(t.current))
+	for v := range t.current {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+// resync は適用後の data の members と保持している集合を比較して変更を記録します。
+func (t *membersTracker) resync(data map[string]interface{}) {
+	_, members, _ := lookupKey(data, groupMembersAttr)
+	next := map[string]struct{}{}
+	for _, v := range memberValues(members) {
+		next[v] = struct{}{}
+	}
+	for _, v := range t.sortedCurrent() {
+		if _, ok := next[v]; !ok {
+			t.remove(v)
+		}
+	}
+	for _, v := range memberValues(members) {
+		t.add(v)
+	}
+}
+
+// record は value の状態を更新します。追加と削除が打ち消し合う場合は状態が 0 になります。
+func (t *membersTracker) record(value string, state int) {
+	if _, ok := t.states[value]; !ok {
+		t.order = append(t.order, value)
+	}
+	t.states[value] += state
+}
+
+func (t *membersTracker) delta() MembersDelta {
+	delta := MembersDelta{Added: []string{}, Removed: []string{}}
+	for _, v := range t.order {
+		switch t.states[v] {
+		case 1:
+			delta.Added = append(delta.Added, v)
+		case -1:
+			delta.Removed = append(delta.Removed, v)
+		}
+	}
+	return delta
+}
+
+// memberValues は members の各要素の value を取得します。
+func memberValues(members interface{}) []string {
+	if m, ok := members.(map[string]interface{}); ok {
+		members = []map[string]interface{}{m}
+	}
+	items, ok := areEveryItemsMap(members)
+	if !ok {
+		return nil
+	}
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		if v, ok := item["value"].(string); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// presentMembers は data の members に含まれる value のうち、candidates に含まれるものを返却します。
+func presentMembers(data map[string]interface{}, candidates []string) map[string]struct{} {
+	present := map[string]struct{}{}
+	if len(candidates) == 0 {
+		return present
+	}
+	wanted := make(map[string]struct{}, len(candidates))
+	for _, v := range candidates {
+		wanted[v] = struct{}{}
+	}
+	_, members, _ := lookupKey(data, groupMembersAttr)
+	for _, v := range memberValues(members) {
+		if _, ok := wanted[v]; ok {
+			present[v] = struct{}{}
+		}
+	}
+	return present
+}
+
+// memberFilterValue は `value eq "x"` 形式の expr から x を取得します。
+func memberFilterValue(expr filter.Expression) (string, bool) {
+	attrExpr, ok := expr.(*filter.AttributeExpression)
+	if !ok || attrExpr.Operator != filter.EQ || attrExpr.AttributePath.AttributeName != "value" {
+		return "", false
+	}
+	value, ok := attrExpr.CompareValue.(string)
+	return value, ok
+}
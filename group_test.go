@@ -0,0 +1,164 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+func members(values ...string) []interface{} {
+	ret := []interface{}{}
+	for _, v := range values {
+		ret = append(ret, map[string]interface{}{"value": v})
+	}
+	return ret
+}
+
+// TestGroupPatcherApply は GroupPatcher.Apply で取得される members の差分をテストします
+func TestGroupPatcherApply(t *testing.T) {
+	testCases := []struct {
+		name            string
+		ops             []scim.PatchOperation
+		data            map[string]interface{}
+		profile         *scimpatch.CompatibilityProfile
+		expected        scimpatch.MembersDelta
+		expectedMembers []string
+	}{
+		{
+			name: "add members",
+			ops: []scim.PatchOperation{
+				{Op: "add", Path: path(`members`), Value: members("2", "3")},
+			},
+			data:            map[string]interface{}{"members": members("1", "2")},
+			expected:        scimpatch.MembersDelta{Added: []string{"3"}, Removed: []string{}},
+			expectedMembers: []string{"1", "2", "3"},
+		},
+		{
+			name: "remove members by value filter",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`members[value eq "2"]`)},
+				{Op: "remove", Path: path(`members[value eq "9"]`)},
+			},
+			data:            map[string]interface{}{"members": members("1", "2")},
+			expected:        scimpatch.MembersDelta{Added: []string{}, Removed: []string{"2"}},
+			expectedMembers: []string{"1"},
+		},
+		{
+			name: "remove members with value (Entra ID)",
+			ops: []scim.PatchOperation{
+				{Op: "Remove", Path: path(`members`), Value: members("1", "3")},
+			},
+			data:            map[string]interface{}{"members": members("1", "2", "3")},
+			expected:        scimpatch.MembersDelta{Added: []string{}, Removed: []string{"1", "3"}},
+			expectedMembers: []string{"2"},
+		},
+		{
+			name: "remove all members",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`members`)},
+			},
+			data:            map[string]interface{}{"members": members("1")},
+			expected:        scimpatch.MembersDelta{Added: []string{}, Removed: []string{"1"}},
+			expectedMembers: []string{},
+		},
+		{
+			name: "replace members",
+			ops: []scim.PatchOperation{
+				{Op: "replace", Path: path(`members`), Value: members("2", "3")},
+			},
+			data:            map[string]interface{}{"members": members("1", "2")},
+			expected:        scimpatch.MembersDelta{Added: []string{"3"}, Removed: []string{"1"}},
+			expectedMembers: []string{"2", "3"},
+		},
+		{
+			name: "replace members coerced to add by profile (Entra ID)",
+			ops: []scim.PatchOperation{
+				{Op: "replace", Path: path(`members`), Value: members("2")},
+			},
+			data:            map[string]interface{}{"members": members("1")},
+			profile:         &scimpatch.ProfileEntraID,
+			expected:        scimpatch.MembersDelta{Added: []string{"2"}, Removed: []string{}},
+			expectedMembers: []string{"1", "2"},
+		},
+		{
+			name: "remove members with partially matched value",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`members`), Value: []interface{}{
+					map[string]interface{}{"value": "1", "display": "b"},
+				}},
+			},
+			data: map[string]interface{}{"members": []interface{}{
+				map[string]interface{}{"value": "1", "display": "a"},
+			}},
+			expected:        scimpatch.MembersDelta{Added: []string{}, Removed: []string{}},
+			expectedMembers: []string{"1"},
+		},
+		{
+			name: "path not specified",
+			ops: []scim.PatchOperation{
+				{Op: "add", Value: map[string]interface{}{"members": members("2")}},
+			},
+			data:            map[string]interface{}{},
+			expected:        scimpatch.MembersDelta{Added: []string{"2"}, Removed: []string{}},
+			expectedMembers: []string{"2"},
+		},
+		{
+			name: "added and removed in the same request",
+			ops: []scim.PatchOperation{
+				{Op: "add", Path: path(`members`), Value: members("2")},
+				{Op: "remove", Path: path(`members[value eq "2"]`)},
+				{Op: "remove", Path: path(`members[value eq "1"]`)},
+			},
+			data:            map[string]interface{}{"members": members("1")},
+			expected:        scimpatch.MembersDelta{Added: []string{}, Removed: []string{"1"}},
+			expectedMembers: []string{},
+		},
+		{
+			name: "remove members by other filter",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`members[display eq "Alice"]`)},
+			},
+			data: map[string]interface{}{"members": []interface{}{
+				map[string]interface{}{"value": "1", "display": "Alice"},
+				map[string]interface{}{"value": "2", "display": "Bob"},
+			}},
+			expected:        scimpatch.MembersDelta{Added: []string{}, Removed: []string{"1"}},
+			expectedMembers: []string{"2"},
+		},
+		{
+			name: "not members",
+			ops: []scim.PatchOperation{
+				{Op: "replace", Path: path(`displayName`), Value: "Sales"},
+			},
+			data:            map[string]interface{}{"members": members("1")},
+			expected:        scimpatch.MembersDelta{Added: []string{}, Removed: []string{}},
+			expectedMembers: []string{"1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patcher := scimpatch.NewGroupPatcher(scimpatch.NewPatcher(schema.CoreGroupSchema(), nil, &scimpatch.PatcherOpts{Profile: tc.profile}))
+			result, delta, err := patcher.Apply(context.TODO(), tc.ops, tc.data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if fmt.Sprint(delta) != fmt.Sprint(tc.expected) {
+				t.Errorf("delta:\n    actual  : %v\n    expected: %v", delta, tc.expected)
+			}
+			actualMembers := []string{}
+			if items, ok := scimpatch.AreEveryItemsMap(result["members"]); ok {
+				for _, item := range items {
+					actualMembers = append(actualMembers, fmt.Sprint(item["value"]))
+				}
+			}
+			if fmt.Sprint(actualMembers) != fmt.Sprint(tc.expectedMembers) {
+				t.Errorf("members:\n    actual  : %v\n    expected: %v", actualMembers, tc.expectedMembers)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package scimpatch
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/elimity-com/scim/errors"
+)
+
+// ResourceStore は PatchHandler が利用するリソースの保存先です。
+// version は楽観的排他制御のためのリソースのバージョンで、If-Match ヘッダーや ETag ヘッダーの値としてそのまま利用されます。
+//
+// Get はリソースとそのバージョンを返却します。リソースが存在しない場合は errors.ScimErrorResourceNotFound を返却してください。
+// Put はリソースのバージョンが version と一致する場合のみリソースを保存し、新しいバージョンを返却します。
+// 一致しない場合は ScimErrorPreconditionFailed を返却してください。
+// errors.ScimError (fmt.Errorf の %w でラップされたものを含む) 以外のエラーは、エラーの内容を含めずに 500 Internal Server Error として扱います。
+type ResourceStore interface {
+	Get(ctx context.Context, resourceType string, id string) (map[string]interface{}, string, error)
+	Put(ctx context.Context, resourceType string, id string, data map[string]interface{}, version string) (string, error)
+}
+
+// ScimErrorPreconditionFailed はリソースのバージョンが If-Match ヘッダーや Put で指定されたバージョンと一致しない場合のエラーです。
+// cf. https://datatracker.ietf.org/doc/html/rfc7644#section-3.14
+var ScimErrorPreconditionFailed = errors.ScimError{
+	Detail: "Failed to update. Resource has changed on the server.",
+	Status: http.StatusPreconditionFailed,
+}
+
+// DefaultMaxBodyBytes は PatchHandlerOpts.MaxBodyBytes が指定されていない場合のリクエストのボディの上限のバイト数です。
+const DefaultMaxBodyBytes int64 = 1 << 20
+
+// ScimErrorRequestEntityTooLarge はリクエストのボディが上限のバイト数を超えた場合のエラーです。
+var ScimErrorRequestEntityTooLarge = errors.ScimError{
+	Detail: "The size of the request body exceeds the limit.",
+	Status: http.StatusRequestEntityTooLarge,
+}
+
+// PatchHandlerOpts を利用することで PatchHandler の挙動を変更することができます。
+// Prefix を指定すると、リクエストの URL のパスから Prefix を取り除いたうえで `/{ResourceType}/{id}` として扱います。
+// NoContent を指定すると、成功時に 200 OK とリソースの代わりに 204 No Content を返却します。
+// MaxBodyBytes はリクエストのボディの上限のバイト数で、超えた場合は 413 Request Entity Too Large を返却します。0 以下の場合は DefaultMaxBodyBytes を利用します。
+type PatchHandlerOpts struct {
+	Prefix       string
+	NoContent    bool
+	MaxBodyBytes int64
+}
+
+// PatchHandler は `PATCH /{ResourceType}/{id}` を処理する http.Handler です。
+// elimity-com/scim の scim.Server を利用せずに PATCH のエンドポイントを提供するためのものです。
+type PatchHandler struct {
+	store    ResourceStore
+	patchers map[string]*Patcher
+	opts     PatchHandlerOpts
+}
+
+// NewPatchHandler は PatchHandler の実態を取得します。
+// patchers はリソースタイプの名前 (e.g. "Users") から、そのリソースタイプで利用する Patcher を取得するためのものです。
+func NewPatchHandler(store ResourceStore, patchers map[string]*Patcher, opts *PatchHandlerOpts) *PatchHandler {
+	h := &PatchHandler{
+		store:    store,
+		patchers: patchers,
+	}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.MaxBodyBytes <= 0 {
+		h.opts.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	return h
+}
+
+// ServeHTTP は PATCH リクエストのボディを Patcher で適用し、ResourceStore に保存します。
+// If-Match ヘッダーが指定されている場合は、リソースのバージョンが一致する場合のみ適用します。
+// cf. https://datatracker.ietf.org/doc/html/rfc7644#section-3.5.2
+func (h *PatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		w.Header().Set("Allow", http.MethodPatch)
+		writeScimError(w, errors.ScimError{
+			Detail: fmt.Sprintf("The method %s is not allowed.", r.Method),
+			Status: http.StatusMethodNotAllowed,
+		})
+		return
+	}
+
+	resourceType, id, ok := h.splitPath(r.URL.Path)
+	if !ok {
+		writeScimError(w, errors.ScimError{
+			Detail: fmt.Sprintf("The endpoint %s was not found.", r.URL.Path),
+			Status: http.StatusNotFound,
+		})
+		return
+	}
+	patcher, ok := h.patchers[resourceType]
+	if !ok {
+		writeScimError(w, errors.ScimError{
+			Detail: fmt.Sprintf("The resource type %s was not found.", resourceType),
+			Status: http.StatusNotFound,
+		})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.opts.MaxBodyBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if stderrors.As(err, &maxBytesErr) {
+			writeScimError(w, ScimErrorRequestEntityTooLarge)
+			return
+		}
+		writeScimError(w, errors.ScimErrorInvalidSyntax)
+		return
+	}
+	data, version, err := h.store.Get(r.Context(), resourceType, id)
+	if err != nil {
+		writeScimError(w, checkScimError(err))
+		return
+	}
+	if !matchesETag(r.Header.Get("If-Match"), version) {
+		writeScimError(w, ScimErrorPreconditionFailed)
+		return
+	}
+
+	patched, changed, err := patcher.ApplyRequest(r.Context(), body, data)
+	if err != nil {
+		writeScimError(w, checkScimError(err))
+		return
+	}
+	if changed {
+		version, err = h.store.Put(r.Context(), resourceType, id, patched, version)
+		if err != nil {
+			writeScimError(w, checkScimError(err))
+			return
+		}
+	}
+
+	if version != "" {
+		w.Header().Set("Etag", version)
+	}
+	if h.opts.NoContent {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	raw, err := json.Marshal(patched)
+	if err != nil {
+		writeScimError(w, errors.ScimErrorInternal)
+		return
+	}
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(raw)
+}
+
+// splitPath はリクエストの URL のパスからリソースタイプの名前と id を取得します。
+func (h *PatchHandler) splitPath(urlPath string) (string, string, bool) {
+	trimmed, ok := strings.CutPrefix(urlPath, h.opts.Prefix)
+	if !ok {
+		return "", "", false
+	}
+	resourceType, id, ok := strings.Cut(strings.Trim(trimmed, "/"), "/")
+	if !ok || resourceType == "" || id == "" || strings.Contains(id, "/") {
+		return "", "", false
+	}
+	return resourceType, id, true
+}
+
+// matchesETag は If-Match ヘッダーの値にリソースのバージョンが含まれているかを確認します。
+// ヘッダーが指定されていない場合と "*" の場合は常に一致するものとして扱います。
+// cf. https://datatracker.ietf.org/doc/html/rfc7232#section-3.1
+func matchesETag(ifMatch string, version string) bool {
+	if ifMatch == "" {
+		return true
+	}
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == version {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScimError は err に含まれる errors.ScimError を取得し、PATCH のレスポンスとして利用できるかを確認します。
+// errors.ScimError を含まない場合は、エラーの内容をレスポンスに含めないよう ScimErrorInternal を返却します。
+func checkScimError(err error) errors.ScimError {
+	var scimErr errors.ScimError
+	if !stderrors.As(err, &scimErr) {
+		return errors.ScimErrorInternal
+	}
+	return errors.CheckScimError(scimErr, http.MethodPatch)
+}
+
+// writeScimError は scimErr を SCIM のエラーレスポンスとして書き込みます。
+// cf. https://datatracker.ietf.org/doc/html/rfc7644#section-3.12
+func writeScimError(w http.ResponseWriter, scimErr errors.ScimError) {
+	raw, err := json.Marshal(scimErr)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(scimErr.Status)
+	_, _ = w.Write(raw)
+}
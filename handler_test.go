@@ -0,0 +1,244 @@
+package scimpatch_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestPatchHandler は PatchHandler のレスポンスと保存されるリソースをテストします
+func TestPatchHandler(t *testing.T) {
+	const replaceTitle = `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","path":"title","value":"Manager"}]}`
+	testCases := []struct {
+		name             string
+		method           string
+		target           string
+		ifMatch          string
+		body             string
+		opts             *scimpatch.PatchHandlerOpts
+		expectedStatus   int
+		expectedScimType errors.ScimType
+		expectedBody     map[string]interface{}
+		expectedStored   map[string]interface{}
+	}{
+		{
+			name:           "changed",
+			method:         http.MethodPatch,
+			target:         "/Users/0001",
+			body:           replaceTitle,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"id": "0001", "title": "Manager"},
+			expectedStored: map[string]interface{}{"id": "0001", "title": "Manager"},
+		},
+		{
+			name:           "no content with prefix",
+			method:         http.MethodPatch,
+			target:         "/scim/v2/Users/0001",
+			body:           replaceTitle,
+			opts:           &scimpatch.PatchHandlerOpts{Prefix: "/scim/v2", NoContent: true},
+			expectedStatus: http.StatusNoContent,
+			expectedStored: map[string]interface{}{"id": "0001", "title": "Manager"},
+		},
+		{
+			name:           "If-Match matched",
+			method:         http.MethodPatch,
+			target:         "/Users/0001",
+			ifMatch:        `W/"1"`,
+			body:           replaceTitle,
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"id": "0001", "title": "Manager"},
+			expectedStored: map[string]interface{}{"id": "0001", "title": "Manager"},
+		},
+		{
+			name:           "If-Match not matched",
+			method:         http.MethodPatch,
+			target:         "/Users/0001",
+			ifMatch:        `W/"0"`,
+			body:           replaceTitle,
+			expectedStatus: http.StatusPreconditionFailed,
+			expectedStored: map[string]interface{}{"id": "0001", "title": "Engineer"},
+		},
+		{
+			name:             "invalid path is not stored",
+			method:           http.MethodPatch,
+			target:           "/Users/0001",
+			body:             `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","path":"title","value":"Manager"},{"op":"remove","path":"unknown"}]}`,
+			expectedStatus:   http.StatusBadRequest,
+			expectedScimType: errors.ScimTypeInvalidPath,
+			expectedStored:   map[string]interface{}{"id": "0001", "title": "Engineer"},
+		},
+		{
+			name:             "invalid request body",
+			method:           http.MethodPatch,
+			target:           "/Users/0001",
+			body:             `{"Operations":[]}`,
+			expectedStatus:   http.StatusBadRequest,
+			expectedScimType: errors.ScimTypeInvalidValue,
+			expectedStored:   map[string]interface{}{"id": "0001", "title": "Engineer"},
+		},
+		{
+			name:           "request body too large",
+			method:         http.MethodPatch,
+			target:         "/Users/0001",
+			body:           replaceTitle,
+			opts:           &scimpatch.PatchHandlerOpts{MaxBodyBytes: int64(len(replaceTitle) - 1)},
+			expectedStatus: http.StatusRequestEntityTooLarge,
+			expectedStored: map[string]interface{}{"id": "0001", "title": "Engineer"},
+		},
+		{
+			name:           "request body at the limit",
+			method:         http.MethodPatch,
+			target:         "/Users/0001",
+			body:           replaceTitle,
+			opts:           &scimpatch.PatchHandlerOpts{MaxBodyBytes: int64(len(replaceTitle))},
+			expectedStatus: http.StatusOK,
+			expectedBody:   map[string]interface{}{"id": "0001", "title": "Manager"},
+			expectedStored: map[string]interface{}{"id": "0001", "title": "Manager"},
+		},
+		{
+			name:           "resource not found",
+			method:         http.MethodPatch,
+			target:         "/Users/0002",
+			body:           replaceTitle,
+			expectedStatus: http.StatusNotFound,
+			expectedStored: map[string]interface{}{"id": "0001", "title": "Engineer"},
+		},
+		{
+			name:           "resource type not found",
+			method:         http.MethodPatch,
+			target:         "/Groups/0001",
+			body:           replaceTitle,
+			expectedStatus: http.StatusNotFound,
+			expectedStored: map[string]interface{}{"id": "0001", "title": "Engineer"},
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPut,
+			target:         "/Users/0001",
+			body:           replaceTitle,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedStored: map[string]interface{}{"id": "0001", "title": "Engineer"},
+		},
+	}
+
+	patchers := map[string]*scimpatch.Patcher{
+		"Users": scimpatch.NewPatcher(schema.CoreUserSchema(), nil, nil),
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := scimpatch.NewMemoryStore()
+			store.Set("Users", "0001", map[string]interface{}{"id": "0001", "title": "Engineer"})
+			handler := scimpatch.NewPatchHandler(store, patchers, tc.opts)
+
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("status:\n    actual  : %d\n    expected: %d\n    body    : %s", rec.Code, tc.expectedStatus, rec.Body)
+			}
+			if tc.expectedStatus >= http.StatusBadRequest {
+				var scimErr errors.ScimError
+				if err := json.Unmarshal(rec.Body.Bytes(), &scimErr); err != nil {
+					t.Fatalf("failed to decode error response: %v", err)
+				}
+				if scimErr.Status != tc.expectedStatus || scimErr.ScimType != tc.expectedScimType {
+					t.Errorf("error:\n    actual  : %v\n    expected: %d (%s)", scimErr, tc.expectedStatus, tc.expectedScimType)
+				}
+			}
+			if tc.expectedBody != nil {
+				var actual map[string]interface{}
+				if err := json.Unmarshal(rec.Body.Bytes(), &actual); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if !reflect.DeepEqual(actual, tc.expectedBody) {
+					t.Errorf("body:\n    actual  : %v\n    expected: %v", actual, tc.expectedBody)
+				}
+			}
+
+			stored, version, _ := store.Get(context.TODO(), "Users", "0001")
+			if !reflect.DeepEqual(stored, tc.expectedStored) {
+				t.Errorf("stored:\n    actual  : %v\n    expected: %v", stored, tc.expectedStored)
+			}
+			if tc.expectedStatus == http.StatusOK && rec.Header().Get("Etag") != version {
+				t.Errorf("Etag:\n    actual  : %s\n    expected: %s", rec.Header().Get("Etag"), version)
+			}
+		})
+	}
+}
+
+// errorStore は Get で err を返却する ResourceStore です
+type errorStore struct {
+	err error
+}
+
+func (s errorStore) Get(ctx context.Context, resourceType string, id string) (map[string]interface{}, string, error) {
+	return nil, "", s.err
+}
+
+func (s errorStore) Put(ctx context.Context, resourceType string, id string, data map[string]interface{}, version string) (string, error) {
+	return "", s.err
+}
+
+// TestPatchHandlerStoreError は ResourceStore が返却したエラーのレスポンスをテストします
+func TestPatchHandlerStoreError(t *testing.T) {
+	testCases := []struct {
+		name           string
+		err            error
+		expectedStatus int
+	}{
+		{
+			name:           "scim error",
+			err:            errors.ScimErrorResourceNotFound("0001"),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "wrapped scim error",
+			err:            fmt.Errorf("failed to load: %w", errors.ScimErrorResourceNotFound("0001")),
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "other error",
+			err:            fmt.Errorf("dial tcp db.internal:5432: connection refused"),
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	patchers := map[string]*scimpatch.Patcher{
+		"Users": scimpatch.NewPatcher(schema.CoreUserSchema(), nil, nil),
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := scimpatch.NewPatchHandler(errorStore{err: tc.err}, patchers, nil)
+			req := httptest.NewRequest(http.MethodPatch, "/Users/0001", strings.NewReader(`{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","path":"title","value":"Manager"}]}`))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.expectedStatus {
+				t.Fatalf("status:\n    actual  : %d\n    expected: %d\n    body    : %s", rec.Code, tc.expectedStatus, rec.Body)
+			}
+			var scimErr errors.ScimError
+			if err := json.Unmarshal(rec.Body.Bytes(), &scimErr); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if scimErr.Status != tc.expectedStatus {
+				t.Errorf("status of the error response:\n    actual  : %d\n    expected: %d", scimErr.Status, tc.expectedStatus)
+			}
+			if strings.Contains(rec.Body.String(), "db.internal") {
+				t.Errorf("the error is exposed in the response: %s", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package scimpatch
+
+import (
+	"context"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// HookOperation は Hook に渡される op の情報です。
+// path 未指定の op の場合、Attribute を指定した Hook には value に含まれる属性毎に呼び出されます。
+type HookOperation struct {
+	// Op は小文字に変換された op です
+	Op string
+	// Path は op の path です。path 未指定の op の場合は nil です
+	Path *filter.Path
+	// SchemaURI は属性が属するスキーマの URI です。属性を特定できない場合は空文字です
+	SchemaURI string
+	// Attribute は対象の属性名です。path 未指定の op 全体に対する呼び出しの場合は空文字です
+	Attribute string
+	// SubAttribute は対象のサブ属性名です。サブ属性が対象ではない場合は空文字です
+	SubAttribute string
+	// Value は op の value です。Before で書き換えると、書き換えた value が適用されます
+	Value interface{}
+	// Data は op を適用する対象のリソースです。After では op が適用された後のリソースです。Hook で変更してはいけません
+	Data map[string]interface{}
+}
+
+// Hook は op の適用前後に呼び出される処理です。
+//
+// Attribute を指定しない場合はすべての op で呼び出されます。
+// Attribute を指定した場合は、その属性が対象の op でのみ呼び出されます。
+// "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department" のようにスキーマの URI で修飾して指定することもできます。
+//
+// Before は op の適用前に呼び出されます。エラーを返却すると op は適用されず、そのエラーが Apply から返却されます。
+// そのため、errors.ScimError を返却することを推奨します。op.Value を書き換えると、書き換えた value が適用されます。
+// After は op の適用後に呼び出され、変更の有無と適用時のエラーを受け取ります。Before がエラーを返却した場合は呼び出されません。
+type Hook struct {
+	Attribute string
+	Before    func(ctx context.Context, op *HookOperation) error
+	After     func(ctx context.Context, op HookOperation, changed bool, err error)
+}
+
+// matches は hop が Hook の対象であるかを判断します。
+// whole は op 全体に対する呼び出し、attribute は属性に対する呼び出しであるかを示します。
+// Attribute を指定しない Hook は op 全体に対する呼び出し、Attribute を指定した Hook は属性に対する呼び出しのみが対象です。
+func (h Hook) matches(hop HookOperation, whole bool, attribute bool) bool {
+	if h.Attribute == "" {
+		return whole
+	}
+	if !attribute || hop.Attribute == "" {
+		return false
+	}
+	if i := strings.LastIndex(h.Attribute, ":"); i >= 0 {
+		return strings.EqualFold(h.Attribute[:i], hop.SchemaURI) && strings.EqualFold(h.Attribute[i+1:], hop.Attribute)
+	}
+	return strings.EqualFold(h.Attribute, hop.Attribute)
+}
+
+// hookCall は Before を呼び出した HookOperation と、その呼び出しの種類の組です。After の呼び出しに利用します。
+type hookCall struct {
+	op        HookOperation
+	whole     bool
+	attribute bool
+}
+
+// runBeforeHooks は Before を呼び出し、value を書き換えた op と After の呼び出しに利用する hookCall を返却します。
+// path が指定されている場合は op 全体と属性に対する呼び出しを区別しません。
+// path 未指定の場合は op 全体に対して呼び出した後、value に含まれる属性毎に呼び出します。
+func (p *Patcher) runBeforeHooks(ctx context.Context, op scim.PatchOperation, data map[string]interface{}) (scim.PatchOperation, []hookCall, error) {
+	if len(p.hooks) == 0 {
+		return op, nil, nil
+	}
+
+	if op.Path != nil {
+		attrName := op.Path.AttributePath.AttributeName
+		call := hookCall{
+			op: HookOperation{
+				Op:           op.Op,
+				Path:         op.Path,
+				SchemaURI:    p.schemaURIOf(op.Path.AttributePath.URIPrefix, attrName),
+				Attribute:    attrName,
+				SubAttribute: op.Path.SubAttributeName(),
+				Value:        op.Value,
+				Data:         data,
+			},
+			whole:     true,
+			attribute: true,
+		}
+		if err := p.callBefore(ctx, &call); err != nil {
+			return op, nil, err
+		}
+		op.Value = call.op.Value
+		return op, []hookCall{call}, nil
+	}
+
+	call := hookCall{op: HookOperation{Op: op.Op, Value: op.Value, Data: data}, whole: true}
+	if err := p.callBefore(ctx, &call); err != nil {
+		return op, nil, err
+	}
+	op.Value = call.op.Value
+	calls := []hookCall{call}
+
+	newMap, ok := asMap(op.Value)
+	if !ok {
+		return op, calls, nil
+	}
+	converted := make(map[string]interface{}, len(newMap))
+	for key, value := range newMap {
+		if extensionID, ok := p.extensionKey(key); ok {
+			if extMap, ok := asMap(value); ok {
+				convertedExt := make(map[string]interface{}, len(extMap))
+				for extKey, extValue := range extMap {
+					call := hookCall{op: newAttributeHookOperation(op.Op, extensionID, extKey, extValue, data), attribute: true}
+					if err := p.callBefore(ctx, &call); err != nil {
+						return op, nil, err
+					}
+					calls = append(calls, call)
+					convertedExt[extKey] = call.op.Value
+				}
+				converted[key] = convertedExt
+				continue
+			}
+		}
+		// "urn:...:User:department" のように拡張スキーマの URI で修飾されたキーは、URI に含まれるドットで分割しないよう先に解決します
+		schemaURI, attrKey := "", key
+		if extension, attrName, ok := p.splitURNQualifiedKey(key); ok {
+			schemaURI, attrKey = extension.ID, attrName
+		} else {
+			attrName, _, _ := strings.Cut(key, ".")
+			schemaURI = p.schemaURIOf(nil, attrName)
+		}
+		call := hookCall{op: newAttributeHookOperation(op.Op, schemaURI, attrKey, value, data), attribute: true}
+		if err := p.callBefore(ctx, &call); err != nil {
+			return op, nil, err
+		}
+		calls = append(calls, call)
+		converted[key] = call.op.Value
+	}
+	op.Value = converted
+	return op, calls, nil
+}
+
+// newAttributeHookOperation は path 未指定の value に含まれる key (ドット区切りを含む) の属性に対する HookOperation を作成します。
+func newAttributeHookOperation(opName string, schemaURI string, key string, value interface{}, data map[string]interface{}) HookOperation {
+	attrName, subAttrName, _ := strings.Cut(key, ".")
+	return HookOperation{
+		Op:           opName,
+		SchemaURI:    schemaURI,
+		Attribute:    attrName,
+		SubAttribute: subAttrName,
+		Value:        value,
+		Data:         data,
+	}
+}
+
+func (p *Patcher) callBefore(ctx context.Context, call *hookCall) error {
+	for _, h := range p.hooks {
+		if h.Before == nil || !h.matches(call.op, call.whole, call.attribute) {
+			continue
+		}
+		if err := h.Before(ctx, &call.op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runAfterHooks は runBeforeHooks で Before を呼び出した各 HookOperation について After を呼び出します。
+func (p *Patcher) runAfterHooks(ctx context.Context, calls []hookCall, data map[string]interface{}, changed bool, err error) {
+	for _, call := range calls {
+		call.op.Data = data
+		for _, h := range p.hooks {
+			if h.After == nil || !h.matches(call.op, call.whole, call.attribute) {
+				continue
+			}
+			h.After(ctx, call.op, changed, err)
+		}
+	}
+}
+
+// schemaURIOf は属性が属するスキーマの URI を取得します。
+func (p *Patcher) schemaURIOf(uriPrefix *string, attrName string) string {
+	if uriPrefix != nil {
+		return *uriPrefix
+	}
+	if _, ok := p.schema.Attributes.ContainsAttribute(attrName); ok || strings.EqualFold(attrName, externalIdAttr.Name()) {
+		return p.schema.ID
+	}
+	for id, s := range p.schemas {
+		if _, ok := s.Attributes.ContainsAttribute(attrName); ok {
+			return id
+		}
+	}
+	return ""
+}
@@ -0,0 +1,208 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// errBillingOwner は請求管理者を無効化しようとした場合に Hook が返却するエラーです
+var errBillingOwner = errors.ScimError{
+	ScimType: errors.ScimTypeMutability,
+	Detail:   "The billing owner must not be deactivated.",
+	Status:   400,
+}
+
+// testHooks は TestHooks で利用する Hook です
+var testHooks = []scimpatch.Hook{
+	{
+		// 請求管理者の無効化を拒否します
+		Attribute: "active",
+		Before: func(ctx context.Context, op *scimpatch.HookOperation) error {
+			if op.Op != scim.PatchOperationRemove && op.Value == false && op.Data["billingOwner"] == true {
+				return errBillingOwner
+			}
+			return nil
+		},
+	},
+	{
+		// 電話番号からハイフンを取り除きます
+		Attribute: "phoneNumbers",
+		Before: func(ctx context.Context, op *scimpatch.HookOperation) error {
+			if s, ok := op.Value.(string); ok && op.SubAttribute == "value" {
+				op.Value = strings.ReplaceAll(s, "-", "")
+			}
+			return nil
+		},
+	},
+	{
+		// 拡張スキーマの属性は URI で修飾して指定できます
+		Attribute: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department",
+		Before: func(ctx context.Context, op *scimpatch.HookOperation) error {
+			if s, ok := op.Value.(string); ok {
+				op.Value = strings.ToUpper(s)
+			}
+			return nil
+		},
+	},
+}
+
+// TestHooks は Hook による op の拒否と value の書き換えをテストします
+func TestHooks(t *testing.T) {
+	testCases := []struct {
+		name          string
+		op            scim.PatchOperation
+		data          map[string]interface{}
+		expected      map[string]interface{}
+		expectedError error
+	}{
+		{
+			name:          "veto with path",
+			op:            scim.PatchOperation{Op: "replace", Path: path(`active`), Value: false},
+			data:          map[string]interface{}{"active": true, "billingOwner": true},
+			expected:      map[string]interface{}{"active": true, "billingOwner": true},
+			expectedError: errBillingOwner,
+		},
+		{
+			name:          "veto without path",
+			op:            scim.PatchOperation{Op: "replace", Value: map[string]interface{}{"active": false, "title": "Manager"}},
+			data:          map[string]interface{}{"active": true, "billingOwner": true},
+			expected:      map[string]interface{}{"active": true, "billingOwner": true},
+			expectedError: errBillingOwner,
+		},
+		{
+			name:     "not vetoed",
+			op:       scim.PatchOperation{Op: "replace", Path: path(`active`), Value: false},
+			data:     map[string]interface{}{"active": true},
+			expected: map[string]interface{}{"active": false},
+		},
+		{
+			name: "rewrite sub attribute with filter",
+			op:   scim.PatchOperation{Op: "replace", Path: path(`phoneNumbers[type eq "work"].value`), Value: "03-1234-5678"},
+			data: map[string]interface{}{
+				"phoneNumbers": []interface{}{
+					map[string]interface{}{"type": "work", "value": "0300000000"},
+				},
+			},
+			expected: map[string]interface{}{
+				"phoneNumbers": []map[string]interface{}{
+					{"type": "work", "value": "0312345678"},
+				},
+			},
+		},
+		{
+			name: "rewrite extension attribute without path",
+			op: scim.PatchOperation{Op: "add", Value: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"department": "sales"},
+			}},
+			data: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"department": "SALES"},
+			},
+		},
+		{
+			name:     "core attribute with the same name is not rewritten",
+			op:       scim.PatchOperation{Op: "add", Path: path(`title`), Value: "department"},
+			data:     map[string]interface{}{},
+			expected: map[string]interface{}{"title": "department"},
+		},
+	}
+
+	patcher := scimpatch.NewPatcher(
+		schema.CoreUserSchema(),
+		[]schema.Schema{schema.ExtensionEnterpriseUser()},
+		&scimpatch.PatcherOpts{Hooks: testHooks},
+	)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, _, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if err != tc.expectedError {
+				t.Fatalf("error:\n    actual  : %v\n    expected: %v", err, tc.expectedError)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+// TestHooksURIQualifiedKey は path 未指定の value に拡張スキーマの URI で修飾されたキーが含まれる場合に、
+// Hook に拡張スキーマの URI と属性名が渡されることをテストします
+func TestHooksURIQualifiedKey(t *testing.T) {
+	var actual []scimpatch.HookOperation
+	patcher := scimpatch.NewPatcher(
+		schema.CoreUserSchema(),
+		[]schema.Schema{schema.ExtensionEnterpriseUser()},
+		&scimpatch.PatcherOpts{Hooks: []scimpatch.Hook{{
+			Attribute: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department",
+			Before: func(ctx context.Context, op *scimpatch.HookOperation) error {
+				actual = append(actual, scimpatch.HookOperation{Op: op.Op, SchemaURI: op.SchemaURI, Attribute: op.Attribute, SubAttribute: op.SubAttribute, Value: op.Value})
+				return nil
+			},
+		}}},
+	)
+
+	op := scim.PatchOperation{Op: "add", Value: map[string]interface{}{
+		"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department": "Sales",
+	}}
+	if _, _, err := patcher.Apply(context.TODO(), op, map[string]interface{}{}); err != nil {
+		t.Fatalf("Apply() returned an unexpected error: %v", err)
+	}
+	expected := []scimpatch.HookOperation{{
+		Op:        "add",
+		SchemaURI: "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User",
+		Attribute: "department",
+		Value:     "Sales",
+	}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("hook operations:\n    actual  : %v\n    expected: %v", actual, expected)
+	}
+}
+
+// TestHooksAfter は After に適用結果が渡されることをテストします
+func TestHooksAfter(t *testing.T) {
+	type observed struct {
+		attribute string
+		value     interface{}
+		changed   bool
+		err       error
+	}
+	var actual []observed
+	after := func(ctx context.Context, op scimpatch.HookOperation, changed bool, err error) {
+		actual = append(actual, observed{attribute: op.Attribute, value: op.Value, changed: changed, err: err})
+	}
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+		Hooks: []scimpatch.Hook{
+			{After: after},
+			{Attribute: "displayName", After: after},
+		},
+	})
+
+	data := map[string]interface{}{"displayName": "Alice"}
+	ops := []scim.PatchOperation{
+		{Op: "replace", Path: path(`displayName`), Value: "Bob"},
+		{Op: "replace", Value: map[string]interface{}{"displayName": "Bob"}},
+		{Op: "remove", Path: path(`unknown`)},
+	}
+	for _, op := range ops {
+		data, _, _ = patcher.Apply(context.TODO(), op, data)
+	}
+
+	expected := []observed{
+		{attribute: "displayName", value: "Bob", changed: true},
+		{attribute: "displayName", value: "Bob", changed: true},
+		{attribute: "", value: map[string]interface{}{"displayName": "Bob"}, changed: false},
+		{attribute: "displayName", value: "Bob", changed: false},
+		{attribute: "unknown", changed: false, err: errors.ScimErrorInvalidPath},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("observed:\n    actual  : %v\n    expected: %v", actual, expected)
+	}
+}
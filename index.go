@@ -0,0 +1,99 @@
+package scimpatch
+
+import "encoding/json"
+
+// identityKey は複数値属性の要素を識別するためのサブ属性名です。
+// cf. https://datatracker.ietf.org/doc/html/rfc7643#section-2.4
+const identityKey = "value"
+
+// mapIndex は複数値属性の要素を identityKey の値で索引付けしたものです。
+// 要素の比較 (eqMap, isSubMap) は identityKey の値が等しい要素の間でのみ行えばよいため、
+// 要素数 n の複数値属性に m 個の要素を追加する際の比較回数を O(n·m) から O(n+m) 程度に抑えることができます。
+type mapIndex struct {
+	buckets map[interface{}][]map[string]interface{}
+	// unkeyed は identityKey を持たない、もしくは map のキーとして利用できない値を持つ要素です
+	unkeyed []map[string]interface{}
+}
+
+func newMapIndex(maps []map[string]interface{}) *mapIndex {
+	idx := &mapIndex{buckets: make(map[interface{}][]map[string]interface{}, len(maps))}
+	for _, m := range maps {
+		idx.add(m)
+	}
+	return idx
+}
+
+// add は item を索引に追加します。
+func (idx *mapIndex) add(item map[string]interface{}) {
+	key, ok := indexKey(item)
+	if !ok {
+		idx.unkeyed = append(idx.unkeyed, item)
+		return
+	}
+	idx.buckets[key] = append(idx.buckets[key], item)
+}
+
+// contains は索引に item と等しい要素が含まれているかを確認します。
+func (idx *mapIndex) contains(item map[string]interface{}) bool {
+	candidates := idx.unkeyed
+	if key, ok := indexKey(item); ok {
+		candidates = idx.buckets[key]
+	}
+	return containsMap(candidates, item)
+}
+
+// containsSubMapOf は索引の要素のうち、属性をすべて item が同じ値で保持している要素が存在するかを確認します。
+func (idx *mapIndex) containsSubMapOf(item map[string]interface{}) bool {
+	if key, ok := indexKey(item); ok && containsSubMap(idx.buckets[key], item) {
+		return true
+	}
+	return containsSubMap(idx.unkeyed, item)
+}
+
+// indexKey は item の identityKey の値を索引のキーとして取得します。
+func indexKey(item map[string]interface{}) (interface{}, bool) {
+	value, ok := item[identityKey]
+	if !ok {
+		return nil, false
+	}
+	return hashableValue(value)
+}
+
+// hashableValue は value が map のキーとして利用できる型の場合に、その値を返却します。
+func hashableValue(value interface{}) (interface{}, bool) {
+	switch value.(type) {
+	case string, bool, float64, float32, int, int64, int32, json.Number:
+		return value, true
+	}
+	return nil, false
+}
+
+// itemSet は単一値の複数値属性の要素の集合です。
+type itemSet struct {
+	hashed map[interface{}]struct{}
+	others []interface{}
+}
+
+func newItemSet(items []interface{}) *itemSet {
+	s := &itemSet{hashed: make(map[interface{}]struct{}, len(items))}
+	for _, item := range items {
+		s.add(item)
+	}
+	return s
+}
+
+func (s *itemSet) add(item interface{}) {
+	if key, ok := hashableValue(item); ok {
+		s.hashed[key] = struct{}{}
+		return
+	}
+	s.others = append(s.others, item)
+}
+
+func (s *itemSet) contains(item interface{}) bool {
+	if key, ok := hashableValue(item); ok {
+		_, ok := s.hashed[key]
+		return ok
+	}
+	return containsItem(s.others, item)
+}
@@ -0,0 +1,111 @@
+package scimpatch
+
+import (
+	"context"
+	"time"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+)
+
+// Instrumentation は Patcher が各 op の適用前後に呼び出す、トレースやメトリクスのためのインターフェースです。
+// StartOperation は op の適用前に呼び出され、返却した context は op の適用中と EndOperation で利用されます。
+// EndOperation は op の適用後に、適用結果とともに呼び出されます。
+type Instrumentation interface {
+	StartOperation(ctx context.Context, info OperationInfo) context.Context
+	EndOperation(ctx context.Context, info OperationInfo, result OperationResult)
+}
+
+// PathShape は op の path の形式です。属性名などを含まないため、メトリクスの属性として利用できます。
+type PathShape string
+
+const (
+	// PathShapeNone は path 未指定の op です
+	PathShapeNone PathShape = "none"
+	// PathShapeAttribute は `attr` 形式の path です
+	PathShapeAttribute PathShape = "attr"
+	// PathShapeSubAttribute は `attr.sub` 形式の path です
+	PathShapeSubAttribute PathShape = "attr.sub"
+	// PathShapeFilter は `attr[filter]` 形式の path です
+	PathShapeFilter PathShape = "attr[filter]"
+	// PathShapeFilterSubAttribute は `attr[filter].sub` 形式の path です
+	PathShapeFilterSubAttribute PathShape = "attr[filter].sub"
+)
+
+// OperationInfo は Instrumentation に渡される op の情報です。
+type OperationInfo struct {
+	// Op は小文字に変換された op です
+	Op string
+	// Path は op の path です。path 未指定の op の場合は空文字です
+	Path string
+	// PathShape は op の path の形式です
+	PathShape PathShape
+	// Attribute は path の属性名です。path 未指定の op の場合は空文字です
+	Attribute string
+	// Profile は PatcherOpts.Profile に指定されたプロファイルの名前です。IdP 毎の集計に利用できます
+	Profile string
+}
+
+// OperationResult は op の適用結果です。
+type OperationResult struct {
+	Changed bool
+	Err     error
+	// ScimType は Err が errors.ScimError の場合の scimType です
+	ScimType errors.ScimType
+	Duration time.Duration
+}
+
+type noopInstrumentation struct{}
+
+func (noopInstrumentation) StartOperation(ctx context.Context, info OperationInfo) context.Context {
+	return ctx
+}
+
+func (noopInstrumentation) EndOperation(ctx context.Context, info OperationInfo, result OperationResult) {
+}
+
+// newOperationInfo は op から OperationInfo を作成します。
+func (p *Patcher) newOperationInfo(op scim.PatchOperation) OperationInfo {
+	info := OperationInfo{Op: op.Op, PathShape: PathShapeNone}
+	if p.profile != nil {
+		info.Profile = p.profile.Name
+	}
+	if op.Path == nil {
+		return info
+	}
+	info.Path = op.Path.String()
+	info.Attribute = op.Path.AttributePath.AttributeName
+	switch {
+	case op.Path.ValueExpression != nil && op.Path.SubAttribute != nil:
+		info.PathShape = PathShapeFilterSubAttribute
+	case op.Path.ValueExpression != nil:
+		info.PathShape = PathShapeFilter
+	case op.Path.AttributePath.SubAttribute != nil:
+		info.PathShape = PathShapeSubAttribute
+	default:
+		info.PathShape = PathShapeAttribute
+	}
+	return info
+}
+
+// instrument は Instrumentation の呼び出しで f を囲みます。
+func (p *Patcher) instrument(
+	ctx context.Context,
+	op scim.PatchOperation,
+	f func(ctx context.Context) (map[string]interface{}, bool, error),
+) (map[string]interface{}, bool, error) {
+	switch p.instrumentation.(type) {
+	case nil, noopInstrumentation:
+		return f(ctx)
+	}
+	info := p.newOperationInfo(op)
+	ctx = p.instrumentation.StartOperation(ctx, info)
+	start := time.Now()
+	data, changed, err := f(ctx)
+	result := OperationResult{Changed: changed, Err: err, Duration: time.Since(start)}
+	if scimErr, ok := err.(errors.ScimError); ok {
+		result.ScimType = scimErr.ScimType
+	}
+	p.instrumentation.EndOperation(ctx, info, result)
+	return data, changed, err
+}
@@ -0,0 +1,82 @@
+package scimpatch_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+type startedKey struct{}
+
+// recordingInstrumentation は EndOperation に渡された内容を記録する Instrumentation です
+type recordingInstrumentation struct {
+	infos   *[]scimpatch.OperationInfo
+	results *[]scimpatch.OperationResult
+}
+
+func (i recordingInstrumentation) StartOperation(ctx context.Context, info scimpatch.OperationInfo) context.Context {
+	return context.WithValue(ctx, startedKey{}, info.Path)
+}
+
+func (i recordingInstrumentation) EndOperation(ctx context.Context, info scimpatch.OperationInfo, result scimpatch.OperationResult) {
+	if ctx.Value(startedKey{}) != info.Path {
+		panic("the context returned by StartOperation is not passed to EndOperation")
+	}
+	*i.infos = append(*i.infos, info)
+	result.Duration = 0
+	*i.results = append(*i.results, result)
+}
+
+// TestInstrumentation は Instrumentation が各 op の前後に呼び出されることをテストします
+func TestInstrumentation(t *testing.T) {
+	var infos []scimpatch.OperationInfo
+	var results []scimpatch.OperationResult
+	profile := scimpatch.ProfileOkta
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+		Profile:         &profile,
+		Instrumentation: recordingInstrumentation{infos: &infos, results: &results},
+	})
+
+	data := map[string]interface{}{
+		"emails": []interface{}{map[string]interface{}{"type": "work", "value": "a@example.com"}},
+	}
+	ops := []scim.PatchOperation{
+		{Op: "Replace", Value: map[string]interface{}{"title": "Manager"}},
+		{Op: "replace", Path: path(`title`), Value: "Manager"},
+		{Op: "add", Path: path(`name.givenName`), Value: "Alice"},
+		{Op: "replace", Path: path(`emails[type eq "work"]`), Value: map[string]interface{}{"type": "work", "value": "b@example.com"}},
+		{Op: "replace", Path: path(`emails[type eq "work"].value`), Value: "c@example.com"},
+		{Op: "remove", Path: path(`unknown`)},
+	}
+	for _, op := range ops {
+		data, _, _ = patcher.Apply(context.TODO(), op, data)
+	}
+
+	expectedInfos := []scimpatch.OperationInfo{
+		{Op: "replace", PathShape: scimpatch.PathShapeNone, Profile: "okta"},
+		{Op: "replace", Path: "title", PathShape: scimpatch.PathShapeAttribute, Attribute: "title", Profile: "okta"},
+		{Op: "add", Path: "name.givenName", PathShape: scimpatch.PathShapeSubAttribute, Attribute: "name", Profile: "okta"},
+		{Op: "replace", Path: `emails[type eq "work"]`, PathShape: scimpatch.PathShapeFilter, Attribute: "emails", Profile: "okta"},
+		{Op: "replace", Path: `emails[type eq "work"].value`, PathShape: scimpatch.PathShapeFilterSubAttribute, Attribute: "emails", Profile: "okta"},
+		{Op: "remove", Path: "unknown", PathShape: scimpatch.PathShapeAttribute, Attribute: "unknown", Profile: "okta"},
+	}
+	if !reflect.DeepEqual(infos, expectedInfos) {
+		t.Errorf("infos:\n    actual  : %v\n    expected: %v", infos, expectedInfos)
+	}
+	expectedResults := []scimpatch.OperationResult{
+		{Changed: true},
+		{Changed: false},
+		{Changed: true},
+		{Changed: true},
+		{Changed: true},
+		{Err: errors.ScimErrorInvalidPath, ScimType: errors.ScimTypeInvalidPath},
+	}
+	if !reflect.DeepEqual(results, expectedResults) {
+		t.Errorf("results:\n    actual  : %v\n    expected: %v", results, expectedResults)
+	}
+}
@@ -1,6 +1,12 @@
 package scimpatch
 
-import "context"
+import (
+	"context"
+	"log/slog"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+)
 
 type PatcherLogger interface {
 	Error(args ...interface{})
@@ -34,3 +40,114 @@ func getLogger(ctx context.Context) PatcherLogger {
 	}
 	return l
 }
+
+type slogKey struct{}
+
+// AddSlogLogger adds a *slog.Logger to the context.
+// The Patcher logs structured events with the fields op_index, op, path, attribute and changed.
+// A logger added by AddSlogLogger takes precedence over a PatcherLogger added by AddLogger.
+func AddSlogLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, slogKey{}, logger)
+}
+
+func getSlogLogger(ctx context.Context) *slog.Logger {
+	l, _ := ctx.Value(slogKey{}).(*slog.Logger)
+	return l
+}
+
+type logAttrsKey struct{}
+
+// withLogAttrs returns a context whose events are logged with attrs in addition to the attributes already in ctx.
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	parent, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(parent)+len(attrs))
+	merged = append(merged, parent...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, merged)
+}
+
+// logEnabled reports whether an event at level would be logged, so that callers can skip building costly attributes.
+func logEnabled(ctx context.Context, level slog.Level) bool {
+	if l := getSlogLogger(ctx); l != nil {
+		return l.Enabled(ctx, level)
+	}
+	return getLogger(ctx) != noop
+}
+
+// logEvent logs an event with the attributes of ctx.
+// A PatcherLogger receives events at the error level via Error and the others via Debug,
+// with the attributes formatted as "key=value".
+func logEvent(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if !logEnabled(ctx, level) {
+		return
+	}
+	ctxAttrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	all := make([]slog.Attr, 0, len(ctxAttrs)+len(attrs))
+	all = append(all, ctxAttrs...)
+	all = append(all, attrs...)
+
+	if l := getSlogLogger(ctx); l != nil {
+		l.LogAttrs(ctx, level, msg, all...)
+		return
+	}
+	args := make([]interface{}, 0, len(all)+1)
+	args = append(args, msg)
+	for _, attr := range all {
+		args = append(args, attr.String())
+	}
+	if level >= slog.LevelError {
+		getLogger(ctx).Error(args...)
+		return
+	}
+	getLogger(ctx).Debug(args...)
+}
+
+// hasLogger reports whether ctx has any logger, so that the Patcher can skip preparing attributes when nothing is logged.
+func hasLogger(ctx context.Context) bool {
+	return getSlogLogger(ctx) != nil || getLogger(ctx) != noop
+}
+
+// withOperationLogAttrs returns a context whose events are logged with the op, path and attribute of op.
+func withOperationLogAttrs(ctx context.Context, op scim.PatchOperation) context.Context {
+	if !hasLogger(ctx) {
+		return ctx
+	}
+	var path, attribute string
+	if op.Path != nil {
+		path = op.Path.String()
+		attribute = op.Path.AttributePath.AttributeName
+	}
+	return withLogAttrs(ctx, slog.String("op", op.Op), slog.String("path", path), slog.String("attribute", attribute))
+}
+
+// logRewritten logs msg at the debug level when the op, path or value of before was rewritten to after.
+// The values are not logged, since they may contain writeOnly attributes.
+func logRewritten(ctx context.Context, msg string, before scim.PatchOperation, after scim.PatchOperation) {
+	if !logEnabled(ctx, slog.LevelDebug) {
+		return
+	}
+	if before.Op != after.Op {
+		logEvent(ctx, slog.LevelDebug, msg, slog.String("from_op", before.Op), slog.String("to_op", after.Op))
+		return
+	}
+	if before.Path != after.Path || !eqValue(before.Value, after.Value) {
+		logEvent(ctx, slog.LevelDebug, msg)
+	}
+}
+
+// logResult logs the result of an op: a failure at the warn level, a change at the info level and a no-op at the debug level.
+func logResult(ctx context.Context, changed bool, err error) {
+	if err != nil {
+		attrs := []slog.Attr{slog.Bool("changed", changed), slog.Any("error", err)}
+		if scimErr, ok := err.(errors.ScimError); ok {
+			attrs = append(attrs, slog.String("scim_type", string(scimErr.ScimType)))
+		}
+		logEvent(ctx, slog.LevelWarn, "operation failed", attrs...)
+		return
+	}
+	if changed {
+		logEvent(ctx, slog.LevelInfo, "operation applied", slog.Bool("changed", true))
+		return
+	}
+	logEvent(ctx, slog.LevelDebug, "operation did not change the resource", slog.Bool("changed", false))
+}
@@ -0,0 +1,76 @@
+package scimpatch_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestSlogLogger は AddSlogLogger で追加した *slog.Logger に構造化されたイベントが出力されることをテストします
+func TestSlogLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx := scimpatch.AddSlogLogger(context.TODO(), logger)
+
+	profile := scimpatch.ProfileOkta
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{Profile: &profile})
+	ops := []scim.PatchOperation{
+		{Op: "Replace", Path: path(`title`), Value: "Manager"},
+		{Op: "replace", Path: path(`title`), Value: "Manager"},
+		{Op: "replace", Value: map[string]interface{}{"id": "0001", "title": "Manager"}},
+		{Op: "add", Value: "Manager"},
+		{Op: "remove", Path: path(`unknown`)},
+	}
+	_, _, err := patcher.ApplyOperations(ctx, ops, map[string]interface{}{})
+	if err == nil {
+		t.Fatalf("ApplyOperations() should return an error")
+	}
+
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("failed to decode log: %v", err)
+		}
+		records = append(records, record)
+	}
+
+	expected := []map[string]interface{}{
+		{"level": "DEBUG", "msg": "applying operation", "op_index": 0.0, "op": "replace", "path": "title", "attribute": "title", "value": "Manager"},
+		{"level": "INFO", "msg": "operation applied", "op_index": 0.0, "op": "replace", "path": "title", "attribute": "title", "changed": true},
+		{"level": "DEBUG", "msg": "operation did not change the resource", "op_index": 1.0, "changed": false},
+		{"level": "DEBUG", "msg": "read-only key skipped by profile", "op_index": 2.0, "key": "id", "profile": "okta"},
+		{"level": "DEBUG", "msg": "operation coerced by profile", "op_index": 2.0, "op": "replace", "path": "", "attribute": ""},
+		{"level": "WARN", "msg": "value without path is not an object; operation ignored", "op_index": 3.0, "op": "add"},
+		{"level": "WARN", "msg": "operation failed", "op_index": 4.0, "op": "remove", "path": "unknown", "scim_type": "invalidPath", "changed": false},
+	}
+	for _, e := range expected {
+		if !containsRecord(records, e) {
+			t.Errorf("the log does not contain %v\n    logs: %s", e, buf.String())
+		}
+	}
+}
+
+// containsRecord は records に expected のすべてのフィールドを含むレコードがあるかを判断します
+func containsRecord(records []map[string]interface{}, expected map[string]interface{}) bool {
+	for _, record := range records {
+		matched := true
+		for k, v := range expected {
+			if record[k] != v {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
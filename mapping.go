@@ -0,0 +1,222 @@
+package scimpatch
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/elimity-com/scim"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// Mapping は SCIM スキーマ上の属性パスとアプリケーションのフィールドの対応を表します。
+// Path には PATCH の path と同じ記法 (e.g. `emails[type eq "work"].value`) を指定します。
+// Transform を指定した場合は、解決された値を変換してからフィールドの値とします。
+type Mapping struct {
+	Path      string
+	Field     string
+	Transform func(value interface{}) (interface{}, error)
+}
+
+// FieldChange はアプリケーションのフィールド単位の変更です。
+// 変更前後で値が存在しない場合は、それぞれ Old, New が nil となります。
+// writeOnly の属性 (e.g. password) が変更された場合は、Old, New を含めずに Redacted が true となります。
+type FieldChange struct {
+	Field    string
+	Path     string
+	Old      interface{}
+	New      interface{}
+	Redacted bool
+}
+
+// Mapper は Mapping の集合を利用して、SCIM のリソースとアプリケーションのレコードを対応付けます。
+type Mapper struct {
+	mappings []compiledMapping
+}
+
+type compiledMapping struct {
+	Mapping
+	path filter.Path
+}
+
+// NewMapper は Mapper の実態を取得します。
+// Path が解釈できない Mapping や Field が重複している Mapping が含まれる場合はエラーを返却します。
+func NewMapper(mappings []Mapping) (*Mapper, error) {
+	fields := map[string]struct{}{}
+	compiled := make([]compiledMapping, 0, len(mappings))
+	for _, m := range mappings {
+		if _, ok := fields[m.Field]; ok {
+			return nil, fmt.Errorf("scimpatch: duplicate mapping field %q", m.Field)
+		}
+		fields[m.Field] = struct{}{}
+		p, err := filter.ParsePath([]byte(m.Path))
+		if err != nil {
+			return nil, fmt.Errorf("scimpatch: invalid mapping path %q: %w", m.Path, err)
+		}
+		compiled = append(compiled, compiledMapping{Mapping: m, path: p})
+	}
+	return &Mapper{mappings: compiled}, nil
+}
+
+// Record は SCIM のリソースからアプリケーションのレコードを作成します。
+// リソース上に値が存在しない属性に対応するフィールドはレコードに含まれません。
+func (m *Mapper) Record(resource map[string]interface{}) (map[string]interface{}, error) {
+	record := map[string]interface{}{}
+	for _, mapping := range m.mappings {
+		value, ok, err := mapping.value(resource)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			record[mapping.Field] = value
+		}
+	}
+	return record, nil
+}
+
+// Changes は変更前後の SCIM のリソースを比較し、値が変わったフィールドを Mapping の定義順で返却します。
+// p のスキーマで writeOnly の属性 (e.g. password) の変更は、Old, New を含めずに Redacted が true となります。
+func (m *Mapper) Changes(p *Patcher, before map[string]interface{}, after map[string]interface{}) ([]FieldChange, error) {
+	if p == nil {
+		return nil, errNilPatcher
+	}
+	return m.changes(p.snapshot(), before, after, true)
+}
+
+// errNilPatcher は writeOnly の属性を判断するための Patcher が指定されていない場合のエラーです。
+var errNilPatcher = fmt.Errorf("scimpatch: Patcher is required to detect writeOnly attributes")
+
+// changes は Changes の実装です。p のスキーマで writeOnly の属性の変更は Redacted が true となり、
+// redact が true の場合は Old, New を含めません。
+func (m *Mapper) changes(p *Patcher, before map[string]interface{}, after map[string]interface{}, redact bool) ([]FieldChange, error) {
+	changes := []FieldChange{}
+	for _, mapping := range m.mappings {
+		oldValue, _, err := mapping.value(before)
+		if err != nil {
+			return nil, err
+		}
+		newValue, _, err := mapping.value(after)
+		if err != nil {
+			return nil, err
+		}
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		writeOnly := p.writeOnlyPath(&mapping.path)
+		if writeOnly && redact {
+			changes = append(changes, FieldChange{Field: mapping.Field, Path: mapping.Path, Redacted: true})
+			continue
+		}
+		changes = append(changes, FieldChange{
+			Field:    mapping.Field,
+			Path:     mapping.Path,
+			Old:      oldValue,
+			New:      newValue,
+			Redacted: writeOnly,
+		})
+	}
+	return changes, nil
+}
+
+// Apply は p を利用して ops を data に適用し、更新後のリソースとフィールド単位の変更を返却します。
+// data は変更前の状態を比較に利用するため複製されたうえで更新されます。
+// writeOnly の属性の変更は値を含めずに返却されます。
+func (m *Mapper) Apply(ctx context.Context, p *Patcher, ops []scim.PatchOperation, data map[string]interface{}) (map[string]interface{}, []FieldChange, error) {
+	p = p.snapshot()
+	before := copyMap(data)
+	after, _, err := p.ApplyOperations(ctx, ops, copyMap(data))
+	if err != nil {
+		return data, nil, err
+	}
+	changes, err := m.changes(p, before, after, true)
+	if err != nil {
+		return data, nil, err
+	}
+	return after, changes, nil
+}
+
+// value は Mapping の Path を解決して、変換後の値を返却します。
+func (m compiledMapping) value(resource map[string]interface{}) (interface{}, bool, error) {
+	value, ok := resolvePathValue(resource, m.path)
+	if !ok {
+		return nil, false, nil
+	}
+	if m.Transform == nil {
+		return value, true, nil
+	}
+	transformed, err := m.Transform(value)
+	if err != nil {
+		return nil, false, fmt.Errorf("scimpatch: failed to transform %q: %w", m.Path, err)
+	}
+	return transformed, true, nil
+}
+
+// resolvePathValue は path が指し示す値を data から取得します。
+// path に valFilter が指定されている場合は、最初に条件に一致した要素を対象とします。
+func resolvePathValue(data map[string]interface{}, path filter.Path) (interface{}, bool) {
+	scoped := data
+	if path.AttributePath.URIPrefix != nil {
+		_, uriScoped, ok := lookupKey(data, *path.AttributePath.URIPrefix)
+		if !ok {
+			return nil, false
+		}
+		if scoped, ok = uriScoped.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+
+	_, value, ok := lookupKey(scoped, path.AttributePath.AttributeName)
+	if !ok {
+		return nil, false
+	}
+
+	if path.ValueExpression != nil {
+		items, ok := areEveryItemsMap(value)
+		if !ok {
+			return nil, false
+		}
+		found := false
+		for _, item := range items {
+			if isMatchExpression(item, path.ValueExpression) {
+				value, found = item, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	for _, subAttr := range []*string{path.AttributePath.SubAttribute, path.SubAttribute} {
+		if subAttr == nil {
+			continue
+		}
+		if value, ok = resolveSubAttributeValue(value, *subAttr); !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// resolveSubAttributeValue は value からサブ属性の値を取得します。
+// value が複数値の場合は、各要素のサブ属性の値をスライスとして返却します。
+func resolveSubAttributeValue(value interface{}, subAttr string) (interface{}, bool) {
+	if m, ok := value.(map[string]interface{}); ok {
+		_, v, ok := lookupKey(m, subAttr)
+		return v, ok
+	}
+	items, ok := areEveryItemsMap(value)
+	if !ok {
+		return nil, false
+	}
+	values := []interface{}{}
+	for _, item := range items {
+		if v, ok := resolveSubAttributeValue(item, subAttr); ok {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values, true
+}
@@ -0,0 +1,186 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+var testMappings = []scimpatch.Mapping{
+	{Path: `userName`, Field: "login"},
+	{Path: `name.familyName`, Field: "family_name"},
+	{Path: `emails[type eq "work"].value`, Field: "work_email", Transform: func(v interface{}) (interface{}, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected type %T", v)
+		}
+		return strings.ToLower(s), nil
+	}},
+	{Path: `urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department`, Field: "department"},
+}
+
+// TestMapperRecord は Mapper.Record をテストします
+func TestMapperRecord(t *testing.T) {
+	testCases := []struct {
+		name     string
+		resource map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "empty",
+			resource: map[string]interface{}{},
+			expected: map[string]interface{}{},
+		},
+		{
+			name: "every attributes",
+			resource: map[string]interface{}{
+				"userName": "alice",
+				"name": map[string]interface{}{
+					"familyName": "Green",
+				},
+				"emails": []interface{}{
+					map[string]interface{}{"type": "home", "value": "alice@example.net"},
+					map[string]interface{}{"type": "work", "value": "Alice@Example.com"},
+				},
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+					"department": "Sales",
+				},
+			},
+			expected: map[string]interface{}{
+				"login":       "alice",
+				"family_name": "Green",
+				"work_email":  "alice@example.com",
+				"department":  "Sales",
+			},
+		},
+		{
+			name: "filter not matched",
+			resource: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{"type": "home", "value": "alice@example.net"},
+				},
+			},
+			expected: map[string]interface{}{},
+		},
+	}
+
+	mapper, err := scimpatch.NewMapper(testMappings)
+	if err != nil {
+		t.Fatalf("NewMapper() returned an unexpected error: %v", err)
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := mapper.Record(tc.resource)
+			if err != nil {
+				t.Fatalf("Record() returned an unexpected error: %v", err)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("record:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+// TestMapperApply は Mapper.Apply で取得されるフィールド単位の変更をテストします
+func TestMapperApply(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ops      []scim.PatchOperation
+		data     map[string]interface{}
+		expected []scimpatch.FieldChange
+	}{
+		{
+			name: "replace work email",
+			ops: []scim.PatchOperation{
+				{Op: "replace", Path: path(`emails[type eq "work"].value`), Value: "Bob@Example.com"},
+			},
+			data: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{"type": "work", "value": "alice@example.com"},
+				},
+			},
+			expected: []scimpatch.FieldChange{
+				{Field: "work_email", Path: `emails[type eq "work"].value`, Old: "alice@example.com", New: "bob@example.com"},
+			},
+		},
+		{
+			name: "add and remove",
+			ops: []scim.PatchOperation{
+				{Op: "add", Path: path(`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department`), Value: "Sales"},
+				{Op: "remove", Path: path(`name.familyName`)},
+			},
+			data: map[string]interface{}{
+				"name": map[string]interface{}{
+					"familyName": "Green",
+				},
+			},
+			expected: []scimpatch.FieldChange{
+				{Field: "family_name", Path: `name.familyName`, Old: "Green", New: nil},
+				{Field: "department", Path: `urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department`, Old: nil, New: "Sales"},
+			},
+		},
+		{
+			name: "not changed",
+			ops: []scim.PatchOperation{
+				{Op: "replace", Path: path(`userName`), Value: "alice"},
+			},
+			data: map[string]interface{}{
+				"userName": "alice",
+			},
+			expected: []scimpatch.FieldChange{},
+		},
+	}
+
+	mapper, err := scimpatch.NewMapper(testMappings)
+	if err != nil {
+		t.Fatalf("NewMapper() returned an unexpected error: %v", err)
+	}
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), []schema.Schema{schema.ExtensionEnterpriseUser()}, nil)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := fmt.Sprint(tc.data)
+			_, changes, err := mapper.Apply(context.TODO(), patcher, tc.ops, tc.data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if fmt.Sprint(changes) != fmt.Sprint(tc.expected) {
+				t.Errorf("changes:\n    actual  : %v\n    expected: %v", changes, tc.expected)
+			}
+			if fmt.Sprint(tc.data) != before {
+				t.Errorf("data must not be mutated:\n    actual  : %v\n    expected: %v", tc.data, before)
+			}
+		})
+	}
+}
+
+// TestNewMapperError は NewMapper の異常系をテストします
+func TestNewMapperError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mappings []scimpatch.Mapping
+	}{
+		{
+			name:     "invalid path",
+			mappings: []scimpatch.Mapping{{Path: `emails[type eq`, Field: "email"}},
+		},
+		{
+			name: "duplicate field",
+			mappings: []scimpatch.Mapping{
+				{Path: `userName`, Field: "login"},
+				{Path: `displayName`, Field: "login"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := scimpatch.NewMapper(tc.mappings); err == nil {
+				t.Errorf("NewMapper() must return error")
+			}
+		})
+	}
+}
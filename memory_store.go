@@ -0,0 +1,70 @@
+package scimpatch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/elimity-com/scim/errors"
+)
+
+// MemoryStore はリソースをメモリ上に保持する ResourceStore の実装です。
+// テストや動作確認での利用を想定しています。
+type MemoryStore struct {
+	mu        sync.Mutex
+	resources map[string]memoryResource
+	counter   int
+}
+
+type memoryResource struct {
+	data    map[string]interface{}
+	version string
+}
+
+// NewMemoryStore は MemoryStore の実態を取得します。
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{resources: map[string]memoryResource{}}
+}
+
+// Set はバージョンにかかわらずリソースを保存し、新しいバージョンを返却します。
+func (s *MemoryStore) Set(resourceType string, id string, data map[string]interface{}) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.set(resourceType, id, data)
+}
+
+// Get は保存されているリソースの複製とバージョンを返却します。
+func (s *MemoryStore) Get(ctx context.Context, resourceType string, id string) (map[string]interface{}, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resource, ok := s.resources[memoryStoreKey(resourceType, id)]
+	if !ok {
+		return nil, "", errors.ScimErrorResourceNotFound(id)
+	}
+	return copyMap(resource.data), resource.version, nil
+}
+
+// Put はリソースのバージョンが version と一致する場合のみリソースを保存し、新しいバージョンを返却します。
+func (s *MemoryStore) Put(ctx context.Context, resourceType string, id string, data map[string]interface{}, version string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resource, ok := s.resources[memoryStoreKey(resourceType, id)]
+	if !ok {
+		return "", errors.ScimErrorResourceNotFound(id)
+	}
+	if resource.version != version {
+		return "", ScimErrorPreconditionFailed
+	}
+	return s.set(resourceType, id, data), nil
+}
+
+func (s *MemoryStore) set(resourceType string, id string, data map[string]interface{}) string {
+	s.counter++
+	version := fmt.Sprintf("W/\"%d\"", s.counter)
+	s.resources[memoryStoreKey(resourceType, id)] = memoryResource{data: copyMap(data), version: version}
+	return version
+}
+
+func memoryStoreKey(resourceType string, id string) string {
+	return resourceType + "/" + id
+}
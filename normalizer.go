@@ -0,0 +1,208 @@
+package scimpatch
+
+import (
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// Normalizer は op の value を正規化する関数です。
+// 複数値属性の場合は要素毎に呼び出されます。正規化できない値はそのまま返却してください。
+type Normalizer func(value interface{}) interface{}
+
+// NormalizeLowercase は文字列を小文字に変換する Normalizer です。 e.g. emails.value
+func NormalizeLowercase(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		return strings.ToLower(s)
+	}
+	return value
+}
+
+// NormalizeUppercase は文字列を大文字に変換する Normalizer です。 e.g. addresses.country (ISO 3166-1 alpha-2)
+func NormalizeUppercase(value interface{}) interface{} {
+	if s, ok := value.(string); ok {
+		return strings.ToUpper(s)
+	}
+	return value
+}
+
+// NormalizeE164 は電話番号を E.164 形式 (e.g. "+81312345678") に変換する Normalizer を取得します。
+// 空白、ハイフン、括弧、ピリオドを取り除き、国際プレフィックス "00" は "+" に変換します。
+// defaultCountryCode (e.g. "81") を指定すると、"0" から始まる国内形式の番号に国番号を付与します。
+// 変換できない番号はそのまま返却します。
+func NormalizeE164(defaultCountryCode string) Normalizer {
+	return func(value interface{}) interface{} {
+		s, ok := value.(string)
+		if !ok {
+			return value
+		}
+		stripped := strings.Map(func(r rune) rune {
+			switch r {
+			case ' ', '-', '(', ')', '.', '\t':
+				return -1
+			}
+			return r
+		}, s)
+		var digits string
+		switch {
+		case strings.HasPrefix(stripped, "+"):
+			digits = stripped[1:]
+		case strings.HasPrefix(stripped, "00"):
+			digits = stripped[2:]
+		case strings.HasPrefix(stripped, "0") && defaultCountryCode != "":
+			digits = defaultCountryCode + stripped[1:]
+		default:
+			return value
+		}
+		if digits == "" || strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+			return value
+		}
+		return "+" + digits
+	}
+}
+
+// newNormalizers は PatcherOpts.Normalizers のキーを比較しやすいように小文字に変換します。
+func newNormalizers(normalizers map[string]Normalizer) map[string]Normalizer {
+	if len(normalizers) == 0 {
+		return nil
+	}
+	lowered := make(map[string]Normalizer, len(normalizers))
+	for key, n := range normalizers {
+		lowered[strings.ToLower(key)] = n
+	}
+	return lowered
+}
+
+// normalizerFor は属性のパス (e.g. "emails.value") に対する Normalizer を取得します。
+// スキーマの URI で修飾されたキー、修飾されていないキーの順に探します。
+func (p *Patcher) normalizerFor(schemaURI string, attrPath string) (Normalizer, bool) {
+	if schemaURI != "" {
+		if n, ok := p.normalizers[strings.ToLower(schemaURI+":"+attrPath)]; ok {
+			return n, true
+		}
+	}
+	n, ok := p.normalizers[strings.ToLower(attrPath)]
+	return n, ok
+}
+
+// applyNormalizers は op の value と path のフィルタの比較値を正規化します。
+// 呼び出し元の value と path は変更せず、正規化した値で置き換えた op を返却します。
+func (p *Patcher) applyNormalizers(op scim.PatchOperation) scim.PatchOperation {
+	if len(p.normalizers) == 0 {
+		return op
+	}
+
+	if op.Path == nil {
+		newMap, ok := asMap(op.Value)
+		if !ok {
+			return op
+		}
+		normalized := make(map[string]interface{}, len(newMap))
+		for key, value := range newMap {
+			if extensionID, ok := p.extensionKey(key); ok {
+				if extMap, ok := asMap(value); ok {
+					normalizedExt := make(map[string]interface{}, len(extMap))
+					for extKey, extValue := range extMap {
+						normalizedExt[extKey] = p.normalizeAttribute(extensionID, extKey, extValue)
+					}
+					normalized[key] = normalizedExt
+					continue
+				}
+			}
+			attrName, _, _ := strings.Cut(key, ".")
+			normalized[key] = p.normalizeAttribute(p.schemaURIOf(nil, attrName), key, value)
+		}
+		op.Value = normalized
+		return op
+	}
+
+	attrName := op.Path.AttributePath.AttributeName
+	schemaURI := p.schemaURIOf(op.Path.AttributePath.URIPrefix, attrName)
+	attrPath := attrName
+	if op.Path.AttributePath.SubAttribute != nil {
+		attrPath += "." + *op.Path.AttributePath.SubAttribute
+	} else if op.Path.SubAttribute != nil {
+		attrPath += "." + *op.Path.SubAttribute
+	}
+	if op.Op != scim.PatchOperationRemove {
+		op.Value = p.normalizeAttribute(schemaURI, attrPath, op.Value)
+	}
+	if op.Path.ValueExpression != nil {
+		path := *op.Path
+		path.ValueExpression = p.normalizeExpression(schemaURI, attrName, path.ValueExpression)
+		op.Path = &path
+	}
+	return op
+}
+
+// normalizeAttribute は attrPath の属性の value を正規化します。
+// 複数値属性の場合は要素毎に、複合属性の場合はサブ属性毎に正規化します。
+func (p *Patcher) normalizeAttribute(schemaURI string, attrPath string, value interface{}) interface{} {
+	normalizer, ok := p.normalizerFor(schemaURI, attrPath)
+	switch typed := value.(type) {
+	case []interface{}:
+		normalized := make([]interface{}, len(typed))
+		for i, item := range typed {
+			normalized[i] = p.normalizeAttribute(schemaURI, attrPath, item)
+		}
+		return normalized
+	case []map[string]interface{}:
+		normalized := make([]map[string]interface{}, len(typed))
+		for i, item := range typed {
+			m, isMap := asMap(p.normalizeAttribute(schemaURI, attrPath, item))
+			if !isMap {
+				m = item
+			}
+			normalized[i] = m
+		}
+		return normalized
+	case map[string]interface{}:
+		if !strings.Contains(attrPath, ".") {
+			typed = p.normalizeSubAttributes(schemaURI, attrPath, typed)
+		}
+		if ok {
+			return normalizer(typed)
+		}
+		return typed
+	}
+	if ok && value != nil {
+		return normalizer(value)
+	}
+	return value
+}
+
+// normalizeSubAttributes は複合属性の値をサブ属性毎に正規化した map を返却します。
+func (p *Patcher) normalizeSubAttributes(schemaURI string, attrName string, value map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(value))
+	for subAttr, subValue := range value {
+		normalized[subAttr] = p.normalizeAttribute(schemaURI, attrName+"."+subAttr, subValue)
+	}
+	return normalized
+}
+
+// normalizeExpression は attrName の値フィルタの比較値をサブ属性の Normalizer で正規化した Expression を返却します。
+func (p *Patcher) normalizeExpression(schemaURI string, attrName string, expr filter.Expression) filter.Expression {
+	switch e := expr.(type) {
+	case *filter.AttributeExpression:
+		if e.CompareValue == nil || e.AttributePath.SubAttribute != nil {
+			return e
+		}
+		normalizer, ok := p.normalizerFor(schemaURI, attrName+"."+e.AttributePath.AttributeName)
+		if !ok {
+			return e
+		}
+		normalized := *e
+		normalized.CompareValue = normalizer(e.CompareValue)
+		return &normalized
+	case *filter.LogicalExpression:
+		return &filter.LogicalExpression{
+			Left:     p.normalizeExpression(schemaURI, attrName, e.Left),
+			Right:    p.normalizeExpression(schemaURI, attrName, e.Right),
+			Operator: e.Operator,
+		}
+	case *filter.NotExpression:
+		return &filter.NotExpression{Expression: p.normalizeExpression(schemaURI, attrName, e.Expression)}
+	}
+	return expr
+}
@@ -0,0 +1,143 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestNormalizers は Normalizers に指定した Normalizer で value とフィルタの比較値が正規化されることをテストします
+func TestNormalizers(t *testing.T) {
+	testCases := []struct {
+		name            string
+		op              scim.PatchOperation
+		data            map[string]interface{}
+		expected        map[string]interface{}
+		expectedChanged bool
+	}{
+		{
+			name:            "simple attribute",
+			op:              scim.PatchOperation{Op: "replace", Path: path(`userName`), Value: "Alice@Example.com"},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{"userName": "alice@example.com"},
+			expectedChanged: true,
+		},
+		{
+			name: "multi valued complex attribute",
+			op: scim.PatchOperation{Op: "add", Path: path(`emails`), Value: []interface{}{
+				map[string]interface{}{"type": "work", "value": "Alice@Example.com"},
+			}},
+			data: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"emails": []map[string]interface{}{{"type": "work", "value": "alice@example.com"}},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "sub attribute with filter",
+			op:   scim.PatchOperation{Op: "replace", Path: path(`phoneNumbers[type eq "work"].value`), Value: "03-1234-5678"},
+			data: map[string]interface{}{
+				"phoneNumbers": []interface{}{map[string]interface{}{"type": "work", "value": "+81300000000"}},
+			},
+			expected: map[string]interface{}{
+				"phoneNumbers": []map[string]interface{}{{"type": "work", "value": "+81312345678"}},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "filter comparison",
+			op:   scim.PatchOperation{Op: "remove", Path: path(`emails[value eq "Alice@Example.com"]`)},
+			data: map[string]interface{}{
+				"emails": []interface{}{map[string]interface{}{"value": "alice@example.com"}},
+			},
+			expected:        map[string]interface{}{},
+			expectedChanged: true,
+		},
+		{
+			name: "same value after normalization is not changed",
+			op: scim.PatchOperation{Op: "replace", Value: map[string]interface{}{
+				"addresses": []interface{}{map[string]interface{}{"country": "jp"}},
+			}},
+			data: map[string]interface{}{
+				"addresses": []interface{}{map[string]interface{}{"country": "JP"}},
+			},
+			expected: map[string]interface{}{
+				"addresses": []interface{}{map[string]interface{}{"country": "JP"}},
+			},
+			expectedChanged: false,
+		},
+		{
+			name: "extension attribute without path",
+			op: scim.PatchOperation{Op: "add", Value: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"costCenter": "ab-1"},
+			}},
+			data: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"costCenter": "AB-1"},
+			},
+			expectedChanged: true,
+		},
+	}
+
+	patcher := scimpatch.NewPatcher(
+		schema.CoreUserSchema(),
+		[]schema.Schema{schema.ExtensionEnterpriseUser()},
+		&scimpatch.PatcherOpts{
+			Normalizers: map[string]scimpatch.Normalizer{
+				"userName":           scimpatch.NormalizeLowercase,
+				"emails.value":       scimpatch.NormalizeLowercase,
+				"phoneNumbers.value": scimpatch.NormalizeE164("81"),
+				"addresses.country":  scimpatch.NormalizeUppercase,
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:costCenter": scimpatch.NormalizeUppercase,
+			},
+		},
+	)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var pathBefore string
+			if tc.op.Path != nil {
+				pathBefore = tc.op.Path.String()
+			}
+			actual, changed, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if changed != tc.expectedChanged {
+				t.Errorf("changed:\n    actual  : %t\n    expected: %t", changed, tc.expectedChanged)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+			if tc.op.Path != nil && tc.op.Path.String() != pathBefore {
+				t.Errorf("path must not be modified:\n    actual  : %v\n    expected: %s", tc.op.Path, pathBefore)
+			}
+		})
+	}
+}
+
+// TestNormalizeE164 は NormalizeE164 をテストします
+func TestNormalizeE164(t *testing.T) {
+	testCases := []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{value: "+1 (555) 123-4567", expected: "+15551234567"},
+		{value: "0044 20 7946 0000", expected: "+442079460000"},
+		{value: "03-1234-5678", expected: "+81312345678"},
+		{value: "1234", expected: "1234"},
+		{value: "+81 3 1234 5678 ext. 9", expected: "+81 3 1234 5678 ext. 9"},
+		{value: true, expected: true},
+	}
+	normalize := scimpatch.NormalizeE164("81")
+	for _, tc := range testCases {
+		t.Run(fmt.Sprint(tc.value), func(t *testing.T) {
+			if actual := normalize(tc.value); actual != tc.expected {
+				t.Errorf("actual: %v, expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
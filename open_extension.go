@@ -0,0 +1,110 @@
+package scimpatch
+
+import (
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+)
+
+// isOpenExtension は urn がスキーマを登録せずに受け付ける拡張スキーマの URN であるかを判断します。
+func (p *Patcher) isOpenExtension(urn string) bool {
+	if len(p.openExtensions) == 0 || !strings.HasPrefix(strings.ToLower(urn), "urn:") {
+		return false
+	}
+	for _, pattern := range p.openExtensions {
+		if ok, err := path.Match(pattern, urn); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// extensionKey は path 未指定の value のキーが拡張スキーマの URN であるかを判断し、データを格納するキーを返却します。
+// 登録されていない URN でも OpenExtensions に一致する場合は拡張スキーマとして扱います。
+func (p *Patcher) extensionKey(key string) (string, bool) {
+	if extension, ok := p.schemas[key]; ok {
+		return extension.ID, true
+	}
+	if p.isOpenExtension(key) {
+		return key, true
+	}
+	return "", false
+}
+
+// resolvePathAttribute は op の path が対象とする属性を取得します。
+// path の URIPrefix が登録されていない URN で OpenExtensions に一致する場合は、path と value の形から属性を組み立てます。
+func (p *Patcher) resolvePathAttribute(op scim.PatchOperation, data map[string]interface{}) (schema.CoreAttribute, bool) {
+	if uriPrefix := op.Path.AttributePath.URIPrefix; uriPrefix != nil {
+		if _, ok := p.schemas[*uriPrefix]; !ok && p.isOpenExtension(*uriPrefix) {
+			return openExtensionAttribute(op, data)
+		}
+	}
+	return p.containsAttribute(op.Path.AttributePath.AttributeName)
+}
+
+// openExtensionAttribute はスキーマが登録されていない拡張スキーマの属性を組み立てます。
+// 属性の型は以下の順に判断し、単一値の属性は string として扱います。
+//   - path にフィルタが指定されている場合は、複数値の複合属性。ただし、保存されている値が map 以外の要素の配列の場合は複数値の属性
+//   - path にサブ属性が指定されている場合は、そのサブ属性を持つ単一値の複合属性
+//   - value (remove で value が未指定の場合は保存されている値) が配列の場合は、各要素が map であれば複数値の複合属性、そうでなければ複数値の属性
+//   - value が map の場合は、単一値の複合属性
+//
+// elimity-com/scim が受け付けない属性名の場合は false を返却します。
+func openExtensionAttribute(op scim.PatchOperation, data map[string]interface{}) (schema.CoreAttribute, bool) {
+	name := op.Path.AttributePath.AttributeName
+	if !attributeNamePattern.MatchString(name) {
+		return schema.CoreAttribute{}, false
+	}
+	switch {
+	case op.Path.ValueExpression != nil:
+		if op.Path.SubAttribute == nil && isSimpleSlice(storedOpenExtensionValue(op, data)) {
+			return schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{Name: name, MultiValued: true})), true
+		}
+		return schema.ComplexCoreAttribute(schema.ComplexParams{Name: name, MultiValued: true}), true
+	case op.Path.AttributePath.SubAttribute != nil:
+		return schema.ComplexCoreAttribute(schema.ComplexParams{
+			Name: name,
+			SubAttributes: []schema.SimpleParams{
+				schema.SimpleStringParams(schema.StringParams{Name: *op.Path.AttributePath.SubAttribute}),
+			},
+		}), true
+	}
+
+	value := op.Value
+	if value == nil {
+		value = storedOpenExtensionValue(op, data)
+	}
+	if v := reflect.ValueOf(value); v.Kind() == reflect.Slice {
+		if _, ok := areEveryItemsMap(value); ok && v.Len() > 0 {
+			return schema.ComplexCoreAttribute(schema.ComplexParams{Name: name, MultiValued: true}), true
+		}
+		return schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{Name: name, MultiValued: true})), true
+	}
+	if _, ok := asMap(value); ok {
+		return schema.ComplexCoreAttribute(schema.ComplexParams{Name: name}), true
+	}
+	return schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{Name: name})), true
+}
+
+// storedOpenExtensionValue は path が対象とする属性の、data に保存されている値を取得します。
+func storedOpenExtensionValue(op scim.PatchOperation, data map[string]interface{}) interface{} {
+	extMap, ok := asMap(data[*op.Path.AttributePath.URIPrefix])
+	if !ok {
+		return nil
+	}
+	_, value, _ := lookupKey(extMap, op.Path.AttributePath.AttributeName)
+	return value
+}
+
+// isSimpleSlice は value が map 以外の要素を含む配列であるかを判断します。空の配列は含みません。
+func isSimpleSlice(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice || v.Len() == 0 {
+		return false
+	}
+	_, ok := areEveryItemsMap(value)
+	return !ok
+}
@@ -0,0 +1,167 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestOpenExtensions は OpenExtensions に一致する登録されていない拡張スキーマに対する Patcher.Apply をテストします
+func TestOpenExtensions(t *testing.T) {
+	testCases := []struct {
+		name            string
+		op              scim.PatchOperation
+		data            map[string]interface{}
+		expected        map[string]interface{}
+		expectedChanged bool
+	}{
+		{
+			name: "add simple attribute",
+			op:   scim.PatchOperation{Op: "add", Path: path(`urn:acme:tenant1:costCenter`), Value: "A"},
+			data: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{"costCenter": "A"},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "replace sub attribute",
+			op:   scim.PatchOperation{Op: "replace", Path: path(`urn:acme:tenant1:manager.value`), Value: "0002"},
+			data: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{
+					"manager": map[string]interface{}{"value": "0001", "displayName": "Bob"},
+				},
+			},
+			expected: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{
+					"manager": map[string]interface{}{"value": "0002", "displayName": "Bob"},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "replace sub attribute with filter",
+			op:   scim.PatchOperation{Op: "replace", Path: path(`urn:acme:tenant1:badges[type eq "door"].value`), Value: "B-2"},
+			data: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{
+					"badges": []interface{}{
+						map[string]interface{}{"type": "door", "value": "B-1"},
+						map[string]interface{}{"type": "desk", "value": "D-1"},
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{
+					"badges": []map[string]interface{}{
+						{"type": "door", "value": "B-2"},
+						{"type": "desk", "value": "D-1"},
+					},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "remove with filter",
+			op:   scim.PatchOperation{Op: "remove", Path: path(`urn:acme:tenant1:badges[type eq "desk"]`)},
+			data: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{
+					"badges": []interface{}{
+						map[string]interface{}{"type": "door", "value": "B-1"},
+						map[string]interface{}{"type": "desk", "value": "D-1"},
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{
+					"badges": []map[string]interface{}{
+						{"type": "door", "value": "B-1"},
+					},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "add multi valued complex attribute",
+			op: scim.PatchOperation{Op: "add", Path: path(`urn:acme:tenant1:badges`), Value: []interface{}{
+				map[string]interface{}{"type": "desk", "value": "D-1"},
+			}},
+			data: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{
+					"badges": []interface{}{
+						map[string]interface{}{"type": "door", "value": "B-1"},
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{
+					"badges": []map[string]interface{}{
+						{"type": "door", "value": "B-1"},
+						{"type": "desk", "value": "D-1"},
+					},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			// サブ属性を持たない複数値属性のフィルタは、登録されたスキーマの属性と同様に要素に一致しません
+			name: "filter on simple multi-valued attribute",
+			op:   scim.PatchOperation{Op: "remove", Path: path(`urn:acme:tenant1:tags[value eq "x"]`)},
+			data: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{"tags": []interface{}{"x", "y"}},
+			},
+			expected: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{"tags": []interface{}{"x", "y"}},
+			},
+			expectedChanged: false,
+		},
+		{
+			name: "path not specified",
+			op: scim.PatchOperation{Op: "add", Value: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{"costCenter": "B"},
+			}},
+			data: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{"costCenter": "A", "division": "X"},
+			},
+			expected: map[string]interface{}{
+				"urn:acme:tenant1": map[string]interface{}{"costCenter": "B", "division": "X"},
+			},
+			expectedChanged: true,
+		},
+	}
+
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+		OpenExtensions: []string{"urn:acme:*"},
+	})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, changed, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if changed != tc.expectedChanged {
+				t.Errorf("changed:\n    actual  : %t\n    expected: %t", changed, tc.expectedChanged)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+// TestOpenExtensionsNotAllowed は OpenExtensions に一致しない URN が invalidPath となることをテストします
+func TestOpenExtensionsNotAllowed(t *testing.T) {
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+		OpenExtensions: []string{"urn:acme:*"},
+	})
+	_, _, err := patcher.Apply(context.TODO(), scim.PatchOperation{
+		Op: "add", Path: path(`urn:other:tenant1:costCenter`), Value: "A",
+	}, map[string]interface{}{})
+	if err != errors.ScimErrorInvalidPath {
+		t.Errorf("Apply() should return invalidPath: %v", err)
+	}
+}
@@ -16,3 +16,33 @@ type Operator interface {
 	ByValueExpressionForItem(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, value interface{}) ([]map[string]interface{}, bool)
 	ByValueExpressionForAttribute(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, subAttr string, value interface{}) ([]map[string]interface{}, bool)
 }
+
+// OperatorMiddleware は Operator を受け取り、処理を追加した Operator を返却する関数です。
+// ログやメトリクスなどの横断的な処理を、パッケージデフォルトの Operator を置き換えずに追加するために利用します。
+type OperatorMiddleware func(Operator) Operator
+
+// DefaultAdder はパッケージデフォルトの add の Operator を取得します。
+func DefaultAdder() Operator {
+	return adderInstance
+}
+
+// DefaultReplacer はパッケージデフォルトの replace の Operator を取得します。
+func DefaultReplacer() Operator {
+	return replacerInstance
+}
+
+// DefaultRemover はパッケージデフォルトの remove の Operator を取得します。
+func DefaultRemover() Operator {
+	return removerInstance
+}
+
+// chainOperator は middlewares を operator に適用します。先頭の middleware が最も外側になります。
+func chainOperator(operator Operator, middlewares []OperatorMiddleware) Operator {
+	if operator == nil {
+		return nil
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		operator = middlewares[i](operator)
+	}
+	return operator
+}
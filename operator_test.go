@@ -0,0 +1,130 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// recordingOperator は呼び出された順序を記録して next に処理を委譲する Operator です
+type recordingOperator struct {
+	name     string
+	next     scimpatch.Operator
+	recorded *[]string
+}
+
+func (o recordingOperator) Direct(ctx context.Context, scopedMap map[string]interface{}, scopedAttr string, value interface{}) bool {
+	*o.recorded = append(*o.recorded, o.name+":Direct")
+	return o.next.Direct(ctx, scopedMap, scopedAttr, value)
+}
+
+func (o recordingOperator) ByValueExpressionForItem(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, value interface{}) ([]map[string]interface{}, bool) {
+	*o.recorded = append(*o.recorded, o.name+":ByValueExpressionForItem")
+	return o.next.ByValueExpressionForItem(ctx, scopedMaps, expr, value)
+}
+
+func (o recordingOperator) ByValueExpressionForAttribute(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, subAttr string, value interface{}) ([]map[string]interface{}, bool) {
+	*o.recorded = append(*o.recorded, o.name+":ByValueExpressionForAttribute")
+	return o.next.ByValueExpressionForAttribute(ctx, scopedMaps, expr, subAttr, value)
+}
+
+// TestMiddlewares は Middlewares が指定した順に適用され、パッケージデフォルトの Operator に委譲されることをテストします
+func TestMiddlewares(t *testing.T) {
+	testCases := []struct {
+		name             string
+		op               scim.PatchOperation
+		data             map[string]interface{}
+		expected         map[string]interface{}
+		expectedRecorded []string
+	}{
+		{
+			name:             "default operator",
+			op:               scim.PatchOperation{Op: "replace", Path: path(`title`), Value: "Manager"},
+			data:             map[string]interface{}{"title": "Engineer"},
+			expected:         map[string]interface{}{"title": "Manager"},
+			expectedRecorded: []string{"outer:Direct", "inner:Direct"},
+		},
+		{
+			name: "default operator with filter",
+			op:   scim.PatchOperation{Op: "remove", Path: path(`emails[type eq "work"]`)},
+			data: map[string]interface{}{
+				"emails": []interface{}{map[string]interface{}{"type": "work", "value": "a@example.com"}},
+			},
+			expected:         map[string]interface{}{},
+			expectedRecorded: []string{"outer:ByValueExpressionForItem", "inner:ByValueExpressionForItem"},
+		},
+		{
+			name:             "attribute operator",
+			op:               scim.PatchOperation{Op: "add", Path: path(`nickName`), Value: "Bob"},
+			data:             map[string]interface{}{},
+			expected:         map[string]interface{}{"nickName": "nickName adder"},
+			expectedRecorded: []string{"outer:Direct", "inner:Direct"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var recorded []string
+			middleware := func(name string) scimpatch.OperatorMiddleware {
+				return func(next scimpatch.Operator) scimpatch.Operator {
+					return recordingOperator{name: name, next: next, recorded: &recorded}
+				}
+			}
+			patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+				AttributeOperators: map[string]scimpatch.AttributeOperators{
+					"nickName": {Adder: markOperator{mark: "nickName adder"}},
+				},
+				Middlewares: []scimpatch.OperatorMiddleware{middleware("outer"), middleware("inner")},
+			})
+
+			actual, _, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+			if !reflect.DeepEqual(recorded, tc.expectedRecorded) {
+				t.Errorf("recorded:\n    actual  : %v\n    expected: %v", recorded, tc.expectedRecorded)
+			}
+		})
+	}
+}
+
+// TestDefaultOperators はパッケージデフォルトの Operator に委譲する Operator を指定できることをテストします
+func TestDefaultOperators(t *testing.T) {
+	var recorded []string
+	var adder scimpatch.Operator = recordingOperator{name: "adder", next: scimpatch.DefaultAdder(), recorded: &recorded}
+	var replacer scimpatch.Operator = recordingOperator{name: "replacer", next: scimpatch.DefaultReplacer(), recorded: &recorded}
+	var remover scimpatch.Operator = recordingOperator{name: "remover", next: scimpatch.DefaultRemover(), recorded: &recorded}
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+		Adder:    &adder,
+		Replacer: &replacer,
+		Remover:  &remover,
+	})
+
+	data := map[string]interface{}{}
+	ops := []scim.PatchOperation{
+		{Op: "add", Path: path(`title`), Value: "Engineer"},
+		{Op: "replace", Path: path(`title`), Value: "Manager"},
+		{Op: "remove", Path: path(`nickName`)},
+	}
+	actual, changed, err := patcher.ApplyOperations(context.TODO(), ops, data)
+	if err != nil {
+		t.Fatalf("ApplyOperations() returned an unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{"title": "Manager"}
+	if !changed || !reflect.DeepEqual(actual, expected) {
+		t.Errorf("result:\n    actual  : %v (%t)\n    expected: %v", actual, changed, expected)
+	}
+	expectedRecorded := []string{"adder:Direct", "replacer:Direct", "remover:Direct"}
+	if !reflect.DeepEqual(recorded, expectedRecorded) {
+		t.Errorf("recorded:\n    actual  : %v\n    expected: %v", recorded, expectedRecorded)
+	}
+}
@@ -0,0 +1,24 @@
+module github.com/ivixvi/scim-patch/otelscimpatch
+
+go 1.22
+
+replace github.com/ivixvi/scim-patch => ../
+
+require (
+	github.com/elimity-com/scim v0.0.0-20240320110924-172bf2aee9c8
+	github.com/ivixvi/scim-patch v0.0.0-00010101000000-000000000000
+	github.com/scim2/filter-parser/v2 v2.2.0
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/metric v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/sdk/metric v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+)
+
+require (
+	github.com/di-wu/parser v0.2.2 // indirect
+	github.com/di-wu/xsd-datetime v1.0.0 // indirect
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+)
@@ -0,0 +1,150 @@
+// Package otelscimpatch は scimpatch.Instrumentation の OpenTelemetry 実装です。
+// op 毎にスパンを作成し、op の件数と適用時間をメトリクスとして記録します。
+package otelscimpatch
+
+import (
+	"context"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	scimpatch "github.com/ivixvi/scim-patch"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName は Tracer と Meter の計装スコープ名です。
+const ScopeName = "github.com/ivixvi/scim-patch/otelscimpatch"
+
+// メトリクスとスパンの属性のキーです。
+// attribute は値の種類が多くなりうるため、スパンにのみ記録します。
+// path はフィルタの比較値 (e.g. メールアドレス) を含みうるため記録せず、path の形式と属性名のみを記録します。
+const (
+	AttributeOp        = attribute.Key("scimpatch.op")
+	AttributePathShape = attribute.Key("scimpatch.path_shape")
+	AttributeProfile   = attribute.Key("scimpatch.profile")
+	AttributeChanged   = attribute.Key("scimpatch.changed")
+	AttributeScimType  = attribute.Key("scimpatch.scim_type")
+	AttributeAttribute = attribute.Key("scimpatch.attribute")
+)
+
+// OpUnknown は add, replace, remove 以外の op の代わりに記録される値です。
+// op はクライアントが指定する値のため、スパン名とメトリクスの属性の種類が増えないよう固定の値に置き換えます。
+const OpUnknown = "unknown"
+
+// Instrumentation は OpenTelemetry を利用する scimpatch.Instrumentation です。
+type Instrumentation struct {
+	tracer     trace.Tracer
+	operations metric.Int64Counter
+	duration   metric.Float64Histogram
+}
+
+var _ scimpatch.Instrumentation = (*Instrumentation)(nil)
+
+// Option は Instrumentation の設定です。
+type Option func(*config)
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+}
+
+// WithTracerProvider は利用する TracerProvider を指定します。指定しない場合はグローバルの TracerProvider が利用されます。
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = provider
+	}
+}
+
+// WithMeterProvider は利用する MeterProvider を指定します。指定しない場合はグローバルの MeterProvider が利用されます。
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = provider
+	}
+}
+
+// New は Instrumentation の実態を取得します。
+func New(opts ...Option) (*Instrumentation, error) {
+	c := config{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	meter := c.meterProvider.Meter(ScopeName)
+	operations, err := meter.Int64Counter(
+		"scimpatch.operations",
+		metric.WithDescription("The number of applied PATCH operations."),
+		metric.WithUnit("{operation}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := meter.Float64Histogram(
+		"scimpatch.operation.duration",
+		metric.WithDescription("The duration of applying a PATCH operation."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Instrumentation{
+		tracer:     c.tracerProvider.Tracer(ScopeName),
+		operations: operations,
+		duration:   duration,
+	}, nil
+}
+
+// StartOperation は op のスパンを開始します。
+func (i *Instrumentation) StartOperation(ctx context.Context, info scimpatch.OperationInfo) context.Context {
+	ctx, _ = i.tracer.Start(ctx, "scimpatch "+operationName(info.Op),
+		trace.WithAttributes(append(commonAttributes(info),
+			AttributeAttribute.String(info.Attribute),
+		)...),
+	)
+	return ctx
+}
+
+// EndOperation は op のスパンを終了し、メトリクスを記録します。
+// エラーのメッセージは value やフィルタの比較値を含みうるため、スパンには scimType のみを記録します。
+func (i *Instrumentation) EndOperation(ctx context.Context, info scimpatch.OperationInfo, result scimpatch.OperationResult) {
+	attrs := append(commonAttributes(info), AttributeChanged.Bool(result.Changed))
+	if result.ScimType != "" {
+		attrs = append(attrs, AttributeScimType.String(string(result.ScimType)))
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attrs...)
+	if result.Err != nil {
+		span.SetStatus(codes.Error, string(result.ScimType))
+	}
+	span.End()
+
+	set := metric.WithAttributes(attrs...)
+	i.operations.Add(ctx, 1, set)
+	i.duration.Record(ctx, result.Duration.Seconds(), set)
+}
+
+func commonAttributes(info scimpatch.OperationInfo) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		AttributeOp.String(operationName(info.Op)),
+		AttributePathShape.String(string(info.PathShape)),
+	}
+	if info.Profile != "" {
+		attrs = append(attrs, AttributeProfile.String(info.Profile))
+	}
+	return attrs
+}
+
+// operationName は op を小文字に変換し、add, replace, remove 以外の場合は OpUnknown を返却します。
+func operationName(op string) string {
+	switch op = strings.ToLower(op); op {
+	case scim.PatchOperationAdd, scim.PatchOperationReplace, scim.PatchOperationRemove:
+		return op
+	}
+	return OpUnknown
+}
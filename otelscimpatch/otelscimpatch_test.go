@@ -0,0 +1,229 @@
+package otelscimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+	"github.com/ivixvi/scim-patch/otelscimpatch"
+	"github.com/scim2/filter-parser/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func path(s string) *filter.Path {
+	p, err := filter.ParsePath([]byte(s))
+	if err != nil {
+		panic(err)
+	}
+	return &p
+}
+
+// TestInstrumentation は op 毎のスパンとメトリクスが記録されることをテストします
+func TestInstrumentation(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	instrumentation, err := otelscimpatch.New(
+		otelscimpatch.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+		otelscimpatch.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+	)
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
+	}
+	profile := scimpatch.ProfileOkta
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+		Profile:         &profile,
+		Instrumentation: instrumentation,
+	})
+
+	ops := []scim.PatchOperation{
+		{Op: "replace", Path: path(`title`), Value: "Manager"},
+		{Op: "replace", Path: path(`title`), Value: "Manager"},
+		{Op: "remove", Path: path(`unknown`)},
+	}
+	data := map[string]interface{}{}
+	for _, op := range ops {
+		data, _, _ = patcher.Apply(context.TODO(), op, data)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != len(ops) {
+		t.Fatalf("spans:\n    actual  : %d\n    expected: %d", len(ended), len(ops))
+	}
+	if ended[0].Name() != "scimpatch replace" {
+		t.Errorf("span name: %s", ended[0].Name())
+	}
+	if !containsAttribute(ended[0].Attributes(), otelscimpatch.AttributeAttribute.String("title")) {
+		t.Errorf("span attributes: %v", ended[0].Attributes())
+	}
+	if ended[2].Status().Code != codes.Error || !containsAttribute(ended[2].Attributes(), otelscimpatch.AttributeScimType.String("invalidPath")) {
+		t.Errorf("span of the failed operation: %v %v", ended[2].Status(), ended[2].Attributes())
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.TODO(), &rm); err != nil {
+		t.Fatalf("Collect() returned an unexpected error: %v", err)
+	}
+	counts := map[attribute.Distinct]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if sum, ok := m.Data.(metricdata.Sum[int64]); ok && m.Name == "scimpatch.operations" {
+				for _, dp := range sum.DataPoints {
+					counts[dp.Attributes.Equivalent()] = dp.Value
+				}
+			}
+		}
+	}
+	expected := map[attribute.Distinct]int64{
+		newSet(
+			otelscimpatch.AttributeOp.String("replace"),
+			otelscimpatch.AttributePathShape.String("attr"),
+			otelscimpatch.AttributeProfile.String("okta"),
+			otelscimpatch.AttributeChanged.Bool(true),
+		).Equivalent(): 1,
+		newSet(
+			otelscimpatch.AttributeOp.String("replace"),
+			otelscimpatch.AttributePathShape.String("attr"),
+			otelscimpatch.AttributeProfile.String("okta"),
+			otelscimpatch.AttributeChanged.Bool(false),
+		).Equivalent(): 1,
+		newSet(
+			otelscimpatch.AttributeOp.String("remove"),
+			otelscimpatch.AttributePathShape.String("attr"),
+			otelscimpatch.AttributeProfile.String("okta"),
+			otelscimpatch.AttributeChanged.Bool(false),
+			otelscimpatch.AttributeScimType.String("invalidPath"),
+		).Equivalent(): 1,
+	}
+	if len(counts) != len(expected) {
+		t.Fatalf("operations:\n    actual  : %v\n    expected: %v", counts, expected)
+	}
+	for k, v := range expected {
+		if counts[k] != v {
+			t.Errorf("operations %v:\n    actual  : %d\n    expected: %d", k, counts[k], v)
+		}
+	}
+}
+
+// TestInstrumentationRedaction は path のフィルタの比較値とエラーのメッセージがスパンに記録されないことをテストします
+func TestInstrumentationRedaction(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	instrumentation, err := otelscimpatch.New(
+		otelscimpatch.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+		otelscimpatch.WithMeterProvider(sdkmetric.NewMeterProvider()),
+	)
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
+	}
+	secret := "alice@example.com"
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{
+		Instrumentation: instrumentation,
+		Hooks: []scimpatch.Hook{{
+			Attribute: "emails",
+			Before: func(ctx context.Context, op *scimpatch.HookOperation) error {
+				return fmt.Errorf("rejected %s", op.Path)
+			},
+		}},
+	})
+
+	op := scim.PatchOperation{Op: "replace", Path: path(`emails[value eq "` + secret + `"].display`), Value: "Alice"}
+	if _, _, err := patcher.Apply(context.TODO(), op, map[string]interface{}{}); err == nil || !strings.Contains(err.Error(), secret) {
+		t.Fatalf("the error must contain the filter value for this test: %v", err)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("spans: %d", len(ended))
+	}
+	span := ended[0]
+	for _, attr := range span.Attributes() {
+		if strings.Contains(attr.Value.Emit(), secret) {
+			t.Errorf("the filter value is recorded: %v", attr)
+		}
+	}
+	if !containsAttribute(span.Attributes(), otelscimpatch.AttributeAttribute.String("emails")) ||
+		!containsAttribute(span.Attributes(), otelscimpatch.AttributePathShape.String("attr[filter].sub")) {
+		t.Errorf("span attributes: %v", span.Attributes())
+	}
+	if span.Status().Code != codes.Error || strings.Contains(span.Status().Description, secret) {
+		t.Errorf("span status: %v", span.Status())
+	}
+	if len(span.Events()) != 0 {
+		t.Errorf("span events: %v", span.Events())
+	}
+}
+
+// TestInstrumentationUnknownOp は add, replace, remove 以外の op がスパン名とメトリクスに固定の値で記録されることをテストします
+func TestInstrumentationUnknownOp(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	reader := sdkmetric.NewManualReader()
+	instrumentation, err := otelscimpatch.New(
+		otelscimpatch.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))),
+		otelscimpatch.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))),
+	)
+	if err != nil {
+		t.Fatalf("New() returned an unexpected error: %v", err)
+	}
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{Instrumentation: instrumentation})
+
+	ops := []scim.PatchOperation{
+		{Op: "Replace", Path: path(`title`), Value: "Manager"},
+		{Op: "copy-1", Path: path(`title`), Value: "Manager"},
+		{Op: "copy-2", Path: path(`title`), Value: "Manager"},
+	}
+	for _, op := range ops {
+		_, _, _ = patcher.Apply(context.TODO(), op, map[string]interface{}{})
+	}
+
+	names := []string{}
+	for _, span := range spans.Ended() {
+		names = append(names, span.Name())
+	}
+	expectedNames := []string{"scimpatch replace", "scimpatch unknown", "scimpatch unknown"}
+	if fmt.Sprint(names) != fmt.Sprint(expectedNames) {
+		t.Errorf("span names:\n    actual  : %v\n    expected: %v", names, expectedNames)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.TODO(), &rm); err != nil {
+		t.Fatalf("Collect() returned an unexpected error: %v", err)
+	}
+	recorded := map[string]bool{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if !ok {
+				continue
+			}
+			for _, dp := range sum.DataPoints {
+				op, _ := dp.Attributes.Value(otelscimpatch.AttributeOp)
+				recorded[op.AsString()] = true
+			}
+		}
+	}
+	if fmt.Sprint(recorded) != fmt.Sprint(map[string]bool{"replace": true, otelscimpatch.OpUnknown: true}) {
+		t.Errorf("op attributes: %v", recorded)
+	}
+}
+
+func containsAttribute(attrs []attribute.KeyValue, expected attribute.KeyValue) bool {
+	for _, attr := range attrs {
+		if attr == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func newSet(kvs ...attribute.KeyValue) *attribute.Set {
+	s := attribute.NewSet(kvs...)
+	return &s
+}
@@ -384,6 +384,92 @@ func TestPathSpecifiedRemove(t *testing.T) {
 			},
 			expectedChanged: false,
 		},
+		// Remove MultiValued Complex Attribute with value
+		// cf. https://learn.microsoft.com/en-us/entra/identity/app-provisioning/use-scim-to-provision-users-and-groups#update-group-remove-members
+		{
+			name: "Remove operation - MultiValued Complex Attribute - Remove with value",
+			op: scim.PatchOperation{
+				Op:   "remove",
+				Path: path("emails"),
+				Value: []interface{}{
+					map[string]interface{}{
+						"value": "ivixvi@example.com",
+					},
+				},
+			},
+			data: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{
+						"value": "ivixvi@example.com",
+						"type":  "home",
+					},
+					map[string]interface{}{
+						"value": "ivixvi@example.org",
+						"type":  "work",
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"emails": []map[string]interface{}{
+					{
+						"value": "ivixvi@example.org",
+						"type":  "work",
+					},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "Remove operation - MultiValued Complex Attribute - Remove with value. All Removed",
+			op: scim.PatchOperation{
+				Op:   "remove",
+				Path: path("emails"),
+				Value: []interface{}{
+					map[string]interface{}{
+						"value": "ivixvi@example.com",
+					},
+				},
+			},
+			data: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{
+						"value": "ivixvi@example.com",
+						"type":  "home",
+					},
+				},
+			},
+			expected:        map[string]interface{}{},
+			expectedChanged: true,
+		},
+		{
+			name: "Remove operation - MultiValued Complex Attribute - Remove with value. No Changed",
+			op: scim.PatchOperation{
+				Op:   "remove",
+				Path: path("emails"),
+				Value: []interface{}{
+					map[string]interface{}{
+						"value": "ivixvi@example.net",
+					},
+				},
+			},
+			data: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{
+						"value": "ivixvi@example.com",
+						"type":  "home",
+					},
+				},
+			},
+			expected: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{
+						"value": "ivixvi@example.com",
+						"type":  "home",
+					},
+				},
+			},
+			expectedChanged: false,
+		},
 		// Remove MultiValued Attributes
 		{
 			name: "Remove operation - MultiValued Singular Attribute - remove",
@@ -417,9 +503,32 @@ func TestPathSpecifiedRemove(t *testing.T) {
 			// 	},
 			// },
 			// expectedChanged: true,
-			expected:        map[string]interface{}{},
+			expected: map[string]interface{}{
+				"urn:ivixvi:testSchema": map[string]interface{}{
+					"testString": []interface{}{"value", "delete"},
+				},
+			},
 			expectedChanged: false,
 		},
+		{
+			name: "Remove operation - MultiValued Singular Attribute - remove with value",
+			op: scim.PatchOperation{
+				Op:    "remove",
+				Path:  path("urn:ivixvi:testSchema:testString"),
+				Value: []interface{}{"delete"},
+			},
+			data: map[string]interface{}{
+				"urn:ivixvi:testSchema": map[string]interface{}{
+					"testString": []interface{}{"value", "delete"},
+				},
+			},
+			expected: map[string]interface{}{
+				"urn:ivixvi:testSchema": map[string]interface{}{
+					"testString": []interface{}{"value"},
+				},
+			},
+			expectedChanged: true,
+		},
 		{
 			name: "Remove operation - MultiValued Singular Attribute - no changed",
 			op: scim.PatchOperation{
@@ -0,0 +1,289 @@
+package scimpatch
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+)
+
+// CompatibilityProfile は IdP 毎の RFC7644 からの逸脱を吸収するための設定です。
+// PatcherOpts.Profile に指定することで、Patcher は op を適用する前に各設定に応じて op を変換します。
+//
+// なお、以下の逸脱はプロファイルの指定有無にかかわらず受け付けます。
+//   - "Add", "Replace", "Remove" のような大文字を含む op
+//   - path 未指定の value に含まれる "name.givenName" のようなドット区切りのキー
+//   - remove で value に削除対象の要素を指定する操作
+type CompatibilityProfile struct {
+	// Name はプロファイルの名前です。ログなどでの識別に利用します。
+	Name string
+	// StringBooleans は boolean 型の属性に対して "True" や "false" のような文字列が指定された場合に、真偽値に変換します。
+	StringBooleans bool
+	// UnwrapSingularValue は単一値の属性に対して [x] や [{"value": x}] のように1要素の配列で value が指定された場合に、x に変換します。
+	UnwrapSingularValue bool
+	// URNQualifiedKeys は path 未指定の value に含まれる "urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department" のような
+	// スキーマの URN で修飾されたキーを、拡張スキーマの属性として扱います。
+	URNQualifiedKeys bool
+	// SkipReadOnlyAttributes は path 未指定の value に含まれる id や meta などの readOnly な属性を無視します。
+	SkipReadOnlyAttributes bool
+	// MembersReplaceAsAdd は members 全体に対する replace を add として扱います。
+	// replace で追加するメンバーのみを送信する IdP のための設定です。
+	MembersReplaceAsAdd bool
+}
+
+var (
+	// ProfileEntraID は Microsoft Entra ID (Azure AD) 向けのプロファイルです。
+	// cf. https://learn.microsoft.com/en-us/entra/identity/app-provisioning/application-provisioning-config-problem-scim-compatibility
+	ProfileEntraID = CompatibilityProfile{
+		Name:                "entra",
+		StringBooleans:      true,
+		URNQualifiedKeys:    true,
+		MembersReplaceAsAdd: true,
+	}
+	// ProfileOkta は Okta 向けのプロファイルです。
+	ProfileOkta = CompatibilityProfile{
+		Name:                   "okta",
+		SkipReadOnlyAttributes: true,
+	}
+	// ProfileOneLogin は OneLogin 向けのプロファイルです。
+	ProfileOneLogin = CompatibilityProfile{
+		Name:                "onelogin",
+		StringBooleans:      true,
+		UnwrapSingularValue: true,
+	}
+	// ProfileGoogle は Google Workspace 向けのプロファイルです。
+	ProfileGoogle = CompatibilityProfile{
+		Name:                   "google",
+		SkipReadOnlyAttributes: true,
+		URNQualifiedKeys:       true,
+	}
+	// ProfileJumpCloud は JumpCloud 向けのプロファイルです。
+	ProfileJumpCloud = CompatibilityProfile{
+		Name:                   "jumpcloud",
+		StringBooleans:         true,
+		UnwrapSingularValue:    true,
+		SkipReadOnlyAttributes: true,
+		MembersReplaceAsAdd:    true,
+	}
+)
+
+// Profiles は名前からプロファイルを取得するための一覧です。
+var Profiles = map[string]CompatibilityProfile{
+	ProfileEntraID.Name:   ProfileEntraID,
+	ProfileOkta.Name:      ProfileOkta,
+	ProfileOneLogin.Name:  ProfileOneLogin,
+	ProfileGoogle.Name:    ProfileGoogle,
+	ProfileJumpCloud.Name: ProfileJumpCloud,
+}
+
+// readOnlyCommonAttributes は各リソースに共通する readOnly な属性です。
+// cf. https://datatracker.ietf.org/doc/html/rfc7643#section-3.1
+var readOnlyCommonAttributes = []string{"id", "meta", "schemas"}
+
+// applyProfile は profile の設定に応じて op を変換します。
+// op.Value は呼び出し元と共有されているため、変換が必要な場合は複製してから変換します。
+func (p *Patcher) applyProfile(ctx context.Context, op scim.PatchOperation) scim.PatchOperation {
+	profile := p.profile
+	if profile == nil {
+		return op
+	}
+
+	if op.Path == nil {
+		if newMap, ok := asMap(op.Value); ok {
+			op.Value = p.profileUnspecifiedValue(ctx, newMap)
+		}
+		return op
+	}
+
+	if profile.MembersReplaceAsAdd && op.Op == scim.PatchOperationReplace &&
+		strings.EqualFold(op.Path.AttributePath.AttributeName, groupMembersAttr) && op.Path.ValueExpression == nil {
+		op.Op = scim.PatchOperationAdd
+	}
+
+	if !profile.StringBooleans && !profile.UnwrapSingularValue {
+		return op
+	}
+	attr, ok := p.containsAttribute(op.Path.AttributePath.AttributeName)
+	if !ok {
+		return op
+	}
+	for _, subAttrName := range []*string{op.Path.AttributePath.SubAttribute, op.Path.SubAttribute} {
+		if subAttrName == nil {
+			continue
+		}
+		subAttr, ok := attr.SubAttributes().ContainsAttribute(*subAttrName)
+		if !ok {
+			return op
+		}
+		attr = subAttr
+	}
+	// `attr[expr]` は複数値属性の1要素が対象となります
+	singular := op.Path.ValueExpression != nil && op.Path.SubAttribute == nil
+	op.Value = profile.coerce(attr, op.Value, singular)
+	return op
+}
+
+// profileUnspecifiedValue は path 未指定の value の各属性を profile の設定に応じて変換します。
+func (p *Patcher) profileUnspecifiedValue(ctx context.Context, newMap map[string]interface{}) map[string]interface{} {
+	profile := p.profile
+	converted := make(map[string]interface{}, len(newMap))
+	for key, value := range newMap {
+		if profile.SkipReadOnlyAttributes && p.isReadOnlyKey(key) {
+			logEvent(ctx, slog.LevelDebug, "read-only key skipped by profile", slog.String("key", key), slog.String("profile", profile.Name))
+			continue
+		}
+
+		// Schema Extension Attributes
+		if extension, ok := p.schemas[key]; ok && key != p.schema.ID {
+			if extMap, ok := asMap(value); ok {
+				value = p.profileExtensionValue(extension, extMap, converted[key])
+			}
+			converted[key] = value
+			continue
+		}
+		if profile.URNQualifiedKeys {
+			if extension, attrName, ok := p.splitURNQualifiedKey(key); ok {
+				converted[extension.ID] = p.profileExtensionValue(extension, map[string]interface{}{attrName: value}, converted[extension.ID])
+				continue
+			}
+		}
+
+		// Core Attributes
+		converted[key] = profile.coerceByName(p.schema.Attributes, key, value)
+	}
+	return converted
+}
+
+// profileExtensionValue は拡張スキーマの属性を変換し、既に変換済みの値 (merged) があればマージします。
+func (p *Patcher) profileExtensionValue(extension schema.Schema, extMap map[string]interface{}, merged interface{}) map[string]interface{} {
+	ret, ok := asMap(merged)
+	if !ok || ret == nil {
+		ret = make(map[string]interface{}, len(extMap))
+	}
+	for attrName, attrValue := range extMap {
+		ret[attrName] = p.profile.coerceByName(extension.Attributes, attrName, attrValue)
+	}
+	return ret
+}
+
+// splitURNQualifiedKey は "urn:...:User:department" のようなキーを拡張スキーマと属性名に分割します。
+func (p *Patcher) splitURNQualifiedKey(key string) (schema.Schema, string, bool) {
+	for id, s := range p.schemas {
+		if id == p.schema.ID {
+			continue
+		}
+		if attrName, ok := strings.CutPrefix(key, id+":"); ok && attrName != "" {
+			return s, attrName, true
+		}
+	}
+	return schema.Schema{}, "", false
+}
+
+// isReadOnlyKey は path 未指定の value のキーが readOnly な属性を示しているかを判断します。
+func (p *Patcher) isReadOnlyKey(key string) bool {
+	for _, name := range readOnlyCommonAttributes {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	attrName, _, _ := strings.Cut(key, ".")
+	attr, ok := p.schema.Attributes.ContainsAttribute(attrName)
+	return ok && isReadOnly(attr)
+}
+
+// coerceByName は attrs から name (ドット区切りを含む) の属性を探し、value を変換します。
+func (profile *CompatibilityProfile) coerceByName(attrs schema.Attributes, name string, value interface{}) interface{} {
+	attrName, subAttrName, hasSub := strings.Cut(name, ".")
+	attr, ok := attrs.ContainsAttribute(attrName)
+	if !ok {
+		return value
+	}
+	if hasSub {
+		if attr, ok = attr.SubAttributes().ContainsAttribute(subAttrName); !ok {
+			return value
+		}
+	}
+	return profile.coerce(attr, value, false)
+}
+
+// coerce は attr の定義に応じて value を変換します。
+// singular が真の場合は、複数値属性の1要素として value を扱います。
+func (profile *CompatibilityProfile) coerce(attr schema.CoreAttribute, value interface{}, singular bool) interface{} {
+	multiValued := attr.MultiValued() && !singular
+	if profile.UnwrapSingularValue && !multiValued {
+		value = unwrapSingularValue(attr, value)
+	}
+
+	if multiValued {
+		if items, ok := value.([]interface{}); ok {
+			coerced := make([]interface{}, len(items))
+			for i, item := range items {
+				coerced[i] = profile.coerce(attr, item, true)
+			}
+			return coerced
+		}
+		if maps, ok := value.([]map[string]interface{}); ok {
+			coerced := make([]map[string]interface{}, len(maps))
+			for i, item := range maps {
+				coerced[i] = profile.coerceComplex(attr, item)
+			}
+			return coerced
+		}
+	}
+
+	if m, ok := asMap(value); ok && attr.HasSubAttributes() {
+		return profile.coerceComplex(attr, m)
+	}
+	if profile.StringBooleans && attr.AttributeType() == "boolean" {
+		if s, ok := value.(string); ok {
+			switch {
+			case strings.EqualFold(s, "true"):
+				return true
+			case strings.EqualFold(s, "false"):
+				return false
+			}
+		}
+	}
+	return value
+}
+
+// coerceComplex は複合属性の各サブ属性の値を変換します。
+func (profile *CompatibilityProfile) coerceComplex(attr schema.CoreAttribute, m map[string]interface{}) map[string]interface{} {
+	coerced := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		subAttr, ok := attr.SubAttributes().ContainsAttribute(k)
+		if !ok {
+			coerced[k] = v
+			continue
+		}
+		coerced[k] = profile.coerce(subAttr, v, false)
+	}
+	return coerced
+}
+
+// unwrapSingularValue は1要素の配列で指定された単一値の属性の value を取り出します。
+func unwrapSingularValue(attr schema.CoreAttribute, value interface{}) interface{} {
+	var item interface{}
+	switch typed := value.(type) {
+	case []interface{}:
+		if len(typed) != 1 {
+			return value
+		}
+		item = typed[0]
+	case []map[string]interface{}:
+		if len(typed) != 1 {
+			return value
+		}
+		item = typed[0]
+	default:
+		return value
+	}
+	if m, ok := asMap(item); ok && !attr.HasSubAttributes() {
+		if v, ok := m[identityKey]; ok && len(m) == 1 {
+			return v
+		}
+	}
+	return item
+}
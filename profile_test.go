@@ -0,0 +1,308 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestCompatibilityProfile は CompatibilityProfile を指定した Patcher.Apply をテストします
+func TestCompatibilityProfile(t *testing.T) {
+	testCases := []struct {
+		name            string
+		profile         scimpatch.CompatibilityProfile
+		schema          schema.Schema
+		op              scim.PatchOperation
+		data            map[string]interface{}
+		expected        map[string]interface{}
+		expectedChanged bool
+	}{
+		{
+			name:    "StringBooleans - path specified",
+			profile: scimpatch.CompatibilityProfile{StringBooleans: true},
+			op: scim.PatchOperation{
+				Op:    "Replace",
+				Path:  path(`active`),
+				Value: "False",
+			},
+			data:            map[string]interface{}{"active": true},
+			expected:        map[string]interface{}{"active": false},
+			expectedChanged: true,
+		},
+		{
+			name:    "StringBooleans - path not specified",
+			profile: scimpatch.ProfileEntraID,
+			op: scim.PatchOperation{
+				Op:    "Replace",
+				Value: map[string]interface{}{"active": "False"},
+			},
+			data:            map[string]interface{}{"active": false},
+			expected:        map[string]interface{}{"active": false},
+			expectedChanged: false,
+		},
+		{
+			name:    "StringBooleans - sub attribute with filter",
+			profile: scimpatch.CompatibilityProfile{StringBooleans: true},
+			op: scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`emails[type eq "work"].primary`),
+				Value: "true",
+			},
+			data: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{"type": "work", "value": "alice@example.com", "primary": false},
+				},
+			},
+			expected: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{"type": "work", "value": "alice@example.com", "primary": true},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name:    "StringBooleans - multi valued complex value",
+			profile: scimpatch.CompatibilityProfile{StringBooleans: true},
+			op: scim.PatchOperation{
+				Op:   "add",
+				Path: path(`emails`),
+				Value: []interface{}{
+					map[string]interface{}{"type": "work", "value": "alice@example.com", "primary": "True"},
+				},
+			},
+			data: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{"type": "work", "value": "alice@example.com", "primary": true},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name:    "without profile",
+			profile: scimpatch.CompatibilityProfile{},
+			op: scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`active`),
+				Value: "False",
+			},
+			data:            map[string]interface{}{"active": true},
+			expected:        map[string]interface{}{"active": "False"},
+			expectedChanged: true,
+		},
+		{
+			name:    "UnwrapSingularValue - simple value",
+			profile: scimpatch.ProfileOneLogin,
+			op: scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`displayName`),
+				Value: []interface{}{map[string]interface{}{"value": "Alice"}},
+			},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{"displayName": "Alice"},
+			expectedChanged: true,
+		},
+		{
+			name:    "UnwrapSingularValue - complex value",
+			profile: scimpatch.ProfileOneLogin,
+			op: scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:manager`),
+				Value: []interface{}{map[string]interface{}{"value": "0001"}},
+			},
+			data: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+					"manager": map[string]interface{}{"value": "0001"},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name:    "UnwrapSingularValue - multi valued attribute is not unwrapped",
+			profile: scimpatch.ProfileOneLogin,
+			op: scim.PatchOperation{
+				Op:    "add",
+				Path:  path(`urn:ivixvi:testSchema:testString`),
+				Value: []interface{}{"value"},
+			},
+			data: map[string]interface{}{},
+			expected: map[string]interface{}{
+				"urn:ivixvi:testSchema": map[string]interface{}{
+					"testString": []interface{}{"value"},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name:    "URNQualifiedKeys",
+			profile: scimpatch.ProfileEntraID,
+			op: scim.PatchOperation{
+				Op: "Add",
+				Value: map[string]interface{}{
+					"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department":    "Sales",
+					"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:manager.value": "0001",
+					"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+						"division": "2B",
+					},
+				},
+			},
+			data: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+					"employeeNumber": "1",
+				},
+			},
+			expected: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+					"department":     "Sales",
+					"division":       "2B",
+					"employeeNumber": "1",
+					"manager":        map[string]interface{}{"value": "0001"},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name:    "SkipReadOnlyAttributes",
+			profile: scimpatch.ProfileOkta,
+			op: scim.PatchOperation{
+				Op: "replace",
+				Value: map[string]interface{}{
+					"id":     "0002",
+					"active": false,
+				},
+			},
+			data:            map[string]interface{}{"id": "0001", "active": true},
+			expected:        map[string]interface{}{"active": false, "id": "0001"},
+			expectedChanged: true,
+		},
+		{
+			name:    "MembersReplaceAsAdd",
+			profile: scimpatch.CompatibilityProfile{MembersReplaceAsAdd: true},
+			schema:  schema.CoreGroupSchema(),
+			op: scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`members`),
+				Value: []interface{}{map[string]interface{}{"value": "2"}},
+			},
+			data: map[string]interface{}{
+				"members": []interface{}{map[string]interface{}{"value": "1"}},
+			},
+			expected: map[string]interface{}{
+				"members": []interface{}{
+					map[string]interface{}{"value": "1"},
+					map[string]interface{}{"value": "2"},
+				},
+			},
+			expectedChanged: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			profile := tc.profile
+			s := tc.schema
+			if s.ID == "" {
+				s = schema.CoreUserSchema()
+			}
+			patcher := scimpatch.NewPatcher(
+				s,
+				[]schema.Schema{
+					schema.ExtensionEnterpriseUser(),
+					TestExtensionSchema,
+				}, &scimpatch.PatcherOpts{Profile: &profile})
+
+			result, changed, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if changed != tc.expectedChanged {
+				t.Errorf("changed:\n    actual  : %v\n    expected: %v", changed, tc.expectedChanged)
+			}
+			if !(fmt.Sprint(result) == fmt.Sprint(tc.expected)) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", result, tc.expected)
+			}
+		})
+	}
+}
+
+// TestProfilesMembersReplace は組み込みのプロファイル毎に members 全体に対する replace の扱いをテストします
+func TestProfilesMembersReplace(t *testing.T) {
+	added := map[string]interface{}{
+		"members": []interface{}{
+			map[string]interface{}{"value": "1"},
+			map[string]interface{}{"value": "2"},
+		},
+	}
+	replaced := map[string]interface{}{
+		"members": []interface{}{
+			map[string]interface{}{"value": "2"},
+		},
+	}
+	testCases := []struct {
+		profile  scimpatch.CompatibilityProfile
+		expected map[string]interface{}
+	}{
+		{profile: scimpatch.ProfileEntraID, expected: added},
+		{profile: scimpatch.ProfileOkta, expected: replaced},
+		{profile: scimpatch.ProfileOneLogin, expected: replaced},
+		{profile: scimpatch.ProfileGoogle, expected: replaced},
+		{profile: scimpatch.ProfileJumpCloud, expected: added},
+	}
+	if len(testCases) != len(scimpatch.Profiles) {
+		t.Fatalf("all profiles must be tested: %d/%d", len(testCases), len(scimpatch.Profiles))
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.profile.Name, func(t *testing.T) {
+			profile := tc.profile
+			patcher := scimpatch.NewPatcher(schema.CoreGroupSchema(), nil, &scimpatch.PatcherOpts{Profile: &profile})
+			op := scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`members`),
+				Value: []interface{}{map[string]interface{}{"value": "2"}},
+			}
+			data := map[string]interface{}{
+				"members": []interface{}{map[string]interface{}{"value": "1"}},
+			}
+			result, changed, err := patcher.Apply(context.TODO(), op, data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if !changed {
+				t.Errorf("changed must be true")
+			}
+			if fmt.Sprint(result) != fmt.Sprint(tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", result, tc.expected)
+			}
+		})
+	}
+}
+
+// TestCapitalizedOpMutability は大文字を含む op でも mutability が検証されることをテストします
+func TestCapitalizedOpMutability(t *testing.T) {
+	patcher := scimpatch.NewPatcher(schema.Schema{
+		ID: "urn:ivixvi:immutableSchema",
+		Attributes: []schema.CoreAttribute{
+			schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{
+				Name:       "code",
+				Mutability: schema.AttributeMutabilityImmutable(),
+			})),
+		},
+	}, nil, nil)
+
+	_, _, err := patcher.Apply(context.TODO(), scim.PatchOperation{
+		Op:    "Replace",
+		Path:  path(`code`),
+		Value: "0002",
+	}, map[string]interface{}{"code": "0001"})
+	scimError, ok := err.(errors.ScimError)
+	if !ok || scimError.ScimType != errors.ScimTypeMutability {
+		t.Fatalf("Apply() not returned Expected ScimError: %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package scimpatch
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+)
+
+// Registry はリソースタイプ毎の Patcher を保持し、リソースタイプの名前やリソースの schemas から Patcher を取得するためのものです。
+type Registry struct {
+	resourceTypes map[string]scim.ResourceType
+	patchers      map[string]*Patcher
+	// bySchema はリソースタイプの主スキーマの ID からリソースタイプの名前を取得するためのものです
+	bySchema map[string]string
+	// byEndpoint はリソースタイプのエンドポイント (e.g. "Users") からリソースタイプの名前を取得するためのものです
+	byEndpoint map[string]string
+}
+
+// NewRegistry は resourceTypes の各スキーマと拡張スキーマから Patcher を作成し、Registry の実態を取得します。
+// opts はすべての Patcher で共通して利用されます。
+// リソースタイプの名前、エンドポイント、主スキーマの ID が重複している場合はエラーを返却します。
+func NewRegistry(resourceTypes []scim.ResourceType, opts *PatcherOpts) (*Registry, error) {
+	r := &Registry{
+		resourceTypes: make(map[string]scim.ResourceType, len(resourceTypes)),
+		patchers:      make(map[string]*Patcher, len(resourceTypes)),
+		bySchema:      make(map[string]string, len(resourceTypes)),
+		byEndpoint:    make(map[string]string, len(resourceTypes)),
+	}
+	for _, rt := range resourceTypes {
+		if rt.Name == "" {
+			return nil, fmt.Errorf("scimpatch: resource type name must not be empty")
+		}
+		if _, ok := r.resourceTypes[rt.Name]; ok {
+			return nil, fmt.Errorf("scimpatch: duplicate resource type name %q", rt.Name)
+		}
+		if name, ok := r.bySchema[rt.Schema.ID]; ok {
+			return nil, fmt.Errorf("scimpatch: resource types %q and %q have the same schema %q", name, rt.Name, rt.Schema.ID)
+		}
+		endpoint := strings.Trim(rt.Endpoint, "/")
+		if name, ok := r.byEndpoint[endpoint]; ok && endpoint != "" {
+			return nil, fmt.Errorf("scimpatch: resource types %q and %q have the same endpoint %q", name, rt.Name, rt.Endpoint)
+		}
+
+		r.resourceTypes[rt.Name] = rt
+		r.patchers[rt.Name] = NewResourceTypePatcher(rt, opts)
+		r.bySchema[rt.Schema.ID] = rt.Name
+		if endpoint != "" {
+			r.byEndpoint[endpoint] = rt.Name
+		}
+	}
+	return r, nil
+}
+
+// NewResourceTypePatcher は rt のスキーマと拡張スキーマを利用する Patcher の実態を取得します。
+// 必須の拡張スキーマは opts の RequiredExtensions に追加されます。
+func NewResourceTypePatcher(rt scim.ResourceType, opts *PatcherOpts) *Patcher {
+	o := PatcherOpts{}
+	if opts != nil {
+		o = *opts
+	}
+	o.RequiredExtensions = append([]string{}, o.RequiredExtensions...)
+	extensions := make([]schema.Schema, 0, len(rt.SchemaExtensions))
+	for _, e := range rt.SchemaExtensions {
+		extensions = append(extensions, e.Schema)
+		if e.Required {
+			o.RequiredExtensions = append(o.RequiredExtensions, e.Schema.ID)
+		}
+	}
+	return NewPatcher(rt.Schema, extensions, &o)
+}
+
+// Patcher はリソースタイプの名前 (e.g. "User") もしくはエンドポイント (e.g. "Users", "/Users") から Patcher を取得します。
+func (r *Registry) Patcher(name string) (*Patcher, bool) {
+	if p, ok := r.patchers[name]; ok {
+		return p, true
+	}
+	if rtName, ok := r.byEndpoint[strings.Trim(name, "/")]; ok {
+		return r.patchers[rtName], true
+	}
+	return nil, false
+}
+
+// PatcherFor はリソースの schemas 属性に含まれる主スキーマから Patcher を取得します。
+// 該当するリソースタイプがない場合は false を返却します。
+func (r *Registry) PatcherFor(data map[string]interface{}) (*Patcher, bool) {
+	rt, ok := r.ResourceTypeFor(data)
+	if !ok {
+		return nil, false
+	}
+	return r.patchers[rt.Name], true
+}
+
+// ResourceType はリソースタイプの名前もしくはエンドポイントから、Registry に登録された scim.ResourceType を取得します。
+func (r *Registry) ResourceType(name string) (scim.ResourceType, bool) {
+	if rt, ok := r.resourceTypes[name]; ok {
+		return rt, true
+	}
+	if rtName, ok := r.byEndpoint[strings.Trim(name, "/")]; ok {
+		return r.resourceTypes[rtName], true
+	}
+	return scim.ResourceType{}, false
+}
+
+// ResourceTypeFor はリソースの schemas 属性に含まれる主スキーマから、Registry に登録された scim.ResourceType を取得します。
+func (r *Registry) ResourceTypeFor(data map[string]interface{}) (scim.ResourceType, bool) {
+	_, schemas, _ := lookupKey(data, "schemas")
+	v := reflect.ValueOf(schemas)
+	if v.Kind() != reflect.Slice {
+		return scim.ResourceType{}, false
+	}
+	for i := 0; i < v.Len(); i++ {
+		id, ok := v.Index(i).Interface().(string)
+		if !ok {
+			continue
+		}
+		if rtName, ok := r.bySchema[id]; ok {
+			return r.resourceTypes[rtName], true
+		}
+	}
+	return scim.ResourceType{}, false
+}
+
+// Patchers はエンドポイント (e.g. "Users") をキーとした Patcher の一覧を返却します。
+// エンドポイントが指定されていないリソースタイプは名前をキーとします。NewPatchHandler に指定することを想定しています。
+func (r *Registry) Patchers() map[string]*Patcher {
+	patchers := make(map[string]*Patcher, len(r.patchers))
+	for name, rt := range r.resourceTypes {
+		key := strings.Trim(rt.Endpoint, "/")
+		if key == "" {
+			key = name
+		}
+		patchers[key] = r.patchers[name]
+	}
+	return patchers
+}
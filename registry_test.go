@@ -0,0 +1,165 @@
+package scimpatch_test
+
+import (
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/optional"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+var testDeviceSchema = schema.Schema{
+	ID:   "urn:ivixvi:schemas:Device",
+	Name: optional.NewString("Device"),
+	Attributes: []schema.CoreAttribute{
+		schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{Name: "serialNumber"})),
+	},
+}
+
+var testResourceTypes = []scim.ResourceType{
+	{
+		Name:     "User",
+		Endpoint: "/Users",
+		Schema:   schema.CoreUserSchema(),
+		SchemaExtensions: []scim.SchemaExtension{
+			{Schema: schema.ExtensionEnterpriseUser(), Required: true},
+		},
+	},
+	{
+		Name:     "Group",
+		Endpoint: "/Groups",
+		Schema:   schema.CoreGroupSchema(),
+	},
+	{
+		Name:   "Device",
+		Schema: testDeviceSchema,
+	},
+}
+
+// TestRegistry は Registry から Patcher を取得できることをテストします
+func TestRegistry(t *testing.T) {
+	registry, err := scimpatch.NewRegistry(testResourceTypes, nil)
+	if err != nil {
+		t.Fatalf("NewRegistry() returned an unexpected error: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		lookup   func() (*scimpatch.Patcher, bool)
+		expected *scimpatch.Patcher
+	}{
+		{
+			name:     "by name",
+			lookup:   func() (*scimpatch.Patcher, bool) { return registry.Patcher("User") },
+			expected: registry.Patchers()["Users"],
+		},
+		{
+			name:     "by endpoint",
+			lookup:   func() (*scimpatch.Patcher, bool) { return registry.Patcher("/Groups") },
+			expected: registry.Patchers()["Groups"],
+		},
+		{
+			name:     "without endpoint",
+			lookup:   func() (*scimpatch.Patcher, bool) { return registry.Patcher("Device") },
+			expected: registry.Patchers()["Device"],
+		},
+		{
+			name: "by schemas",
+			lookup: func() (*scimpatch.Patcher, bool) {
+				return registry.PatcherFor(map[string]interface{}{
+					"schemas": []interface{}{"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User", "urn:ietf:params:scim:schemas:core:2.0:User"},
+				})
+			},
+			expected: registry.Patchers()["Users"],
+		},
+		{
+			name: "by schemas of []string",
+			lookup: func() (*scimpatch.Patcher, bool) {
+				return registry.PatcherFor(map[string]interface{}{"schemas": []string{"urn:ivixvi:schemas:Device"}})
+			},
+			expected: registry.Patchers()["Device"],
+		},
+		{
+			name:     "unknown name",
+			lookup:   func() (*scimpatch.Patcher, bool) { return registry.Patcher("Unknown") },
+			expected: nil,
+		},
+		{
+			name: "unknown schemas",
+			lookup: func() (*scimpatch.Patcher, bool) {
+				return registry.PatcherFor(map[string]interface{}{"schemas": []interface{}{"urn:ivixvi:schemas:Unknown"}})
+			},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, ok := tc.lookup()
+			if ok != (tc.expected != nil) || actual != tc.expected {
+				t.Errorf("patcher:\n    actual  : %p (%t)\n    expected: %p", actual, ok, tc.expected)
+			}
+		})
+	}
+}
+
+// TestRegistryPatchers は Registry.Patchers がエンドポイント毎に異なる Patcher を返却することをテストします
+func TestRegistryPatchers(t *testing.T) {
+	registry, err := scimpatch.NewRegistry(testResourceTypes, nil)
+	if err != nil {
+		t.Fatalf("NewRegistry() returned an unexpected error: %v", err)
+	}
+	patchers := registry.Patchers()
+	if len(patchers) != 3 || patchers["Users"] == nil || patchers["Groups"] == nil || patchers["Device"] == nil || patchers["Users"] == patchers["Groups"] {
+		t.Errorf("unexpected patchers: %v", patchers)
+	}
+	rt, ok := registry.ResourceType("Users")
+	if !ok || rt.Name != "User" || !rt.SchemaExtensions[0].Required {
+		t.Errorf("unexpected resource type: %v", rt)
+	}
+}
+
+// TestNewRegistryError は NewRegistry で重複したリソースタイプがエラーとなることをテストします
+func TestNewRegistryError(t *testing.T) {
+	testCases := []struct {
+		name          string
+		resourceTypes []scim.ResourceType
+	}{
+		{
+			name: "empty name",
+			resourceTypes: []scim.ResourceType{
+				{Schema: testDeviceSchema},
+			},
+		},
+		{
+			name: "duplicate name",
+			resourceTypes: []scim.ResourceType{
+				{Name: "User", Schema: schema.CoreUserSchema()},
+				{Name: "User", Schema: testDeviceSchema},
+			},
+		},
+		{
+			name: "duplicate schema",
+			resourceTypes: []scim.ResourceType{
+				{Name: "User", Schema: schema.CoreUserSchema()},
+				{Name: "Employee", Schema: schema.CoreUserSchema()},
+			},
+		},
+		{
+			name: "duplicate endpoint",
+			resourceTypes: []scim.ResourceType{
+				{Name: "User", Endpoint: "/Users", Schema: schema.CoreUserSchema()},
+				{Name: "Device", Endpoint: "Users", Schema: testDeviceSchema},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := scimpatch.NewRegistry(tc.resourceTypes, nil); err == nil {
+				t.Error("NewRegistry() should return an error")
+			}
+		})
+	}
+}
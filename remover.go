@@ -11,16 +11,85 @@ type remover struct{}
 var removerInstance *remover
 
 func (r *remover) Direct(ctx context.Context, scopedMap map[string]interface{}, scopedAttr string, value interface{}) bool {
-	if _, ok := scopedMap[scopedAttr]; ok {
+	oldValue, ok := scopedMap[scopedAttr]
+	if !ok {
+		return false
+	}
+	switch newValue := value.(type) {
+	case nil:
+	case []interface{}:
+		// Entra ID などは value に削除対象の要素を指定して複数値属性の一部を削除するため、指定された要素のみを削除します
+		// cf. https://learn.microsoft.com/en-us/entra/identity/app-provisioning/use-scim-to-provision-users-and-groups#update-group-remove-members
+		if removeMaps, ok := areEveryItemsMap(newValue); ok {
+			return r.removeMapSlice(scopedMap, scopedAttr, oldValue, removeMaps)
+		}
+		return r.removeSlice(scopedMap, scopedAttr, oldValue, newValue)
+	case []map[string]interface{}:
+		return r.removeMapSlice(scopedMap, scopedAttr, oldValue, newValue)
+	case map[string]interface{}:
+		if _, ok := oldValue.(map[string]interface{}); !ok {
+			return r.removeMapSlice(scopedMap, scopedAttr, oldValue, []map[string]interface{}{newValue})
+		}
+	}
+	delete(scopedMap, scopedAttr)
+	return true
+}
+
+// removeMapSlice は oldValue の要素のうち、removeMaps のいずれかの要素の属性をすべて含む要素を削除します
+func (r *remover) removeMapSlice(scopedMap map[string]interface{}, scopedAttr string, oldValue interface{}, removeMaps []map[string]interface{}) bool {
+	oldMaps, ok := areEveryItemsMap(oldValue)
+	if !ok {
+		return false
+	}
+	changed := false
+	idx := newMapIndex(removeMaps)
+	newMaps := make([]map[string]interface{}, 0, len(oldMaps))
+	for _, oldMap := range oldMaps {
+		if idx.containsSubMapOf(oldMap) {
+			changed = true
+			continue
+		}
+		newMaps = append(newMaps, oldMap)
+	}
+	switch {
+	case !changed:
+	case len(newMaps) == 0:
+		delete(scopedMap, scopedAttr)
+	default:
+		scopedMap[scopedAttr] = newMaps
+	}
+	return changed
+}
+
+// removeSlice は oldValue の要素のうち、removeItems に含まれる要素を削除します
+func (r *remover) removeSlice(scopedMap map[string]interface{}, scopedAttr string, oldValue interface{}, removeItems []interface{}) bool {
+	oldSlice, ok := oldValue.([]interface{})
+	if !ok {
+		return false
+	}
+	changed := false
+	set := newItemSet(removeItems)
+	newSlice := make([]interface{}, 0, len(oldSlice))
+	for _, oldItem := range oldSlice {
+		if set.contains(oldItem) {
+			changed = true
+			continue
+		}
+		newSlice = append(newSlice, oldItem)
+	}
+	switch {
+	case !changed:
+	case len(newSlice) == 0:
 		delete(scopedMap, scopedAttr)
-		return true
+	default:
+		scopedMap[scopedAttr] = newSlice
 	}
-	return false
+	return changed
 }
 
 func (r *remover) ByValueExpressionForItem(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, value interface{}) ([]map[string]interface{}, bool) {
 	changed := false
-	newValues := []map[string]interface{}{}
+	newValues := make([]map[string]interface{}, 0, len(scopedMaps))
 	for _, oldValue := range scopedMaps {
 		if !isMatchExpression(oldValue, expr) {
 			newValues = append(newValues, oldValue)
@@ -33,7 +102,7 @@ func (r *remover) ByValueExpressionForItem(ctx context.Context, scopedMaps []map
 
 func (r *remover) ByValueExpressionForAttribute(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, subAttr string, value interface{}) ([]map[string]interface{}, bool) {
 	changed := false
-	newValues := []map[string]interface{}{}
+	newValues := make([]map[string]interface{}, 0, len(scopedMaps))
 	for _, oldValue := range scopedMaps {
 		if !isMatchExpression(oldValue, expr) {
 			newValues = append(newValues, oldValue)
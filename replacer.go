@@ -2,6 +2,7 @@ package scimpatch
 
 import (
 	"context"
+	"log/slog"
 
 	"github.com/scim2/filter-parser/v2"
 )
@@ -35,8 +36,9 @@ func (r *replacer) replaceMapSlice(scopedMap map[string]interface{}, scopedAttr
 		scopedMap[scopedAttr] = newValue
 		return true
 	}
+	idx := newMapIndex(oldMaps)
 	for _, newMap := range newValue {
-		if !containsMap(oldMaps, newMap) {
+		if !idx.contains(newMap) {
 			scopedMap[scopedAttr] = newValue
 			return true
 		}
@@ -45,7 +47,7 @@ func (r *replacer) replaceMapSlice(scopedMap map[string]interface{}, scopedAttr
 }
 
 func (r *replacer) replaceMap(scopedMap map[string]interface{}, scopedAttr string, newValue map[string]interface{}) bool {
-	oldMap, ok := scopedMap[scopedAttr].(map[string]interface{})
+	oldMap, ok := asMap(scopedMap[scopedAttr])
 	if ok && eqMap(newValue, oldMap) {
 		return false
 	}
@@ -54,6 +56,12 @@ func (r *replacer) replaceMap(scopedMap map[string]interface{}, scopedAttr strin
 }
 
 func (r *replacer) replaceSlice(scopedMap map[string]interface{}, scopedAttr string, newValue []interface{}) bool {
+	// Complex MultiValued
+	// 保存されている値が []map[string]interface{} などの場合も要素を比較するため、型の確認より先に判断します
+	if newMaps, ok := areEveryItemsMap(newValue); ok && len(newMaps) != 0 {
+		return r.replaceMapSlice(scopedMap, scopedAttr, newMaps)
+	}
+
 	oldSlice, ok := scopedMap[scopedAttr].([]interface{})
 	// oldSlice is nil
 	if !ok || len(oldSlice) != len(newValue) {
@@ -61,14 +69,10 @@ func (r *replacer) replaceSlice(scopedMap map[string]interface{}, scopedAttr str
 		return true
 	}
 
-	// Complex MultiValued
-	if newMaps, ok := areEveryItemsMap(newValue); ok {
-		return r.replaceMapSlice(scopedMap, scopedAttr, newMaps)
-	}
-
 	// Singular MultiValued
+	set := newItemSet(oldSlice)
 	for _, newItem := range newValue {
-		if !containsItem(oldSlice, newItem) {
+		if !set.contains(newItem) {
 			scopedMap[scopedAttr] = newValue
 			return true
 		}
@@ -78,7 +82,7 @@ func (r *replacer) replaceSlice(scopedMap map[string]interface{}, scopedAttr str
 }
 
 func (r *replacer) replaceValue(scopedMap map[string]interface{}, scopedAttr string, newValue interface{}) bool {
-	if oldValue, ok := scopedMap[scopedAttr]; !ok || oldValue != newValue {
+	if oldValue, ok := scopedMap[scopedAttr]; !ok || !eqValue(oldValue, newValue) {
 		scopedMap[scopedAttr] = newValue
 		return true
 	}
@@ -86,11 +90,10 @@ func (r *replacer) replaceValue(scopedMap map[string]interface{}, scopedAttr str
 }
 
 func (r *replacer) ByValueExpressionForItem(ctx context.Context, scopedMaps []map[string]interface{}, expr filter.Expression, value interface{}) ([]map[string]interface{}, bool) {
-	logger := getLogger(ctx)
 	newValue, ok := value.(map[string]interface{})
 
 	if !ok {
-		logger.Debug("unexpected input")
+		logEvent(ctx, slog.LevelWarn, "unexpected input: value for a filtered item is not an object; operation ignored")
 		return scopedMaps, false
 	}
 
@@ -121,7 +124,7 @@ func replaceByValueExpressionForAttribute(
 		if isMatchExpression(oldValue, expr) {
 			found = true
 			oldAttrValue, ok := oldValue[subAttr]
-			if !ok || oldAttrValue != value {
+			if !ok || !eqValue(oldAttrValue, value) {
 				changed = true
 				oldValue[subAttr] = value
 			}
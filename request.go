@@ -0,0 +1,117 @@
+package scimpatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// PatchOpSchema は PATCH リクエストのボディの schemas に指定される URI です。
+// cf. https://datatracker.ietf.org/doc/html/rfc7644#section-3.5.2
+const PatchOpSchema = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+
+// patchRequest は PATCH リクエストのボディです。
+type patchRequest struct {
+	Schemas    []string          `json:"schemas"`
+	Operations []json.RawMessage `json:"Operations"`
+}
+
+// patchRequestOperation は PATCH リクエストの Operations の各要素です。
+type patchRequestOperation struct {
+	Op    *string     `json:"op"`
+	Path  *string     `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// ParsePatchRequest は PATCH リクエストのボディを検証し、[]scim.PatchOperation に変換します。
+// elimity-com/scim の scim.Server を利用せずに、HTTP のリクエストボディから直接 Patcher を利用するためのものです。
+//
+// 以下の場合はエラーとして errors.ScimError を返却します。
+//   - ボディが JSON として不正な場合 (invalidSyntax)
+//   - schemas が PatchOpSchema のみではない、または Operations が空の場合 (invalidValue)
+//   - op が add, replace, remove のいずれでもない場合 (invalidValue)。なお、op は大文字小文字を区別しません
+//   - path が filter-parser で解析できない場合 (invalidPath)
+func ParsePatchRequest(body []byte) ([]scim.PatchOperation, error) {
+	var req patchRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, scimError(errors.ScimErrorInvalidSyntax, "The request body is not a valid JSON: %v", err)
+	}
+	if len(req.Schemas) != 1 || req.Schemas[0] != PatchOpSchema {
+		return nil, scimError(errors.ScimErrorInvalidValue, "The request body must contain the \"schemas\" attribute with the value %q.", PatchOpSchema)
+	}
+	if len(req.Operations) == 0 {
+		return nil, scimError(errors.ScimErrorInvalidValue, "The request body must contain at least one operation in the \"Operations\" attribute.")
+	}
+
+	ops := make([]scim.PatchOperation, 0, len(req.Operations))
+	for i, raw := range req.Operations {
+		op, err := parsePatchOperation(raw)
+		if err != nil {
+			err.Detail = fmt.Sprintf("Operations[%d]: %s", i, err.Detail)
+			return nil, *err
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// parsePatchOperation は Operations の1要素を検証し、scim.PatchOperation に変換します。
+func parsePatchOperation(raw json.RawMessage) (scim.PatchOperation, *errors.ScimError) {
+	var o patchRequestOperation
+	if err := json.Unmarshal(raw, &o); err != nil {
+		e := scimError(errors.ScimErrorInvalidSyntax, "The operation is not a valid JSON object: %v", err)
+		return scim.PatchOperation{}, &e
+	}
+	if o.Op == nil {
+		e := scimError(errors.ScimErrorInvalidValue, "The \"op\" attribute is required.")
+		return scim.PatchOperation{}, &e
+	}
+
+	op := scim.PatchOperation{Op: strings.ToLower(*o.Op), Value: o.Value}
+	switch op.Op {
+	case scim.PatchOperationAdd, scim.PatchOperationReplace, scim.PatchOperationRemove:
+	default:
+		e := scimError(errors.ScimErrorInvalidValue, "The \"op\" attribute %q is not one of \"add\", \"replace\" or \"remove\".", *o.Op)
+		return scim.PatchOperation{}, &e
+	}
+
+	if o.Path != nil && *o.Path != "" {
+		p, err := filter.ParsePath([]byte(*o.Path))
+		if err != nil {
+			e := scimError(errors.ScimErrorInvalidPath, "The \"path\" attribute %q could not be parsed: %v", *o.Path, err)
+			return scim.PatchOperation{}, &e
+		}
+		op.Path = &p
+	}
+	if op.Op != scim.PatchOperationRemove && op.Value == nil {
+		e := scimError(errors.ScimErrorInvalidValue, "The \"value\" attribute is required for the %q operation.", op.Op)
+		return scim.PatchOperation{}, &e
+	}
+	return op, nil
+}
+
+// ApplyRequest は PATCH リクエストのボディを ParsePatchRequest で検証したうえで data に適用します。
+// RFC7644 の通り、いずれかの op が失敗した場合は data を一切変更せずにエラーを返却します。
+// cf. https://datatracker.ietf.org/doc/html/rfc7644#section-3.5.2
+func (p *Patcher) ApplyRequest(ctx context.Context, body []byte, data map[string]interface{}) (map[string]interface{}, bool, error) {
+	ops, err := ParsePatchRequest(body)
+	if err != nil {
+		return data, false, err
+	}
+	patched, changed, err := p.ApplyOperations(ctx, ops, copyMap(data))
+	if err != nil {
+		return data, false, err
+	}
+	return patched, changed, nil
+}
+
+// scimError は base に詳細なメッセージを設定したエラーを作成します。
+func scimError(base errors.ScimError, format string, args ...interface{}) errors.ScimError {
+	base.Detail = fmt.Sprintf(format, args...)
+	return base
+}
@@ -0,0 +1,149 @@
+package scimpatch_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestParsePatchRequest は ParsePatchRequest で正常に変換できるリクエストをテストします
+func TestParsePatchRequest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		expected []scim.PatchOperation
+	}{
+		{
+			name: "single operation",
+			body: `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"replace","path":"active","value":false}]}`,
+			expected: []scim.PatchOperation{
+				{Op: "replace", Path: path(`active`), Value: false},
+			},
+		},
+		{
+			name: "capitalized op and value filter path",
+			body: `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+				{"op":"Add","path":"emails[type eq \"work\"].value","value":"alice@example.com"},
+				{"op":"Remove","path":"members[value eq \"0001\"]"}
+			]}`,
+			expected: []scim.PatchOperation{
+				{Op: "add", Path: path(`emails[type eq "work"].value`), Value: "alice@example.com"},
+				{Op: "remove", Path: path(`members[value eq "0001"]`)},
+			},
+		},
+		{
+			name: "path not specified",
+			body: `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"add","value":{"title":"Engineer"}}]}`,
+			expected: []scim.PatchOperation{
+				{Op: "add", Value: map[string]interface{}{"title": "Engineer"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := scimpatch.ParsePatchRequest([]byte(tc.body))
+			if err != nil {
+				t.Fatalf("ParsePatchRequest() returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("operations:\n    actual  : %#v\n    expected: %#v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+// TestParsePatchRequestError は ParsePatchRequest で不正なリクエストがエラーとなることをテストします
+func TestParsePatchRequestError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		body     string
+		expected errors.ScimType
+	}{
+		{
+			name:     "invalid json",
+			body:     `{"schemas":`,
+			expected: errors.ScimTypeInvalidSyntax,
+		},
+		{
+			name:     "missing schemas",
+			body:     `{"Operations":[{"op":"add","path":"title","value":"Engineer"}]}`,
+			expected: errors.ScimTypeInvalidValue,
+		},
+		{
+			name:     "unexpected schemas",
+			body:     `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"Operations":[{"op":"add","path":"title","value":"Engineer"}]}`,
+			expected: errors.ScimTypeInvalidValue,
+		},
+		{
+			name:     "empty operations",
+			body:     `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[]}`,
+			expected: errors.ScimTypeInvalidValue,
+		},
+		{
+			name:     "missing op",
+			body:     `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"path":"title","value":"Engineer"}]}`,
+			expected: errors.ScimTypeInvalidValue,
+		},
+		{
+			name:     "unknown op",
+			body:     `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"move","path":"title","value":"Engineer"}]}`,
+			expected: errors.ScimTypeInvalidValue,
+		},
+		{
+			name:     "missing value",
+			body:     `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"add","path":"title"}]}`,
+			expected: errors.ScimTypeInvalidValue,
+		},
+		{
+			name:     "malformed path",
+			body:     `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[{"op":"remove","path":"emails[type eq"}]}`,
+			expected: errors.ScimTypeInvalidPath,
+		},
+		{
+			name:     "operation is not an object",
+			body:     `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":["add"]}`,
+			expected: errors.ScimTypeInvalidSyntax,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := scimpatch.ParsePatchRequest([]byte(tc.body))
+			scimErr, ok := err.(errors.ScimError)
+			if !ok {
+				t.Fatalf("ParsePatchRequest() returned an unexpected error: %v", err)
+			}
+			if scimErr.ScimType != tc.expected {
+				t.Errorf("scimType:\n    actual  : %s\n    expected: %s", scimErr.ScimType, tc.expected)
+			}
+			if scimErr.Detail == "" {
+				t.Error("detail should not be empty")
+			}
+		})
+	}
+}
+
+// TestApplyRequest は Patcher.ApplyRequest が途中の op で失敗した場合に data を変更しないことをテストします
+func TestApplyRequest(t *testing.T) {
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, nil)
+	body := `{"schemas":["urn:ietf:params:scim:api:messages:2.0:PatchOp"],"Operations":[
+		{"op":"replace","path":"title","value":"Manager"},
+		{"op":"replace","path":"unknownAttribute","value":"x"}
+	]}`
+	data := map[string]interface{}{"title": "Engineer"}
+
+	actual, changed, err := patcher.ApplyRequest(context.TODO(), []byte(body), data)
+	if err == nil {
+		t.Fatal("ApplyRequest() should return an error")
+	}
+	expected := map[string]interface{}{"title": "Engineer"}
+	if changed || !reflect.DeepEqual(actual, expected) || !reflect.DeepEqual(data, expected) {
+		t.Errorf("data should not be changed:\n    actual  : %v\n    data    : %v\n    expected: %v", actual, data, expected)
+	}
+}
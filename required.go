@@ -0,0 +1,101 @@
+package scimpatch
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// requiredExtensionOf は path が必須の拡張スキーマ全体 (e.g. `urn:ietf:params:scim:schemas:extension:enterprise:2.0:User`) を示しているかを判断します。
+// filter-parser は拡張スキーマの URN の最後の要素を属性名として解析するため、URIPrefix と属性名を結合して比較します。
+func (p *Patcher) requiredExtensionOf(path *filter.Path) (schema.Schema, bool) {
+	if path == nil || path.ValueExpression != nil || path.SubAttribute != nil || path.AttributePath.SubAttribute != nil {
+		return schema.Schema{}, false
+	}
+	urn := path.AttributePath.AttributeName
+	if path.AttributePath.URIPrefix != nil {
+		urn = *path.AttributePath.URIPrefix + ":" + urn
+	}
+	for _, extension := range p.required {
+		if extension.ID == urn {
+			return extension, true
+		}
+	}
+	return schema.Schema{}, false
+}
+
+// requiredExtensionValues は必須の拡張スキーマ毎に、data に保存されている値の複製を取得します。
+// data に保存されていない拡張スキーマは含まれません。
+func (p *Patcher) requiredExtensionValues(data map[string]interface{}) map[string]interface{} {
+	if len(p.required) == 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(p.required))
+	for _, extension := range p.required {
+		if value, ok := data[extension.ID]; ok {
+			values[extension.ID] = copyValue(value)
+		}
+	}
+	return values
+}
+
+// validateRequiredExtensions は op の適用によって変更された必須の拡張スキーマについて、
+// 拡張スキーマ自体と必須の属性が data に含まれているかを確認します。
+// 変更されていない拡張スキーマは確認しないため、既に必須の属性が欠けているリソースに対する無関係な op は失敗しません。
+func (p *Patcher) validateRequiredExtensions(before map[string]interface{}, data map[string]interface{}) error {
+	for _, extension := range p.required {
+		after := data[extension.ID]
+		if reflect.DeepEqual(before[extension.ID], after) {
+			continue
+		}
+		extMap, ok := asMap(after)
+		if !ok || len(extMap) == 0 {
+			return requiredExtensionError(extension.ID)
+		}
+		for _, attr := range extension.Attributes {
+			if !attr.Required() {
+				continue
+			}
+			if _, value, ok := lookupKey(extMap, attr.Name()); !ok || isEmptyValue(value) {
+				err := errors.ScimErrorInvalidValue
+				err.Detail = fmt.Sprintf("The required attribute %q of the schema extension %q must not be removed.", attr.Name(), extension.ID)
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// restoreRequiredExtensions は data の必須の拡張スキーマを requiredExtensionValues で取得した before の値に戻します。
+func (p *Patcher) restoreRequiredExtensions(data map[string]interface{}, before map[string]interface{}) {
+	for _, extension := range p.required {
+		if value, ok := before[extension.ID]; ok {
+			data[extension.ID] = value
+		} else {
+			delete(data, extension.ID)
+		}
+	}
+}
+
+// requiredExtensionError は必須の拡張スキーマが削除された場合のエラーを作成します。
+func requiredExtensionError(id string) errors.ScimError {
+	err := errors.ScimErrorInvalidValue
+	err.Detail = fmt.Sprintf("The schema extension %q is required for the resource type and must not be removed.", id)
+	return err
+}
+
+// isEmptyValue は value が未設定とみなせる値 (nil, 空の配列, 空の map) であるかを判断します。
+func isEmptyValue(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package scimpatch_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/optional"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+var testEmployeeSchema = schema.Schema{
+	ID:   "urn:ivixvi:schemas:Employee",
+	Name: optional.NewString("Employee"),
+	Attributes: []schema.CoreAttribute{
+		schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{Name: "employeeNumber", Required: true})),
+		schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{Name: "costCenter"})),
+	},
+}
+
+// TestRequiredExtensions は必須の拡張スキーマを持つリソースタイプに対する ApplyOperations をテストします
+func TestRequiredExtensions(t *testing.T) {
+	testCases := []struct {
+		name          string
+		ops           []scim.PatchOperation
+		data          map[string]interface{}
+		expected      map[string]interface{}
+		expectedError bool
+	}{
+		{
+			name: "remove required enterprise extension",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User`)},
+			},
+			data: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{"department": "Sales"},
+			},
+			expectedError: true,
+		},
+		{
+			name: "remove required custom extension",
+			ops: []scim.PatchOperation{
+				{Op: "Remove", Path: path(`urn:ivixvi:schemas:Employee`)},
+			},
+			data: map[string]interface{}{
+				"urn:ivixvi:schemas:Employee": map[string]interface{}{"employeeNumber": "0001"},
+			},
+			expectedError: true,
+		},
+		{
+			name: "remove required attribute",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`urn:ivixvi:schemas:Employee:employeeNumber`)},
+			},
+			data: map[string]interface{}{
+				"urn:ivixvi:schemas:Employee": map[string]interface{}{"employeeNumber": "0001", "costCenter": "A"},
+			},
+			expectedError: true,
+		},
+		{
+			name: "add extension without required attribute",
+			ops: []scim.PatchOperation{
+				{Op: "add", Path: path(`urn:ivixvi:schemas:Employee:costCenter`), Value: "A"},
+			},
+			data:          map[string]interface{}{},
+			expectedError: true,
+		},
+		{
+			name: "remove and add required attribute in the same request",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`urn:ivixvi:schemas:Employee:employeeNumber`)},
+				{Op: "add", Path: path(`urn:ivixvi:schemas:Employee:employeeNumber`), Value: "0002"},
+			},
+			data: map[string]interface{}{
+				"urn:ivixvi:schemas:Employee": map[string]interface{}{"employeeNumber": "0001"},
+			},
+			expected: map[string]interface{}{
+				"urn:ivixvi:schemas:Employee": map[string]interface{}{"employeeNumber": "0002"},
+			},
+		},
+		{
+			name: "remove optional attribute",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`urn:ivixvi:schemas:Employee:costCenter`)},
+			},
+			data: map[string]interface{}{
+				"urn:ivixvi:schemas:Employee": map[string]interface{}{"employeeNumber": "0001", "costCenter": "A"},
+			},
+			expected: map[string]interface{}{
+				"urn:ivixvi:schemas:Employee": map[string]interface{}{"employeeNumber": "0001"},
+			},
+		},
+		{
+			name: "unrelated operation on a resource missing required attributes",
+			ops: []scim.PatchOperation{
+				{Op: "replace", Path: path(`userName`), Value: "bob"},
+			},
+			data:     map[string]interface{}{"userName": "alice"},
+			expected: map[string]interface{}{"userName": "bob"},
+		},
+	}
+
+	patcher := scimpatch.NewResourceTypePatcher(scim.ResourceType{
+		Name:   "User",
+		Schema: schema.CoreUserSchema(),
+		SchemaExtensions: []scim.SchemaExtension{
+			{Schema: schema.ExtensionEnterpriseUser(), Required: true},
+			{Schema: testEmployeeSchema, Required: true},
+		},
+	}, nil)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, _, err := patcher.ApplyOperations(context.TODO(), tc.ops, tc.data)
+			if tc.expectedError {
+				scimErr, ok := err.(errors.ScimError)
+				if !ok || scimErr.ScimType != errors.ScimTypeInvalidValue {
+					t.Fatalf("ApplyOperations() should return invalidValue: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyOperations() returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+// TestRequiredExtensionsApply は必須の拡張スキーマを持つリソースタイプに対する Apply が、失敗した場合に data を変更しないことをテストします
+func TestRequiredExtensionsApply(t *testing.T) {
+	testCases := []struct {
+		name          string
+		op            scim.PatchOperation
+		expected      map[string]interface{}
+		expectedError bool
+	}{
+		{
+			name:          "remove required attribute",
+			op:            scim.PatchOperation{Op: "remove", Path: path(`urn:ivixvi:schemas:Employee:employeeNumber`)},
+			expectedError: true,
+		},
+		{
+			name:          "remove required extension",
+			op:            scim.PatchOperation{Op: "remove", Path: path(`urn:ivixvi:schemas:Employee`)},
+			expectedError: true,
+		},
+		{
+			name: "remove optional attribute",
+			op:   scim.PatchOperation{Op: "remove", Path: path(`urn:ivixvi:schemas:Employee:costCenter`)},
+			expected: map[string]interface{}{
+				"userName":                    "alice",
+				"emails":                      []interface{}{map[string]interface{}{"value": "alice@example.com"}},
+				"urn:ivixvi:schemas:Employee": map[string]interface{}{"employeeNumber": "701984"},
+			},
+		},
+	}
+
+	patcher := scimpatch.NewResourceTypePatcher(scim.ResourceType{
+		Name:   "User",
+		Schema: schema.CoreUserSchema(),
+		SchemaExtensions: []scim.SchemaExtension{
+			{Schema: testEmployeeSchema, Required: true},
+		},
+	}, nil)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			emails := []interface{}{map[string]interface{}{"value": "alice@example.com"}}
+			data := map[string]interface{}{
+				"userName":                    "alice",
+				"emails":                      emails,
+				"urn:ivixvi:schemas:Employee": map[string]interface{}{"employeeNumber": "701984", "costCenter": "4130"},
+			}
+			original := map[string]interface{}{
+				"userName":                    "alice",
+				"emails":                      []interface{}{map[string]interface{}{"value": "alice@example.com"}},
+				"urn:ivixvi:schemas:Employee": map[string]interface{}{"employeeNumber": "701984", "costCenter": "4130"},
+			}
+			actual, changed, err := patcher.Apply(context.TODO(), tc.op, data)
+			if tc.expectedError {
+				scimErr, ok := err.(errors.ScimError)
+				if !ok || scimErr.ScimType != errors.ScimTypeInvalidValue {
+					t.Fatalf("Apply() should return invalidValue: %v", err)
+				}
+				if changed {
+					t.Errorf("changed must be false")
+				}
+				if !reflect.DeepEqual(actual, original) || !reflect.DeepEqual(data, original) {
+					t.Errorf("data must not be modified:\n    actual  : %v\n    data    : %v\n    expected: %v", actual, data, original)
+				}
+				// 必須の拡張スキーマ以外の属性は複製されずにそのまま保持されます
+				if reflect.ValueOf(actual["emails"]).Pointer() != reflect.ValueOf(emails).Pointer() {
+					t.Errorf("attributes other than the required extensions must not be copied")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(actual, tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package scimpatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/elimity-com/scim/optional"
+	"github.com/elimity-com/scim/schema"
+)
+
+// schemaDocument は RFC7643 7. Schema Definition の JSON 表現です。
+// cf. https://datatracker.ietf.org/doc/html/rfc7643#section-7
+type schemaDocument struct {
+	ID          string              `json:"id"`
+	Name        *string             `json:"name"`
+	Description *string             `json:"description"`
+	Attributes  []attributeDocument `json:"attributes"`
+}
+
+// attributeDocument はスキーマの attributes の各要素の JSON 表現です。
+type attributeDocument struct {
+	Name            string              `json:"name"`
+	Type            string              `json:"type"`
+	MultiValued     bool                `json:"multiValued"`
+	Description     *string             `json:"description"`
+	Required        bool                `json:"required"`
+	CanonicalValues []string            `json:"canonicalValues"`
+	CaseExact       bool                `json:"caseExact"`
+	Mutability      string              `json:"mutability"`
+	Returned        string              `json:"returned"`
+	Uniqueness      string              `json:"uniqueness"`
+	ReferenceTypes  []string            `json:"referenceTypes"`
+	SubAttributes   []attributeDocument `json:"subAttributes"`
+}
+
+// attributeNamePattern は elimity-com/scim が受け付ける属性名です。
+// schema の各コンストラクタは不正な属性名で panic するため、事前に確認します。
+// cf. https://datatracker.ietf.org/doc/html/rfc7643#section-2.1
+var attributeNamePattern = regexp.MustCompile(`^[A-Za-z][\w$-]*$`)
+
+// subAttributeNamePattern はサブ属性名として受け付ける名前です。属性名に加えて "$ref" を受け付けます。
+var subAttributeNamePattern = regexp.MustCompile(`^(\$ref|[A-Za-z][\w$-]*)$`)
+
+// LoadSchemas は /Schemas エンドポイントが返却する形式の JSON からスキーマを読み込みます。
+// data には単一のスキーマ、スキーマの配列、Resources にスキーマを含む ListResponse のいずれかを指定できます。
+// 読み込んだスキーマは NewPatcher の引数としてそのまま利用できます。
+//
+// なお、elimity-com/scim の制約により、canonicalValues と caseExact は string 型の属性でのみ反映され、
+// 複合属性のサブ属性に複合属性を指定することはできません。
+func LoadSchemas(data []byte) ([]schema.Schema, error) {
+	trimmed := bytes.TrimSpace(data)
+	var docs []schemaDocument
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		if err := json.Unmarshal(trimmed, &docs); err != nil {
+			return nil, fmt.Errorf("scimpatch: failed to decode schemas: %w", err)
+		}
+	default:
+		var list struct {
+			Resources []schemaDocument `json:"Resources"`
+		}
+		if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, fmt.Errorf("scimpatch: failed to decode schemas: %w", err)
+		}
+		if list.Resources != nil {
+			docs = list.Resources
+			break
+		}
+		var doc schemaDocument
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("scimpatch: failed to decode schema: %w", err)
+		}
+		docs = []schemaDocument{doc}
+	}
+
+	schemas := make([]schema.Schema, 0, len(docs))
+	for _, doc := range docs {
+		s, err := doc.toSchema()
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, s)
+	}
+	return schemas, nil
+}
+
+// LoadSchema は単一のスキーマの JSON からスキーマを読み込みます。
+func LoadSchema(data []byte) (schema.Schema, error) {
+	var doc schemaDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return schema.Schema{}, fmt.Errorf("scimpatch: failed to decode schema: %w", err)
+	}
+	return doc.toSchema()
+}
+
+func (doc schemaDocument) toSchema() (schema.Schema, error) {
+	if doc.ID == "" {
+		return schema.Schema{}, fmt.Errorf("scimpatch: schema id must not be empty")
+	}
+	s := schema.Schema{
+		ID:          doc.ID,
+		Name:        optionalString(doc.Name),
+		Description: optionalString(doc.Description),
+		Attributes:  make([]schema.CoreAttribute, 0, len(doc.Attributes)),
+	}
+	names := make(map[string]struct{}, len(doc.Attributes))
+	for _, attrDoc := range doc.Attributes {
+		if _, ok := names[strings.ToLower(attrDoc.Name)]; ok {
+			return schema.Schema{}, fmt.Errorf("scimpatch: schema %q: duplicate attribute %q", doc.ID, attrDoc.Name)
+		}
+		names[strings.ToLower(attrDoc.Name)] = struct{}{}
+		attr, err := attrDoc.toCoreAttribute()
+		if err != nil {
+			return schema.Schema{}, fmt.Errorf("scimpatch: schema %q: %w", doc.ID, err)
+		}
+		s.Attributes = append(s.Attributes, attr)
+	}
+	return s, nil
+}
+
+func (doc attributeDocument) toCoreAttribute() (schema.CoreAttribute, error) {
+	if !attributeNamePattern.MatchString(doc.Name) {
+		return schema.CoreAttribute{}, fmt.Errorf("invalid attribute name %q", doc.Name)
+	}
+	if !strings.EqualFold(doc.Type, "complex") {
+		params, err := doc.toSimpleParams()
+		if err != nil {
+			return schema.CoreAttribute{}, err
+		}
+		return schema.SimpleCoreAttribute(params), nil
+	}
+
+	common, err := doc.characteristics()
+	if err != nil {
+		return schema.CoreAttribute{}, err
+	}
+	subAttributes := make([]schema.SimpleParams, 0, len(doc.SubAttributes))
+	names := make(map[string]struct{}, len(doc.SubAttributes))
+	for _, subDoc := range doc.SubAttributes {
+		if _, ok := names[strings.ToLower(subDoc.Name)]; ok {
+			return schema.CoreAttribute{}, fmt.Errorf("attribute %q: duplicate sub-attribute %q", doc.Name, subDoc.Name)
+		}
+		names[strings.ToLower(subDoc.Name)] = struct{}{}
+		if !subAttributeNamePattern.MatchString(subDoc.Name) {
+			return schema.CoreAttribute{}, fmt.Errorf("attribute %q: invalid sub-attribute name %q", doc.Name, subDoc.Name)
+		}
+		if strings.EqualFold(subDoc.Type, "complex") {
+			return schema.CoreAttribute{}, fmt.Errorf("attribute %q: sub-attribute %q must not be complex", doc.Name, subDoc.Name)
+		}
+		params, err := subDoc.toSimpleParams()
+		if err != nil {
+			return schema.CoreAttribute{}, fmt.Errorf("attribute %q: %w", doc.Name, err)
+		}
+		subAttributes = append(subAttributes, params)
+	}
+	return schema.ComplexCoreAttribute(schema.ComplexParams{
+		Description:   common.description,
+		MultiValued:   doc.MultiValued,
+		Mutability:    common.mutability,
+		Name:          doc.Name,
+		Required:      doc.Required,
+		Returned:      common.returned,
+		SubAttributes: subAttributes,
+		Uniqueness:    common.uniqueness,
+	}), nil
+}
+
+func (doc attributeDocument) toSimpleParams() (schema.SimpleParams, error) {
+	c, err := doc.characteristics()
+	if err != nil {
+		return schema.SimpleParams{}, err
+	}
+	switch strings.ToLower(doc.Type) {
+	case "string":
+		return schema.SimpleStringParams(schema.StringParams{
+			CanonicalValues: doc.CanonicalValues,
+			CaseExact:       doc.CaseExact,
+			Description:     c.description,
+			MultiValued:     doc.MultiValued,
+			Mutability:      c.mutability,
+			Name:            doc.Name,
+			Required:        doc.Required,
+			Returned:        c.returned,
+			Uniqueness:      c.uniqueness,
+		}), nil
+	case "boolean":
+		return schema.SimpleBooleanParams(schema.BooleanParams{
+			Description: c.description,
+			MultiValued: doc.MultiValued,
+			Mutability:  c.mutability,
+			Name:        doc.Name,
+			Required:    doc.Required,
+			Returned:    c.returned,
+		}), nil
+	case "decimal", "integer":
+		typ := schema.AttributeTypeDecimal()
+		if strings.EqualFold(doc.Type, "integer") {
+			typ = schema.AttributeTypeInteger()
+		}
+		return schema.SimpleNumberParams(schema.NumberParams{
+			Description: c.description,
+			MultiValued: doc.MultiValued,
+			Mutability:  c.mutability,
+			Name:        doc.Name,
+			Required:    doc.Required,
+			Returned:    c.returned,
+			Type:        typ,
+			Uniqueness:  c.uniqueness,
+		}), nil
+	case "datetime":
+		return schema.SimpleDateTimeParams(schema.DateTimeParams{
+			Description: c.description,
+			MultiValued: doc.MultiValued,
+			Mutability:  c.mutability,
+			Name:        doc.Name,
+			Required:    doc.Required,
+			Returned:    c.returned,
+		}), nil
+	case "reference":
+		referenceTypes := make([]schema.AttributeReferenceType, 0, len(doc.ReferenceTypes))
+		for _, t := range doc.ReferenceTypes {
+			referenceTypes = append(referenceTypes, schema.AttributeReferenceType(t))
+		}
+		return schema.SimpleReferenceParams(schema.ReferenceParams{
+			Description:    c.description,
+			MultiValued:    doc.MultiValued,
+			Mutability:     c.mutability,
+			Name:           doc.Name,
+			ReferenceTypes: referenceTypes,
+			Required:       doc.Required,
+			Returned:       c.returned,
+			Uniqueness:     c.uniqueness,
+		}), nil
+	case "binary":
+		return schema.SimpleBinaryParams(schema.BinaryParams{
+			Description: c.description,
+			MultiValued: doc.MultiValued,
+			Mutability:  c.mutability,
+			Name:        doc.Name,
+			Required:    doc.Required,
+			Returned:    c.returned,
+		}), nil
+	}
+	return schema.SimpleParams{}, fmt.Errorf("attribute %q: unknown type %q", doc.Name, doc.Type)
+}
+
+// attributeCharacteristics は属性の型にかかわらず共通する特性です。
+type attributeCharacteristics struct {
+	description optional.String
+	mutability  schema.AttributeMutability
+	returned    schema.AttributeReturned
+	uniqueness  schema.AttributeUniqueness
+}
+
+// characteristics は mutability, returned, uniqueness を変換します。
+// 指定されていない場合は RFC7643 のデフォルト値となります。
+// cf. https://datatracker.ietf.org/doc/html/rfc7643#section-2.2
+func (doc attributeDocument) characteristics() (attributeCharacteristics, error) {
+	c := attributeCharacteristics{description: optionalString(doc.Description)}
+
+	switch strings.ToLower(doc.Mutability) {
+	case "", "readwrite":
+		c.mutability = schema.AttributeMutabilityReadWrite()
+	case "immutable":
+		c.mutability = schema.AttributeMutabilityImmutable()
+	case "readonly":
+		c.mutability = schema.AttributeMutabilityReadOnly()
+	case "writeonly":
+		c.mutability = schema.AttributeMutabilityWriteOnly()
+	default:
+		return c, fmt.Errorf("attribute %q: unknown mutability %q", doc.Name, doc.Mutability)
+	}
+
+	switch strings.ToLower(doc.Returned) {
+	case "", "default":
+		c.returned = schema.AttributeReturnedDefault()
+	case "always":
+		c.returned = schema.AttributeReturnedAlways()
+	case "never":
+		c.returned = schema.AttributeReturnedNever()
+	case "request":
+		c.returned = schema.AttributeReturnedRequest()
+	default:
+		return c, fmt.Errorf("attribute %q: unknown returned %q", doc.Name, doc.Returned)
+	}
+
+	switch strings.ToLower(doc.Uniqueness) {
+	case "", "none":
+		c.uniqueness = schema.AttributeUniquenessNone()
+	case "server":
+		c.uniqueness = schema.AttributeUniquenessServer()
+	case "global":
+		c.uniqueness = schema.AttributeUniquenessGlobal()
+	default:
+		return c, fmt.Errorf("attribute %q: unknown uniqueness %q", doc.Name, doc.Uniqueness)
+	}
+	return c, nil
+}
+
+func optionalString(s *string) optional.String {
+	if s == nil {
+		return optional.String{}
+	}
+	return optional.NewString(*s)
+}
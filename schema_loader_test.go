@@ -0,0 +1,137 @@
+package scimpatch_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestLoadSchemaRoundTrip は JSON に変換したスキーマを LoadSchema で読み込むと元のスキーマと同じ JSON になることをテストします
+func TestLoadSchemaRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name   string
+		schema schema.Schema
+	}{
+		{name: "core user", schema: schema.CoreUserSchema()},
+		{name: "core group", schema: schema.CoreGroupSchema()},
+		{name: "enterprise user", schema: schema.ExtensionEnterpriseUser()},
+		{name: "test extension", schema: TestExtensionSchema},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expected, err := json.Marshal(tc.schema)
+			if err != nil {
+				t.Fatalf("failed to encode schema: %v", err)
+			}
+			loaded, err := scimpatch.LoadSchema(expected)
+			if err != nil {
+				t.Fatalf("LoadSchema() returned an unexpected error: %v", err)
+			}
+			actual, err := json.Marshal(loaded)
+			if err != nil {
+				t.Fatalf("failed to encode loaded schema: %v", err)
+			}
+			if string(actual) != string(expected) {
+				t.Errorf("schema:\n    actual  : %s\n    expected: %s", actual, expected)
+			}
+		})
+	}
+}
+
+const testCustomSchemaJSON = `{
+	"id": "urn:ivixvi:schemas:Badge",
+	"name": "Badge",
+	"attributes": [
+		{"name": "badgeNumber", "type": "string", "required": true, "caseExact": true, "mutability": "immutable", "uniqueness": "server"},
+		{"name": "level", "type": "string", "canonicalValues": ["bronze", "silver", "gold"]},
+		{"name": "rooms", "type": "complex", "multiValued": true, "subAttributes": [
+			{"name": "value", "type": "string"},
+			{"name": "floor", "type": "integer"},
+			{"name": "$ref", "type": "reference", "referenceTypes": ["external"]}
+		]}
+	]
+}`
+
+// TestLoadSchemas は LoadSchemas が各形式の JSON を読み込めることと、読み込んだスキーマを Patcher で利用できることをテストします
+func TestLoadSchemas(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "single schema", data: testCustomSchemaJSON},
+		{name: "array", data: fmt.Sprintf(`[%s]`, testCustomSchemaJSON)},
+		{name: "list response", data: fmt.Sprintf(`{"schemas":["urn:ietf:params:scim:api:messages:2.0:ListResponse"],"totalResults":1,"Resources":[%s]}`, testCustomSchemaJSON)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			schemas, err := scimpatch.LoadSchemas([]byte(tc.data))
+			if err != nil {
+				t.Fatalf("LoadSchemas() returned an unexpected error: %v", err)
+			}
+			if len(schemas) != 1 {
+				t.Fatalf("LoadSchemas() returned %d schemas", len(schemas))
+			}
+			badge := schemas[0]
+			attr, ok := badge.Attributes.ContainsAttribute("badgeNumber")
+			if !ok || !attr.Required() || !attr.CaseExact() || attr.Mutability() != "immutable" || attr.Uniqueness() != "server" {
+				t.Errorf("unexpected badgeNumber attribute: %v", attr)
+			}
+			attr, ok = badge.Attributes.ContainsAttribute("level")
+			if !ok || !reflect.DeepEqual(attr.CanonicalValues(), []string{"bronze", "silver", "gold"}) {
+				t.Errorf("unexpected level attribute: %v", attr)
+			}
+
+			patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), schemas, nil)
+			actual, _, err := patcher.ApplyOperations(context.TODO(), []scim.PatchOperation{
+				{Op: "add", Path: path(`urn:ivixvi:schemas:Badge:rooms`), Value: []interface{}{map[string]interface{}{"value": "A", "floor": 1}}},
+				{Op: "replace", Path: path(`urn:ivixvi:schemas:Badge:rooms[value eq "A"].floor`), Value: 2},
+			}, map[string]interface{}{})
+			if err != nil {
+				t.Fatalf("ApplyOperations() returned an unexpected error: %v", err)
+			}
+			expected := map[string]interface{}{
+				"urn:ivixvi:schemas:Badge": map[string]interface{}{
+					"rooms": []map[string]interface{}{{"value": "A", "floor": 2}},
+				},
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, expected)
+			}
+		})
+	}
+}
+
+// TestLoadSchemasError は LoadSchemas で不正なスキーマがエラーとなることをテストします
+func TestLoadSchemasError(t *testing.T) {
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{name: "invalid json", data: `{"id":`},
+		{name: "missing id", data: `{"attributes":[]}`},
+		{name: "unknown type", data: `{"id":"urn:x","attributes":[{"name":"a","type":"object"}]}`},
+		{name: "unknown mutability", data: `{"id":"urn:x","attributes":[{"name":"a","type":"string","mutability":"writeOnce"}]}`},
+		{name: "invalid attribute name", data: `{"id":"urn:x","attributes":[{"name":"1a","type":"string"}]}`},
+		{name: "invalid sub-attribute name", data: `{"id":"urn:x","attributes":[{"name":"a","type":"complex","subAttributes":[{"name":"b c","type":"string"}]}]}`},
+		{name: "empty sub-attribute name", data: `{"id":"urn:x","attributes":[{"name":"a","type":"complex","subAttributes":[{"name":"","type":"string"}]}]}`},
+		{name: "duplicate attribute", data: `{"id":"urn:x","attributes":[{"name":"a","type":"string"},{"name":"A","type":"string"}]}`},
+		{name: "duplicate sub-attribute", data: `{"id":"urn:x","attributes":[{"name":"a","type":"complex","subAttributes":[{"name":"b","type":"string"},{"name":"B","type":"string"}]}]}`},
+		{name: "nested complex", data: `{"id":"urn:x","attributes":[{"name":"a","type":"complex","subAttributes":[{"name":"b","type":"complex"}]}]}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := scimpatch.LoadSchemas([]byte(tc.data)); err == nil {
+				t.Error("LoadSchemas() should return an error")
+			}
+		})
+	}
+}
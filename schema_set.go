@@ -0,0 +1,138 @@
+package scimpatch
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/elimity-com/scim/schema"
+)
+
+// schemaSet は Patcher が利用するスキーマのスナップショットです。作成後に変更してはいけません。
+type schemaSet struct {
+	schema      schema.Schema
+	schemas     map[string]schema.Schema
+	requiredIDs []string
+	required    []schema.Schema
+}
+
+func newSchemaSet(s schema.Schema, extensions []schema.Schema, requiredIDs []string) *schemaSet {
+	schemas := make(map[string]schema.Schema, len(extensions)+1)
+	schemas[s.ID] = s
+	for _, extension := range extensions {
+		schemas[extension.ID] = extension
+	}
+	return newSchemaSetFromMap(s, schemas, requiredIDs)
+}
+
+func newSchemaSetFromMap(s schema.Schema, schemas map[string]schema.Schema, requiredIDs []string) *schemaSet {
+	set := &schemaSet{
+		schema:      s,
+		schemas:     schemas,
+		requiredIDs: requiredIDs,
+	}
+	for _, id := range requiredIDs {
+		extension, ok := schemas[id]
+		if !ok {
+			extension = schema.Schema{ID: id}
+		}
+		set.required = append(set.required, extension)
+	}
+	return set
+}
+
+// liveSchemas は Patcher が現在利用しているスキーマのスナップショットを保持します。
+// Apply はスナップショットを読み込むのみで、更新はスナップショット全体を差し替えることで行います。
+type liveSchemas struct {
+	// mu はスナップショットの更新同士を直列化するためのものです
+	mu      sync.Mutex
+	current atomic.Pointer[schemaSet]
+}
+
+// use は set のスキーマを p が処理中に利用するスキーマとして設定します。
+func (p *Patcher) use(set *schemaSet) {
+	p.schema = set.schema
+	p.schemas = set.schemas
+	p.required = set.required
+	p.loaded = set
+}
+
+// snapshot は現在のスキーマのスナップショットを利用する Patcher を返却します。
+// スキーマが更新されていない場合や、NewPatcher を利用せずに作成された Patcher の場合は p をそのまま返却します。
+func (p *Patcher) snapshot() *Patcher {
+	if p.live == nil {
+		return p
+	}
+	set := p.live.current.Load()
+	if set == p.loaded {
+		return p
+	}
+	cp := *p
+	cp.use(set)
+	return &cp
+}
+
+// update は現在のスナップショットから f で新しいスナップショットを作成し、差し替えます。
+// NewPatcher で作成された Patcher でのみ利用できます。
+func (p *Patcher) update(f func(current *schemaSet) *schemaSet) {
+	p.live.mu.Lock()
+	defer p.live.mu.Unlock()
+	p.live.current.Store(f(p.live.current.Load()))
+}
+
+// PutExtensions は拡張スキーマを追加します。同じ ID の拡張スキーマが既にある場合は置き換えます。
+// 処理中の Apply, ApplyOperations には影響せず、以降の呼び出しから更新後のスキーマが利用されます。
+// 複数の goroutine から Apply と同時に呼び出すことができます。
+func (p *Patcher) PutExtensions(extensions ...schema.Schema) {
+	p.update(func(current *schemaSet) *schemaSet {
+		schemas := make(map[string]schema.Schema, len(current.schemas)+len(extensions))
+		for id, s := range current.schemas {
+			schemas[id] = s
+		}
+		for _, extension := range extensions {
+			if extension.ID == current.schema.ID {
+				continue
+			}
+			schemas[extension.ID] = extension
+		}
+		return newSchemaSetFromMap(current.schema, schemas, current.requiredIDs)
+	})
+}
+
+// RemoveExtensions は ids の拡張スキーマを削除します。リソースの主スキーマは削除されません。
+// 必須の拡張スキーマとして指定されていた場合は、必須の指定も解除されます。
+// 処理中の Apply, ApplyOperations には影響せず、以降の呼び出しから更新後のスキーマが利用されます。
+func (p *Patcher) RemoveExtensions(ids ...string) {
+	p.update(func(current *schemaSet) *schemaSet {
+		removed := make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			if id != current.schema.ID {
+				removed[id] = struct{}{}
+			}
+		}
+		schemas := make(map[string]schema.Schema, len(current.schemas))
+		for id, s := range current.schemas {
+			if _, ok := removed[id]; !ok {
+				schemas[id] = s
+			}
+		}
+		requiredIDs := make([]string, 0, len(current.requiredIDs))
+		for _, id := range current.requiredIDs {
+			if _, ok := removed[id]; !ok {
+				requiredIDs = append(requiredIDs, id)
+			}
+		}
+		return newSchemaSetFromMap(current.schema, schemas, requiredIDs)
+	})
+}
+
+// Extensions は現在利用している拡張スキーマの一覧を返却します。
+func (p *Patcher) Extensions() []schema.Schema {
+	current := p.snapshot()
+	extensions := make([]schema.Schema, 0, len(current.schemas))
+	for id, s := range current.schemas {
+		if id != current.schema.ID {
+			extensions = append(extensions, s)
+		}
+	}
+	return extensions
+}
@@ -0,0 +1,112 @@
+package scimpatch_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/optional"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestPutAndRemoveExtensions は Patcher.PutExtensions, Patcher.RemoveExtensions で利用するスキーマが更新されることをテストします
+func TestPutAndRemoveExtensions(t *testing.T) {
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, nil)
+	op := scim.PatchOperation{Op: "add", Path: path(`urn:ivixvi:schemas:Employee:costCenter`), Value: "A"}
+
+	if _, _, err := patcher.Apply(context.TODO(), op, map[string]interface{}{}); err != errors.ScimErrorInvalidPath {
+		t.Fatalf("Apply() before PutExtensions should return invalidPath: %v", err)
+	}
+
+	patcher.PutExtensions(testEmployeeSchema)
+	if len(patcher.Extensions()) != 1 {
+		t.Fatalf("unexpected extensions: %v", patcher.Extensions())
+	}
+	actual, changed, err := patcher.Apply(context.TODO(), op, map[string]interface{}{})
+	if err != nil || !changed {
+		t.Fatalf("Apply() after PutExtensions returned an unexpected result: %v, %t, %v", actual, changed, err)
+	}
+
+	// 同じ ID の拡張スキーマは置き換えられます
+	patcher.PutExtensions(schema.Schema{
+		ID:   testEmployeeSchema.ID,
+		Name: optional.NewString("Employee"),
+		Attributes: []schema.CoreAttribute{
+			schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{
+				Name:       "costCenter",
+				Mutability: schema.AttributeMutabilityReadOnly(),
+			})),
+		},
+	})
+	if _, _, err := patcher.Apply(context.TODO(), op, map[string]interface{}{}); err != errors.ScimErrorMutability {
+		t.Fatalf("Apply() after replacing the extension should return mutability: %v", err)
+	}
+
+	patcher.RemoveExtensions(testEmployeeSchema.ID, schema.CoreUserSchema().ID)
+	if len(patcher.Extensions()) != 0 {
+		t.Fatalf("unexpected extensions: %v", patcher.Extensions())
+	}
+	if _, _, err := patcher.Apply(context.TODO(), op, map[string]interface{}{}); err != errors.ScimErrorInvalidPath {
+		t.Fatalf("Apply() after RemoveExtensions should return invalidPath: %v", err)
+	}
+	// 主スキーマは削除されません
+	if _, _, err := patcher.Apply(context.TODO(), scim.PatchOperation{Op: "add", Path: path(`userName`), Value: "alice"}, map[string]interface{}{}); err != nil {
+		t.Fatalf("Apply() for the core schema returned an unexpected error: %v", err)
+	}
+}
+
+// TestConcurrentSchemaUpdate は ApplyOperations の途中でスキーマが更新されても、すべての op に同じスキーマが利用されることをテストします
+func TestConcurrentSchemaUpdate(t *testing.T) {
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, nil)
+	ops := []scim.PatchOperation{
+		{Op: "add", Path: path(`urn:ivixvi:schemas:Employee:costCenter`), Value: "A"},
+		{Op: "add", Path: path(`urn:ivixvi:schemas:Employee:employeeNumber`), Value: "0001"},
+	}
+
+	done := make(chan struct{})
+	var updater sync.WaitGroup
+	updater.Add(1)
+	go func() {
+		defer updater.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				patcher.PutExtensions(testEmployeeSchema)
+				patcher.RemoveExtensions(testEmployeeSchema.ID)
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := 0; j < 500; j++ {
+				data := map[string]interface{}{}
+				actual, _, err := patcher.ApplyOperations(context.TODO(), ops, data)
+				if err != nil {
+					// 拡張スキーマがない場合は最初の op で失敗するため、data は変更されません
+					if len(data) != 0 {
+						t.Errorf("operations were partially applied: %v", data)
+						return
+					}
+					continue
+				}
+				extension, ok := actual[testEmployeeSchema.ID].(map[string]interface{})
+				if !ok || len(extension) != 2 {
+					t.Errorf("unexpected result: %v", actual)
+					return
+				}
+			}
+		}()
+	}
+	workers.Wait()
+	close(done)
+	updater.Wait()
+}
@@ -2,6 +2,7 @@ package scimpatch
 
 import (
 	"context"
+	"log/slog"
 	"strings"
 
 	"github.com/elimity-com/scim"
@@ -11,19 +12,54 @@ import (
 )
 
 type Patcher struct {
+	// schema, schemas, required は loaded から取得した、処理中に利用するスキーマです
 	schema   schema.Schema
 	schemas  map[string]schema.Schema
+	required []schema.Schema
+	loaded   *schemaSet
+	live     *liveSchemas
 	adder    Operator
 	replacer Operator
 	remover  Operator
+	profile  *CompatibilityProfile
+	// openExtensions はスキーマが登録されていない拡張スキーマとして受け付ける URN のパターンです
+	openExtensions []string
+	hooks          []Hook
+	// attributeOperators は小文字に変換したキーで保持した、属性毎に上書きする Operator です
+	attributeOperators map[string]AttributeOperators
+	// normalizers は小文字に変換した属性のパスをキーとして保持した Normalizer です
+	normalizers     map[string]Normalizer
+	writeOnlySink   WriteOnlySink
+	instrumentation Instrumentation
 }
 
 // PatcherOpts を利用することで Patcherが利用する各操作の Operator を上書きすることができます。
 // 指定しない場合はパッケージデフォルトで実装されている Operator が利用されます。
+// パッケージデフォルトの Operator は DefaultAdder, DefaultReplacer, DefaultRemover で取得できるため、処理を委譲する Operator を実装することもできます。
+// Profile を指定すると、IdP 毎の仕様からの逸脱を吸収したうえで op を適用します。
+// RequiredExtensions にはリソースタイプで必須 (scim.SchemaExtension.Required) の拡張スキーマの ID を指定します。
+// OpenExtensions には、スキーマを登録せずに拡張スキーマとして受け付ける URN のパターン (e.g. "urn:acme:tenant:*") を指定します。
+// パターンの記法は path.Match と同様です。
+// Hooks には各 op の適用前後に呼び出す Hook を指定します。Hook は指定した順に呼び出されます。
+// AttributeOperators には属性名 (e.g. "members") もしくはスキーマの URI で修飾した属性名をキーとして、属性毎に上書きする Operator を指定します。
+// Middlewares は Adder, Replacer, Remover と AttributeOperators のすべての Operator に適用されます。先頭の middleware が最も外側になります。
+// Normalizers には属性のパス (e.g. "emails.value") もしくはスキーマの URI で修飾した属性のパスをキーとして、
+// op の value と path のフィルタの比較値を Operator に渡す前に正規化する Normalizer を指定します。
+// WriteOnlySink を指定すると、writeOnly の属性 (e.g. password) の value は平文のまま保存せずに WriteOnlySink に渡され、返却された値が保存されます。
+// Instrumentation には各 op の適用前後に呼び出す計装を指定します。指定しない場合は何もしない Instrumentation が利用されます。
 type PatcherOpts struct {
-	Adder    *Operator
-	Replacer *Operator
-	Remover  *Operator
+	Adder              *Operator
+	Replacer           *Operator
+	Remover            *Operator
+	Profile            *CompatibilityProfile
+	RequiredExtensions []string
+	OpenExtensions     []string
+	Hooks              []Hook
+	AttributeOperators map[string]AttributeOperators
+	Middlewares        []OperatorMiddleware
+	Normalizers        map[string]Normalizer
+	WriteOnlySink      WriteOnlySink
+	Instrumentation    Instrumentation
 }
 
 var externalIdAttr = schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{
@@ -37,17 +73,14 @@ func NewPatcher(
 	extensions []schema.Schema,
 	opts *PatcherOpts,
 ) *Patcher {
-	schemas := map[string]schema.Schema{s.ID: s}
-	for _, s := range extensions {
-		schemas[s.ID] = s
-	}
 	patcher := &Patcher{
-		schema:   s,
-		schemas:  schemas,
-		adder:    adderInstance,
-		replacer: replacerInstance,
-		remover:  removerInstance,
+		live:            &liveSchemas{},
+		adder:           adderInstance,
+		replacer:        replacerInstance,
+		remover:         removerInstance,
+		instrumentation: noopInstrumentation{},
 	}
+	var required []string
 	if opts != nil {
 		if opts.Adder != nil {
 			patcher.adder = *opts.Adder
@@ -58,15 +91,98 @@ func NewPatcher(
 		if opts.Remover != nil {
 			patcher.remover = *opts.Remover
 		}
+		patcher.profile = opts.Profile
+		patcher.openExtensions = opts.OpenExtensions
+		patcher.hooks = opts.Hooks
+		patcher.normalizers = newNormalizers(opts.Normalizers)
+		patcher.writeOnlySink = opts.WriteOnlySink
+		if opts.Instrumentation != nil {
+			patcher.instrumentation = opts.Instrumentation
+		}
+		patcher.attributeOperators = newAttributeOperators(opts.AttributeOperators, opts.Middlewares)
+		patcher.adder = chainOperator(patcher.adder, opts.Middlewares)
+		patcher.replacer = chainOperator(patcher.replacer, opts.Middlewares)
+		patcher.remover = chainOperator(patcher.remover, opts.Middlewares)
+		required = opts.RequiredExtensions
 	}
+	set := newSchemaSet(s, extensions, required)
+	patcher.live.current.Store(set)
+	patcher.use(set)
 	return patcher
 }
 
 // Apply は RFC7644 3.5.2.  Modifying with PATCH の実装です。
 // data に op が適用された ResourceAttributes と実際に適用されたかどうかの真偽値を返却します。
 // see. https://datatracker.ietf.org/doc/html/rfc7644#section-3.5.2
+// 必須の拡張スキーマもしくはその必須の属性が op によって削除される場合は、data の必須の拡張スキーマを適用前の状態に戻したうえでエラーを返却します。
 func (p *Patcher) Apply(ctx context.Context, op scim.PatchOperation, data map[string]interface{}) (map[string]interface{}, bool, error) {
-	switch strings.ToLower(op.Op) {
+	p = p.snapshot()
+	if len(p.required) == 0 {
+		return p.apply(ctx, op, data)
+	}
+	before := p.requiredExtensionValues(data)
+	patched, changed, err := p.apply(ctx, op, data)
+	if err != nil {
+		return patched, changed, err
+	}
+	if err := p.validateRequiredExtensions(before, patched); err != nil {
+		p.restoreRequiredExtensions(patched, before)
+		return patched, false, err
+	}
+	return patched, changed, nil
+}
+
+// apply は Apply の実装です。スキーマは p が保持しているものを利用します。
+func (p *Patcher) apply(ctx context.Context, op scim.PatchOperation, data map[string]interface{}) (map[string]interface{}, bool, error) {
+	op.Op = strings.ToLower(op.Op)
+	return p.instrument(ctx, op, func(ctx context.Context) (map[string]interface{}, bool, error) {
+		return p.applyOperation(ctx, op, data)
+	})
+}
+
+// applyOperation は小文字に変換された op を data に適用します。
+func (p *Patcher) applyOperation(ctx context.Context, op scim.PatchOperation, data map[string]interface{}) (map[string]interface{}, bool, error) {
+	op.Value = normalizeValue(op.Value)
+	ctx = withOperationLogAttrs(ctx, op)
+	if logEnabled(ctx, slog.LevelDebug) {
+		logEvent(ctx, slog.LevelDebug, "applying operation", slog.Any("value", p.redactedValue(op)))
+	}
+
+	received := op
+	op = p.applyProfile(ctx, op)
+	logRewritten(ctx, "operation coerced by profile", received, op)
+	received = op
+	op = p.applyNormalizers(op)
+	logRewritten(ctx, "value normalized", received, op)
+	received = op
+	op, calls, err := p.runBeforeHooks(ctx, op, data)
+	if err != nil {
+		logEvent(ctx, slog.LevelInfo, "operation vetoed by hook", slog.Any("error", err))
+		return data, false, err
+	}
+	logRewritten(ctx, "value rewritten by hook", received, op)
+	op, consumed, err := p.routeWriteOnly(ctx, op, data)
+	if err != nil {
+		logEvent(ctx, slog.LevelInfo, "operation rejected by writeOnly sink", slog.Any("error", err))
+		p.runAfterHooks(ctx, calls, data, false, err)
+		return data, false, err
+	}
+	changed := false
+	if consumed {
+		logEvent(ctx, slog.LevelDebug, "writeOnly attribute was not stored")
+	} else {
+		data, changed, err = p.operate(ctx, op, data)
+	}
+	logResult(ctx, changed, err)
+	p.runAfterHooks(ctx, calls, data, changed, err)
+	return data, changed, err
+}
+
+// operate は op の種類に応じて add, replace, remove のいずれかを呼び出します。
+func (p *Patcher) operate(ctx context.Context, op scim.PatchOperation, data map[string]interface{}) (map[string]interface{}, bool, error) {
+	p.explainOperation(ctx, op)
+	recordAppliedOperation(ctx, op)
+	switch op.Op {
 	case scim.PatchOperationAdd:
 		return p.add(ctx, op, data)
 	case scim.PatchOperationReplace:
@@ -74,9 +190,35 @@ func (p *Patcher) Apply(ctx context.Context, op scim.PatchOperation, data map[st
 	case scim.PatchOperationRemove:
 		return p.remove(ctx, op, data)
 	}
+	logEvent(ctx, slog.LevelWarn, "unknown op; operation ignored")
 	return data, false, nil
 }
 
+// ApplyOperations は複数の op を順番に data へ適用します。
+// いずれかの op が失敗した場合はその時点でエラーを返却します。data は途中まで更新されている可能性があります。
+// すべての op の適用後に、変更された必須の拡張スキーマの必須の属性が欠けていないかを確認します。
+// 適用中にスキーマが更新された場合でも、すべての op に同じスキーマが利用されます。
+func (p *Patcher) ApplyOperations(ctx context.Context, ops []scim.PatchOperation, data map[string]interface{}) (map[string]interface{}, bool, error) {
+	p = p.snapshot()
+	changed := false
+	before := p.requiredExtensionValues(data)
+	for i, op := range ops {
+		var opChanged bool
+		var err error
+		data, opChanged, err = p.apply(withLogAttrs(ctx, slog.Int("op_index", i)), op, data)
+		if err != nil {
+			return data, changed, err
+		}
+		if opChanged {
+			changed = true
+		}
+	}
+	if err := p.validateRequiredExtensions(before, data); err != nil {
+		return data, changed, err
+	}
+	return data, changed, nil
+}
+
 // add は RFC7644 3.5.2.1. Add Operation の実装です。
 // data に op が適用された ResourceAttributes と実際に適用されたかどうかの真偽値を返却します。
 // see. https://datatracker.ietf.org/doc/html/rfc7644#section-3.5.2.1
@@ -108,6 +250,9 @@ func (p *Patcher) remove(ctx context.Context, op scim.PatchOperation, data map[s
 		// If "path" is unspecified, the operation fails with HTTP status code 400 and a "scimType" error code of "noTarget".
 		return map[string]interface{}{}, false, errors.ScimErrorNoTarget
 	}
+	if extension, ok := p.requiredExtensionOf(op.Path); ok {
+		return data, false, requiredExtensionError(extension.ID)
+	}
 	return p.pathSpecifiedOperate(ctx, op, data, p.remover)
 }
 
@@ -133,34 +278,65 @@ func (p *Patcher) pathSpecifiedOperate(
 ) (map[string]interface{}, bool, error) {
 	var changed = false
 	// Resolve Attribute
-	attrName := op.Path.AttributePath.AttributeName
-	attr, ok := p.containsAttribute(attrName)
+	attr, ok := p.resolvePathAttribute(op, data)
 	if !ok {
 		return map[string]interface{}{}, false, errors.ScimErrorInvalidPath
 	}
 	if cannotBePatched(op.Op, attr) {
 		return map[string]interface{}{}, false, errors.ScimErrorMutability
 	}
+	schemaURI := p.schemaURIOf(op.Path.AttributePath.URIPrefix, attr.Name())
+	explainAttribute(ctx, schemaURI, attr.Name())
+	operator = p.attributeOperator(op.Op, schemaURI, attr.Name(), operator)
 	n := newScopeNavigator(op, data, attr)
 	switch {
 	// request path is `attr[expr].subAttr`
 	case attr.MultiValued() && op.Path.ValueExpression != nil && op.Path.SubAttribute != nil:
+		oldValues, err := n.GetScopedMapSlice()
+		if err != nil {
+			return data, false, err
+		}
+		explainBranch(ctx, ExplainBranchValueExpressionForAttribute)
+		explainMatched(ctx, oldValues, op.Path.ValueExpression)
 		var newValues []map[string]interface{}
-		oldValues := n.GetScopedMapSlice()
 		newValues, changed = operator.ByValueExpressionForAttribute(ctx, oldValues, op.Path.ValueExpression, *op.Path.SubAttribute, op.Value)
-		n.ApplyScopedMapSlice(newValues)
+		// 変更がない場合は、保存されている値の型を変えないよう書き戻しません
+		if changed {
+			if err := n.ApplyScopedMapSlice(newValues); err != nil {
+				return data, false, err
+			}
+		}
 	// request path is `attr[expr]`
 	case attr.MultiValued() && op.Path.ValueExpression != nil:
+		oldValues, err := n.GetScopedMapSlice()
+		if err != nil {
+			return data, false, err
+		}
+		explainBranch(ctx, ExplainBranchValueExpressionForItem)
+		explainMatched(ctx, oldValues, op.Path.ValueExpression)
 		var newValues []map[string]interface{}
-		oldValues := n.GetScopedMapSlice()
 		newValues, changed = operator.ByValueExpressionForItem(ctx, oldValues, op.Path.ValueExpression, op.Value)
-		n.ApplyScopedMapSlice(newValues)
+		// 変更がない場合は、保存されている値の型を変えないよう書き戻しません
+		if changed {
+			if err := n.ApplyScopedMapSlice(newValues); err != nil {
+				return data, false, err
+			}
+		}
 	// request path is `attr`, `attr.subAttr`
 	case !attr.MultiValued() || op.Path.ValueExpression == nil:
-		scopedMap, scopedAttr := n.GetScopedMap()
-		scopedMap, scopedAttr = resolveDotNotationAttribute(scopedMap, scopedAttr)
+		scopedMap, scopedAttr, err := n.GetScopedMap()
+		if err != nil {
+			return data, false, err
+		}
+		scopedMap, scopedAttr, err = resolveDotNotationAttribute(scopedMap, scopedAttr)
+		if err != nil {
+			return data, false, err
+		}
+		explainBranch(ctx, ExplainBranchDirect)
 		changed = operator.Direct(ctx, scopedMap, scopedAttr, op.Value)
-		n.ApplyScopedMap(scopedMap)
+		if err := n.ApplyScopedMap(scopedMap); err != nil {
+			return data, false, err
+		}
 	}
 
 	return data, changed, nil
@@ -172,43 +348,67 @@ func (p *Patcher) pathUnspecifiedOperate(
 	data map[string]interface{},
 	operator Operator,
 ) (map[string]interface{}, bool, error) {
-	switch newMap := op.Value.(type) {
-	case map[string]interface{}:
-		changed := false
-		for attr, value := range newMap {
-			uriPrefix, ok := p.schemas[attr]
-			// Core Attributes
-			if !ok {
-				scopedMap, scopedAttr := resolveDotNotationAttribute(data, attr)
-				if operator.Direct(ctx, scopedMap, scopedAttr, value) {
-					changed = true
-				}
-				continue
+	newMap, ok := asMap(op.Value)
+	if !ok {
+		// unexpected input
+		logEvent(ctx, slog.LevelWarn, "value without path is not an object; operation ignored")
+		return data, false, nil
+	}
+	explainBranch(ctx, ExplainBranchPathUnspecified)
+	changed := false
+	for attr, value := range newMap {
+		extensionID, ok := p.extensionKey(attr)
+		// Core Attributes
+		if !ok {
+			scopedMap, scopedAttr, err := resolveDotNotationAttribute(data, attr)
+			if err != nil {
+				return data, false, err
+			}
+			attrName, _, _ := strings.Cut(attr, ".")
+			attrOperator := p.attributeOperator(op.Op, p.schemaURIOf(nil, attrName), attrName, operator)
+			if attrOperator.Direct(ctx, scopedMap, scopedAttr, normalizeValue(value)) {
+				changed = true
 			}
+			continue
+		}
 
-			// Schema Extension Attributes
-			oldMap, ok := data[uriPrefix.ID].(map[string]interface{})
+		// Schema Extension Attributes
+		oldValue, exists := data[extensionID]
 
-			// if not exists, write all attributes
-			if !ok {
+		// if not exists, write all attributes
+		// 属性毎に上書きされた Operator がある場合は、空の拡張スキーマに属性毎に書き込みます
+		if !exists || oldValue == nil {
+			if !p.overridesAnyAttribute(op.Op, extensionID, value) {
 				changed = true
-				data[uriPrefix.ID] = value
+				data[extensionID] = value
 				continue
 			}
+			oldValue = map[string]interface{}{}
+		}
+		oldMap, ok := asMap(oldValue)
+		if !ok {
+			return data, false, unexpectedTypeError(errors.ScimErrorInvalidValue, extensionID, oldValue)
+		}
+		// scim.ResourceAttributes などの名前付きの型は map[string]interface{} として格納し直します
+		data[extensionID] = oldMap
 
-			// if exists, write by every attributes
-			if newUriMap, ok := value.(map[string]interface{}); ok {
-				for scopedAttr, scopedValue := range newUriMap {
-					scopedMap, scopedAttr := resolveDotNotationAttribute(oldMap, scopedAttr)
-					if operator.Direct(ctx, scopedMap, scopedAttr, scopedValue) {
-						changed = true
-					}
-				}
+		// if exists, write by every attributes
+		newUriMap, ok := asMap(value)
+		if !ok {
+			logEvent(ctx, slog.LevelWarn, "value of schema extension is not an object; key skipped", slog.String("key", attr))
+			continue
+		}
+		for scopedAttr, scopedValue := range newUriMap {
+			attrName, _, _ := strings.Cut(scopedAttr, ".")
+			attrOperator := p.attributeOperator(op.Op, extensionID, attrName, operator)
+			scopedMap, scopedAttr, err := resolveDotNotationAttribute(oldMap, scopedAttr)
+			if err != nil {
+				return data, false, err
+			}
+			if attrOperator.Direct(ctx, scopedMap, scopedAttr, normalizeValue(scopedValue)) {
+				changed = true
 			}
 		}
-		return data, changed, nil
-	default:
-		// unexpected input
-		return data, false, nil
 	}
+	return data, changed, nil
 }
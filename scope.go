@@ -1,7 +1,10 @@
 package scimpatch
 
 import (
+	"fmt"
+
 	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
 	"github.com/elimity-com/scim/schema"
 )
 
@@ -25,8 +28,11 @@ func (n *scopeNavigator) GetMap() map[string]interface{} {
 }
 
 // ApplyScopedMap は 処理対象であるmapまでのスコープをたどりscopedMapに置換します
-func (n *scopeNavigator) ApplyScopedMap(scopedMap map[string]interface{}) {
-	uriScoped := n.GetURIScopedMap()
+func (n *scopeNavigator) ApplyScopedMap(scopedMap map[string]interface{}) error {
+	uriScoped, err := n.GetURIScopedMap()
+	if err != nil {
+		return err
+	}
 	if _, required := n.requiredSubAttributes(); required {
 		attachToMap(uriScoped, scopedMap, n.attr.Name(), required)
 	} else {
@@ -35,39 +41,54 @@ func (n *scopeNavigator) ApplyScopedMap(scopedMap map[string]interface{}) {
 
 	uriPrefix, containsURI := n.containsURIPrefix()
 	attachToMap(n.data, uriScoped, uriPrefix, containsURI)
+	return nil
 }
 
 // ApplyScopedMapSlice は 処理対象であるmapまでのスコープをたどりscopedMapに置換します
-func (n *scopeNavigator) ApplyScopedMapSlice(scopedMapSlice []map[string]interface{}) {
-	uriScoped := n.GetURIScopedMap()
+func (n *scopeNavigator) ApplyScopedMapSlice(scopedMapSlice []map[string]interface{}) error {
+	uriScoped, err := n.GetURIScopedMap()
+	if err != nil {
+		return err
+	}
 	attachToMapSlice(uriScoped, scopedMapSlice, n.attr.Name(), true)
 	uriPrefix, containsURI := n.containsURIPrefix()
 	attachToMap(n.data, uriScoped, uriPrefix, containsURI)
+	return nil
 }
 
 // GetURIScopedMap は URIに応じて、処理対象のMapを返却します
-func (n *scopeNavigator) GetURIScopedMap() map[string]interface{} {
-	uriScoped := n.data
+func (n *scopeNavigator) GetURIScopedMap() (map[string]interface{}, error) {
 	uriPrefix, ok := n.containsURIPrefix()
-	uriScoped = navigateToMap(uriScoped, uriPrefix, ok)
-	return uriScoped
+	return navigateToMap(n.data, uriPrefix, ok)
 }
 
 // GetScopedMap は 属性に応じて、処理対象のMapを返却します
-func (n *scopeNavigator) GetScopedMap() (map[string]interface{}, string) {
+func (n *scopeNavigator) GetScopedMap() (map[string]interface{}, string, error) {
 	// initialize returns
-	data := n.GetURIScopedMap()
+	data, err := n.GetURIScopedMap()
+	if err != nil {
+		return nil, "", err
+	}
 	subAttrName, ok := n.requiredSubAttributes()
-	data = navigateToMap(data, n.attr.Name(), ok)
-	return data, subAttrName
+	data, err = navigateToMap(data, n.attr.Name(), ok)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, subAttrName, nil
 }
 
 // GetScopedMapSlice は 属性に応じて、処理対象のMapを返却します
-func (n *scopeNavigator) GetScopedMapSlice() []map[string]interface{} {
+func (n *scopeNavigator) GetScopedMapSlice() ([]map[string]interface{}, error) {
 	// initialize returns
-	scoped := n.GetURIScopedMap()
-	scopedSlice := navigateToMapSlice(scoped, n.attr.Name(), true)
-	return scopedSlice
+	scoped, err := n.GetURIScopedMap()
+	if err != nil {
+		return nil, err
+	}
+	// サブ属性を持たない属性は map の要素のみを対象とします
+	if !n.attr.HasSubAttributes() {
+		return mapItems(scoped[n.attr.Name()]), nil
+	}
+	return navigateToMapSlice(scoped, n.attr.Name(), true)
 }
 
 // containsURIPrefix は対象の属性がURIPrefixを持ったmapの中に格納されているかどうかを判断します
@@ -93,17 +114,20 @@ func (n *scopeNavigator) requiredSubAttributes() (string, bool) {
 }
 
 // navigateToMap は必要に応じて、パスをたどる処理です
-func navigateToMap(data map[string]interface{}, attr string, ok bool) map[string]interface{} {
-	if ok {
-		data_, ok := data[attr].(map[string]interface{})
-		switch ok {
-		case true:
-			data = data_
-		case false:
-			data = map[string]interface{}{}
-		}
+// たどった先の値が複合属性として扱えない場合は invalidPath のエラーを返却します
+func navigateToMap(data map[string]interface{}, attr string, ok bool) (map[string]interface{}, error) {
+	if !ok {
+		return data, nil
+	}
+	value, exists := data[attr]
+	if !exists || value == nil {
+		return map[string]interface{}{}, nil
 	}
-	return data
+	scoped, ok := asMap(value)
+	if !ok {
+		return nil, unexpectedTypeError(errors.ScimErrorInvalidPath, attr, value)
+	}
+	return scoped, nil
 }
 
 // attachToMap は必要に応じて、パスを戻す処理です
@@ -118,26 +142,24 @@ func attachToMap(data map[string]interface{}, scoped map[string]interface{}, att
 }
 
 // navigateToMapSlice は必要に応じて、パスをたどる処理です
-func navigateToMapSlice(data map[string]interface{}, attr string, ok bool) []map[string]interface{} {
+// たどった先の値が複数値の複合属性として扱えない場合は invalidPath のエラーを返却します
+func navigateToMapSlice(data map[string]interface{}, attr string, ok bool) ([]map[string]interface{}, error) {
 	ret := []map[string]interface{}{}
-	if ok {
-		value, ok := data[attr]
-		switch ok {
-		case true:
-			switch typedSlice := value.(type) {
-			case []map[string]interface{}:
-				ret = typedSlice
-			// 各々の item が map として変換できる可能性があるため、一つ一つ確認する必要がある
-			case []interface{}:
-				for _, item := range typedSlice {
-					if mappedItem, ok := item.(map[string]interface{}); ok {
-						ret = append(ret, mappedItem)
-					}
-				}
-			}
-		}
+	if !ok {
+		return ret, nil
 	}
-	return ret
+	value, exists := data[attr]
+	if !exists || value == nil {
+		return ret, nil
+	}
+	if maps, ok := areEveryItemsMap(value); ok {
+		return maps, nil
+	}
+	// 単一の複合属性として保存されている場合は、1要素の複数値属性として扱います
+	if m, ok := asMap(value); ok {
+		return []map[string]interface{}{m}, nil
+	}
+	return nil, unexpectedTypeError(errors.ScimErrorInvalidPath, attr, value)
 }
 
 // attachToMapSlice は必要に応じて、パスを戻す処理です
@@ -150,3 +172,24 @@ func attachToMapSlice(data map[string]interface{}, scoped []map[string]interface
 		}
 	}
 }
+
+// mapItems は value に含まれる map の要素のみを返却します
+func mapItems(value interface{}) []map[string]interface{} {
+	ret := []map[string]interface{}{}
+	items, ok := value.([]interface{})
+	if !ok {
+		return ret
+	}
+	for _, item := range items {
+		if mappedItem, ok := asMap(item); ok {
+			ret = append(ret, mappedItem)
+		}
+	}
+	return ret
+}
+
+// unexpectedTypeError は保存されているデータの型が属性の定義と一致しない場合のエラーを作成します
+func unexpectedTypeError(base errors.ScimError, attr string, value interface{}) errors.ScimError {
+	base.Detail = fmt.Sprintf("The attribute %q has an unexpected type %T in the stored resource.", attr, value)
+	return base
+}
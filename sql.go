@@ -0,0 +1,262 @@
+package scimpatch
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// SQLExecer は SQLWriter が SQL の実行に利用するインターフェースです。
+// *sql.DB, *sql.Tx, *sql.Conn が実装しています。
+type SQLExecer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// SQLStatement はパラメータ化された SQL 文です。
+type SQLStatement struct {
+	Query string
+	Args  []interface{}
+}
+
+// SQLColumn は複数値属性のサブ属性とカラムの対応です。
+type SQLColumn struct {
+	SubAttribute string
+	Column       string
+}
+
+// SQLChildTable は複数値属性の各要素を1行として格納する子テーブルの定義です。
+// Attribute には対象の複数値属性のパス (e.g. `emails`) を指定します。
+type SQLChildTable struct {
+	Name             string
+	ForeignKeyColumn string
+	Attribute        string
+	Columns          []SQLColumn
+}
+
+// SQLWriterOpts を利用することで SQLWriter が生成する SQL 文の形式を上書きすることができます。
+// Placeholder は n 番目 (1始まり) のパラメータのプレースホルダを返却します。指定しない場合は `?` が利用されます。
+type SQLWriterOpts struct {
+	Placeholder func(n int) string
+}
+
+// SQLWriter は PATCH によって実際に変更された属性のみを書き込む SQL 文を生成します。
+// Mapper の Field をテーブルのカラム名として扱います。
+type SQLWriter struct {
+	table       string
+	keyColumn   string
+	mapper      *Mapper
+	children    []sqlChildTable
+	placeholder func(n int) string
+}
+
+type sqlChildTable struct {
+	SQLChildTable
+	path filter.Path
+}
+
+// sqlIdentifierPattern は SQL 文に埋め込むテーブル名、カラム名として許可する識別子の形式です。スキーマ名による修飾を許可します。
+var sqlIdentifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// validateSQLIdentifiers は SQL 文に埋め込まれるテーブル名、カラム名がすべて識別子として妥当であるかを検証します。
+func validateSQLIdentifiers(table string, keyColumn string, mapper *Mapper, children []SQLChildTable) error {
+	identifiers := []string{table, keyColumn}
+	if mapper != nil {
+		for _, mapping := range mapper.mappings {
+			identifiers = append(identifiers, mapping.Field)
+		}
+	}
+	for _, child := range children {
+		identifiers = append(identifiers, child.Name, child.ForeignKeyColumn)
+		for _, column := range child.Columns {
+			identifiers = append(identifiers, column.Column)
+		}
+	}
+	for _, identifier := range identifiers {
+		if !sqlIdentifierPattern.MatchString(identifier) {
+			return fmt.Errorf("scimpatch: invalid SQL identifier %q", identifier)
+		}
+	}
+	return nil
+}
+
+// NewSQLWriter は SQLWriter の実態を取得します。
+// children の Attribute が解釈できない場合や、テーブル名、カラム名 (Mapper の Field を含む) が
+// 英数字とアンダースコアからなる識別子でない場合はエラーを返却します。
+func NewSQLWriter(table string, keyColumn string, mapper *Mapper, children []SQLChildTable, opts *SQLWriterOpts) (*SQLWriter, error) {
+	if err := validateSQLIdentifiers(table, keyColumn, mapper, children); err != nil {
+		return nil, err
+	}
+	w := &SQLWriter{
+		table:       table,
+		keyColumn:   keyColumn,
+		mapper:      mapper,
+		placeholder: func(int) string { return "?" },
+	}
+	for _, child := range children {
+		p, err := filter.ParsePath([]byte(child.Attribute))
+		if err != nil {
+			return nil, fmt.Errorf("scimpatch: invalid child table attribute %q: %w", child.Attribute, err)
+		}
+		w.children = append(w.children, sqlChildTable{SQLChildTable: child, path: p})
+	}
+	if opts != nil && opts.Placeholder != nil {
+		w.placeholder = opts.Placeholder
+	}
+	return w, nil
+}
+
+// Statements は変更前後のリソースを比較して、変更を反映するための SQL 文を返却します。
+// 親テーブルは変更されたカラムのみの UPDATE 文、子テーブルは削除された要素の DELETE 文と追加された要素の INSERT 文となります。
+// p のスキーマで writeOnly の属性 (e.g. password) に対応するカラムには、リソースに保存されている値 (WriteOnlySink が返却した値) が書き込まれます。
+func (w *SQLWriter) Statements(p *Patcher, key interface{}, before map[string]interface{}, after map[string]interface{}) ([]SQLStatement, error) {
+	if p == nil {
+		return nil, errNilPatcher
+	}
+	return w.statements(p.snapshot(), key, before, after)
+}
+
+// statements は Statements の実装です。
+func (w *SQLWriter) statements(p *Patcher, key interface{}, before map[string]interface{}, after map[string]interface{}) ([]SQLStatement, error) {
+	statements := []SQLStatement{}
+
+	if w.mapper != nil {
+		changes, err := w.mapper.changes(p, before, after, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) != 0 {
+			statements = append(statements, w.update(key, changes))
+		}
+	}
+
+	for _, child := range w.children {
+		oldRows := child.rows(before)
+		newRows := child.rows(after)
+		for _, row := range subtractRows(oldRows, newRows) {
+			statements = append(statements, w.delete(child, key, row))
+		}
+		for _, row := range subtractRows(newRows, oldRows) {
+			statements = append(statements, w.insert(child, key, row))
+		}
+	}
+	return statements, nil
+}
+
+// Apply は p を利用して ops を data に適用し、変更を db に書き込みます。
+// data は複製されたうえで更新され、更新後のリソースが返却されます。
+func (w *SQLWriter) Apply(ctx context.Context, db SQLExecer, p *Patcher, key interface{}, ops []scim.PatchOperation, data map[string]interface{}) (map[string]interface{}, error) {
+	p = p.snapshot()
+	before := copyMap(data)
+	after, changed, err := p.ApplyOperations(ctx, ops, copyMap(data))
+	if err != nil || !changed {
+		return data, err
+	}
+	statements, err := w.statements(p, key, before, after)
+	if err != nil {
+		return data, err
+	}
+	if err := w.Exec(ctx, db, statements); err != nil {
+		return data, err
+	}
+	return after, nil
+}
+
+// Exec は statements を順番に実行します。
+// 複数の SQL 文をアトミックに実行したい場合は db に *sql.Tx を渡してください。
+func (w *SQLWriter) Exec(ctx context.Context, db SQLExecer, statements []SQLStatement) error {
+	for _, s := range statements {
+		if _, err := db.ExecContext(ctx, s.Query, s.Args...); err != nil {
+			return fmt.Errorf("scimpatch: failed to execute %q: %w", s.Query, err)
+		}
+	}
+	return nil
+}
+
+func (w *SQLWriter) update(key interface{}, changes []FieldChange) SQLStatement {
+	sets := make([]string, 0, len(changes))
+	args := make([]interface{}, 0, len(changes)+1)
+	for _, change := range changes {
+		args = append(args, change.New)
+		sets = append(sets, fmt.Sprintf("%s = %s", change.Field, w.placeholder(len(args))))
+	}
+	args = append(args, key)
+	return SQLStatement{
+		Query: fmt.Sprintf("UPDATE %s SET %s WHERE %s = %s", w.table, strings.Join(sets, ", "), w.keyColumn, w.placeholder(len(args))),
+		Args:  args,
+	}
+}
+
+func (w *SQLWriter) delete(child sqlChildTable, key interface{}, row []interface{}) SQLStatement {
+	args := []interface{}{key}
+	conds := []string{fmt.Sprintf("%s = %s", child.ForeignKeyColumn, w.placeholder(len(args)))}
+	for i, column := range child.Columns {
+		if row[i] == nil {
+			conds = append(conds, fmt.Sprintf("%s IS NULL", column.Column))
+			continue
+		}
+		args = append(args, row[i])
+		conds = append(conds, fmt.Sprintf("%s = %s", column.Column, w.placeholder(len(args))))
+	}
+	return SQLStatement{
+		Query: fmt.Sprintf("DELETE FROM %s WHERE %s", child.Name, strings.Join(conds, " AND ")),
+		Args:  args,
+	}
+}
+
+func (w *SQLWriter) insert(child sqlChildTable, key interface{}, row []interface{}) SQLStatement {
+	columns := []string{child.ForeignKeyColumn}
+	placeholders := []string{w.placeholder(1)}
+	args := append([]interface{}{key}, row...)
+	for i, column := range child.Columns {
+		columns = append(columns, column.Column)
+		placeholders = append(placeholders, w.placeholder(i+2))
+	}
+	return SQLStatement{
+		Query: fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", child.Name, strings.Join(columns, ", "), strings.Join(placeholders, ", ")),
+		Args:  args,
+	}
+}
+
+// rows は複数値属性の各要素を子テーブルのカラムの順に並べた値のスライスに変換します。
+func (c sqlChildTable) rows(resource map[string]interface{}) [][]interface{} {
+	rows := [][]interface{}{}
+	value, ok := resolvePathValue(resource, c.path)
+	if !ok {
+		return rows
+	}
+	items, ok := areEveryItemsMap(value)
+	if !ok {
+		return rows
+	}
+	for _, item := range items {
+		row := make([]interface{}, len(c.Columns))
+		for i, column := range c.Columns {
+			_, row[i], _ = lookupKey(item, column.SubAttribute)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// subtractRows は rows1 に含まれ rows2 に含まれない行を返却します。
+func subtractRows(rows1 [][]interface{}, rows2 [][]interface{}) [][]interface{} {
+	counts := map[string]int{}
+	for _, row := range rows2 {
+		counts[fmt.Sprintf("%#v", row)]++
+	}
+	ret := [][]interface{}{}
+	for _, row := range rows1 {
+		k := fmt.Sprintf("%#v", row)
+		if counts[k] > 0 {
+			counts[k]--
+			continue
+		}
+		ret = append(ret, row)
+	}
+	return ret
+}
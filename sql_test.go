@@ -0,0 +1,245 @@
+package scimpatch_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// recordingDriver は実行された SQL 文を記録するだけのインメモリの database/sql ドライバです。
+type recordingDriver struct {
+	mu         sync.Mutex
+	statements []scimpatch.SQLStatement
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return &recordingConn{driver: d}, nil
+}
+
+func (d *recordingDriver) reset() []scimpatch.SQLStatement {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	statements := d.statements
+	d.statements = nil
+	return statements
+}
+
+type recordingConn struct {
+	driver *recordingDriver
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (c *recordingConn) Close() error              { return nil }
+func (c *recordingConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *recordingConn) Commit() error             { return nil }
+func (c *recordingConn) Rollback() error           { return nil }
+
+func (c *recordingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	c.driver.statements = append(c.driver.statements, scimpatch.SQLStatement{Query: query, Args: values})
+	return driver.RowsAffected(1), nil
+}
+
+var testDriver = &recordingDriver{}
+
+func init() {
+	sql.Register("scimpatch-recording", testDriver)
+}
+
+// TestSQLWriterApply は SQLWriter.Apply で実行される SQL 文をテストします
+func TestSQLWriterApply(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ops      []scim.PatchOperation
+		data     map[string]interface{}
+		expected []scimpatch.SQLStatement
+	}{
+		{
+			name: "update only touched columns",
+			ops: []scim.PatchOperation{
+				{Op: "replace", Path: path(`name.familyName`), Value: "Brown"},
+			},
+			data: map[string]interface{}{
+				"userName": "alice",
+				"name": map[string]interface{}{
+					"familyName": "Green",
+				},
+			},
+			expected: []scimpatch.SQLStatement{
+				{Query: "UPDATE users SET family_name = $1 WHERE id = $2", Args: []interface{}{"Brown", "0001"}},
+			},
+		},
+		{
+			name: "insert and delete child rows",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`emails[type eq "home"]`)},
+				{Op: "add", Path: path(`emails`), Value: []interface{}{
+					map[string]interface{}{"type": "other", "value": "alice@example.org"},
+				}},
+			},
+			data: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{"type": "work", "value": "alice@example.com"},
+					map[string]interface{}{"type": "home", "value": "alice@example.net"},
+				},
+			},
+			expected: []scimpatch.SQLStatement{
+				{Query: "DELETE FROM user_emails WHERE user_id = $1 AND type = $2 AND value = $3", Args: []interface{}{"0001", "home", "alice@example.net"}},
+				{Query: "INSERT INTO user_emails (user_id, type, value) VALUES ($1, $2, $3)", Args: []interface{}{"0001", "other", "alice@example.org"}},
+			},
+		},
+		{
+			name: "insert child row into rows stored as []map[string]interface{}",
+			ops: []scim.PatchOperation{
+				{Op: "add", Path: path(`emails`), Value: []interface{}{
+					map[string]interface{}{"type": "other", "value": "alice@example.org"},
+				}},
+			},
+			data: map[string]interface{}{
+				"emails": []map[string]interface{}{
+					{"type": "work", "value": "alice@example.com"},
+				},
+			},
+			expected: []scimpatch.SQLStatement{
+				{Query: "INSERT INTO user_emails (user_id, type, value) VALUES ($1, $2, $3)", Args: []interface{}{"0001", "other", "alice@example.org"}},
+			},
+		},
+		{
+			name: "replace sub-attribute of child row",
+			ops: []scim.PatchOperation{
+				{Op: "replace", Path: path(`emails[type eq "work"].value`), Value: "alice@example.org"},
+			},
+			data: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{"type": "work", "value": "alice@example.com"},
+					map[string]interface{}{"type": "home", "value": "alice@example.net"},
+				},
+			},
+			expected: []scimpatch.SQLStatement{
+				{Query: "DELETE FROM user_emails WHERE user_id = $1 AND type = $2 AND value = $3", Args: []interface{}{"0001", "work", "alice@example.com"}},
+				{Query: "INSERT INTO user_emails (user_id, type, value) VALUES ($1, $2, $3)", Args: []interface{}{"0001", "work", "alice@example.org"}},
+			},
+		},
+		{
+			name: "delete child row with missing column",
+			ops: []scim.PatchOperation{
+				{Op: "remove", Path: path(`emails[value eq "alice@example.com"]`)},
+			},
+			data: map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{"value": "alice@example.com"},
+				},
+			},
+			expected: []scimpatch.SQLStatement{
+				{Query: "DELETE FROM user_emails WHERE user_id = $1 AND type IS NULL AND value = $2", Args: []interface{}{"0001", "alice@example.com"}},
+			},
+		},
+		{
+			name: "not changed",
+			ops: []scim.PatchOperation{
+				{Op: "replace", Path: path(`userName`), Value: "alice"},
+			},
+			data: map[string]interface{}{
+				"userName": "alice",
+			},
+			expected: nil,
+		},
+	}
+
+	mapper, err := scimpatch.NewMapper([]scimpatch.Mapping{
+		{Path: `userName`, Field: "user_name"},
+		{Path: `name.familyName`, Field: "family_name"},
+	})
+	if err != nil {
+		t.Fatalf("NewMapper() returned an unexpected error: %v", err)
+	}
+	writer, err := scimpatch.NewSQLWriter("users", "id", mapper, []scimpatch.SQLChildTable{
+		{
+			Name:             "user_emails",
+			ForeignKeyColumn: "user_id",
+			Attribute:        "emails",
+			Columns: []scimpatch.SQLColumn{
+				{SubAttribute: "type", Column: "type"},
+				{SubAttribute: "value", Column: "value"},
+			},
+		},
+	}, &scimpatch.SQLWriterOpts{
+		Placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+	})
+	if err != nil {
+		t.Fatalf("NewSQLWriter() returned an unexpected error: %v", err)
+	}
+	db, err := sql.Open("scimpatch-recording", "")
+	if err != nil {
+		t.Fatalf("sql.Open() returned an unexpected error: %v", err)
+	}
+	defer db.Close()
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, nil)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testDriver.reset()
+			if _, err := writer.Apply(context.TODO(), db, patcher, "0001", tc.ops, tc.data); err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			actual := testDriver.reset()
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("statements:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+// TestNewSQLWriterError は NewSQLWriter の異常系をテストします
+func TestNewSQLWriterError(t *testing.T) {
+	emails := func(column string) []scimpatch.SQLChildTable {
+		return []scimpatch.SQLChildTable{{
+			Name:             "user_emails",
+			ForeignKeyColumn: "user_id",
+			Attribute:        "emails",
+			Columns:          []scimpatch.SQLColumn{{SubAttribute: "value", Column: column}},
+		}}
+	}
+	testCases := []struct {
+		name      string
+		table     string
+		keyColumn string
+		mappings  []scimpatch.Mapping
+		children  []scimpatch.SQLChildTable
+	}{
+		{name: "invalid table", table: "users; DROP TABLE users", keyColumn: "id"},
+		{name: "invalid key column", table: "users", keyColumn: "id = id OR 1"},
+		{name: "invalid field", table: "users", keyColumn: "id", mappings: []scimpatch.Mapping{{Path: `userName`, Field: "user name"}}},
+		{name: "invalid child column", table: "users", keyColumn: "id", children: emails(`"value"`)},
+		{name: "invalid child attribute", table: "users", keyColumn: "id", children: []scimpatch.SQLChildTable{{Name: "user_emails", ForeignKeyColumn: "user_id", Attribute: `emails[`}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mapper, err := scimpatch.NewMapper(tc.mappings)
+			if err != nil {
+				t.Fatalf("NewMapper() returned an unexpected error: %v", err)
+			}
+			if _, err := scimpatch.NewSQLWriter(tc.table, tc.keyColumn, mapper, tc.children, nil); err == nil {
+				t.Errorf("NewSQLWriter() must return error")
+			}
+		})
+	}
+
+	if _, err := scimpatch.NewSQLWriter("app.users", "id", nil, emails("value"), nil); err != nil {
+		t.Errorf("NewSQLWriter() returned an unexpected error for a schema-qualified table: %v", err)
+	}
+}
@@ -0,0 +1,370 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// TestStoredDataShapes は保存されているデータが属性の定義と異なる型で格納されている場合の Patcher.Apply をテストします
+func TestStoredDataShapes(t *testing.T) {
+	testCases := []struct {
+		name            string
+		op              scim.PatchOperation
+		data            map[string]interface{}
+		expected        map[string]interface{}
+		expectedChanged bool
+	}{
+		{
+			name: "Extension stored as scim.ResourceAttributes - path not specified",
+			op: scim.PatchOperation{
+				Op: "add",
+				Value: map[string]interface{}{
+					"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+						"department": "Sales",
+					},
+				},
+			},
+			data: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": scim.ResourceAttributes{
+					"division": "2B",
+				},
+			},
+			expected: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+					"department": "Sales",
+					"division":   "2B",
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "Extension stored as scim.ResourceAttributes - path specified",
+			op: scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department`),
+				Value: "Sales",
+			},
+			data: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": scim.ResourceAttributes{
+					"division": "2B",
+				},
+			},
+			expected: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+					"department": "Sales",
+					"division":   "2B",
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "Complex Attribute stored as scim.ResourceAttributes - dot notation",
+			op: scim.PatchOperation{
+				Op: "replace",
+				Value: map[string]interface{}{
+					"name.givenName": "Alice",
+				},
+			},
+			data: map[string]interface{}{
+				"name": scim.ResourceAttributes{
+					"familyName": "Green",
+				},
+			},
+			expected: map[string]interface{}{
+				"name": map[string]interface{}{
+					"familyName": "Green",
+					"givenName":  "Alice",
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "MultiValued Complex Attribute stored as []scim.ResourceAttributes",
+			op: scim.PatchOperation{
+				Op:   "remove",
+				Path: path(`emails[type eq "home"]`),
+			},
+			data: map[string]interface{}{
+				"emails": []scim.ResourceAttributes{
+					{"type": "home", "value": "alice@example.net"},
+					{"type": "work", "value": "alice@example.com"},
+				},
+			},
+			expected: map[string]interface{}{
+				"emails": []map[string]interface{}{
+					{"type": "work", "value": "alice@example.com"},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "MultiValued Complex Attribute stored as []map[string]interface{} - replace no changed",
+			op: scim.PatchOperation{
+				Op:   "replace",
+				Path: path(`emails`),
+				Value: []interface{}{
+					map[string]interface{}{"type": "work", "value": "alice@example.com"},
+				},
+			},
+			data: map[string]interface{}{
+				"emails": []map[string]interface{}{
+					{"type": "work", "value": "alice@example.com"},
+				},
+			},
+			expected: map[string]interface{}{
+				"emails": []map[string]interface{}{
+					{"type": "work", "value": "alice@example.com"},
+				},
+			},
+			expectedChanged: false,
+		},
+		{
+			name: "MultiValued Complex Attribute stored as []map[string]interface{} - add",
+			op: scim.PatchOperation{
+				Op:   "add",
+				Path: path(`emails`),
+				Value: []interface{}{
+					map[string]interface{}{"type": "home", "value": "alice@example.net"},
+				},
+			},
+			data: map[string]interface{}{
+				"emails": []map[string]interface{}{
+					{"type": "work", "value": "alice@example.com"},
+				},
+			},
+			expected: map[string]interface{}{
+				"emails": []map[string]interface{}{
+					{"type": "work", "value": "alice@example.com"},
+					{"type": "home", "value": "alice@example.net"},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "MultiValued Complex Attribute stored as single complex value",
+			op: scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`emails[type eq "work"].value`),
+				Value: "alice@example.org",
+			},
+			data: map[string]interface{}{
+				"emails": map[string]interface{}{"type": "work", "value": "alice@example.com"},
+			},
+			expected: map[string]interface{}{
+				"emails": []map[string]interface{}{
+					{"type": "work", "value": "alice@example.org"},
+				},
+			},
+			expectedChanged: true,
+		},
+		{
+			name: "value contains complex values",
+			op: scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`name`),
+				Value: scim.ResourceAttributes{"givenName": map[string]interface{}{"x": 1}},
+			},
+			data: map[string]interface{}{
+				"name": map[string]interface{}{"givenName": map[string]interface{}{"x": 1}},
+			},
+			expected: map[string]interface{}{
+				"name": map[string]interface{}{"givenName": map[string]interface{}{"x": 1}},
+			},
+			expectedChanged: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patcher := scimpatch.NewPatcher(
+				schema.CoreUserSchema(),
+				[]schema.Schema{
+					schema.ExtensionEnterpriseUser(),
+				}, nil)
+
+			result, changed, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if changed != tc.expectedChanged {
+				t.Errorf("changed:\n    actual  : %v\n    expected: %v", changed, tc.expectedChanged)
+			}
+			if !(fmt.Sprint(result) == fmt.Sprint(tc.expected)) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", result, tc.expected)
+			}
+		})
+	}
+}
+
+// TestStoredSliceTypeChange はフィルタによる remove で保存されている複数値属性の型が変わった後も add で既存の要素が上書きされないことをテストします
+func TestStoredSliceTypeChange(t *testing.T) {
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, nil)
+	data := map[string]interface{}{
+		"emails": []interface{}{
+			map[string]interface{}{"type": "work", "value": "alice@example.com"},
+			map[string]interface{}{"type": "home", "value": "alice@example.net"},
+		},
+	}
+	ops := []scim.PatchOperation{
+		{Op: "remove", Path: path(`emails[type eq "home"]`)},
+		{Op: "add", Path: path(`emails`), Value: []interface{}{
+			map[string]interface{}{"type": "other", "value": "alice@example.org"},
+		}},
+	}
+	result, changed, err := patcher.ApplyOperations(context.TODO(), ops, data)
+	if err != nil {
+		t.Fatalf("ApplyOperations() returned an unexpected error: %v", err)
+	}
+	expected := map[string]interface{}{
+		"emails": []map[string]interface{}{
+			{"type": "work", "value": "alice@example.com"},
+			{"type": "other", "value": "alice@example.org"},
+		},
+	}
+	if !changed {
+		t.Errorf("changed must be true")
+	}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("result:\n    actual  : %v\n    expected: %v", result, expected)
+	}
+}
+
+// TestStoredSliceNotRewritten はフィルタに一致する要素がなく変更がない場合に、保存されている複数値属性の型が変わらないことをテストします
+func TestStoredSliceNotRewritten(t *testing.T) {
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, nil)
+	ops := []scim.PatchOperation{
+		{Op: "remove", Path: path(`emails[type eq "home"]`)},
+		{Op: "replace", Path: path(`emails[type eq "home"].value`), Value: "alice@example.net"},
+	}
+	for _, op := range ops {
+		t.Run(op.Op, func(t *testing.T) {
+			data := map[string]interface{}{
+				"emails": []interface{}{
+					map[string]interface{}{"type": "work", "value": "alice@example.com"},
+				},
+			}
+			result, changed, err := patcher.Apply(context.TODO(), op, data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if changed {
+				t.Errorf("changed must be false")
+			}
+			if _, ok := result["emails"].([]interface{}); !ok {
+				t.Errorf("the stored type is rewritten: %T", result["emails"])
+			}
+		})
+	}
+}
+
+// TestStoredDataShapesError は保存されているデータを属性の定義どおりに扱えない場合の Patcher.Apply の異常系をテストします
+func TestStoredDataShapesError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		op       scim.PatchOperation
+		data     map[string]interface{}
+		expected errors.ScimType
+	}{
+		{
+			name: "sub attribute of string",
+			op: scim.PatchOperation{
+				Op:    "replace",
+				Path:  path(`name.givenName`),
+				Value: "Alice",
+			},
+			data:     map[string]interface{}{"name": "Alice"},
+			expected: errors.ScimTypeInvalidPath,
+		},
+		{
+			name: "dot notation of string",
+			op: scim.PatchOperation{
+				Op: "add",
+				Value: map[string]interface{}{
+					"name.givenName": "Alice",
+				},
+			},
+			data:     map[string]interface{}{"name": "Alice"},
+			expected: errors.ScimTypeInvalidValue,
+		},
+		{
+			name: "extension stored as string - path specified",
+			op: scim.PatchOperation{
+				Op:    "add",
+				Path:  path(`urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department`),
+				Value: "Sales",
+			},
+			data: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": "Sales",
+			},
+			expected: errors.ScimTypeInvalidPath,
+		},
+		{
+			name: "extension stored as string - path not specified",
+			op: scim.PatchOperation{
+				Op: "add",
+				Value: map[string]interface{}{
+					"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": map[string]interface{}{
+						"department": "Sales",
+					},
+				},
+			},
+			data: map[string]interface{}{
+				"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User": "Sales",
+			},
+			expected: errors.ScimTypeInvalidValue,
+		},
+		{
+			name: "filter on string",
+			op: scim.PatchOperation{
+				Op:   "remove",
+				Path: path(`emails[type eq "work"]`),
+			},
+			data:     map[string]interface{}{"emails": "alice@example.com"},
+			expected: errors.ScimTypeInvalidPath,
+		},
+		{
+			name: "filter on slice contains string",
+			op: scim.PatchOperation{
+				Op:   "remove",
+				Path: path(`emails[type eq "work"]`),
+			},
+			data: map[string]interface{}{"emails": []interface{}{
+				map[string]interface{}{"type": "work", "value": "alice@example.com"},
+				"alice@example.net",
+			}},
+			expected: errors.ScimTypeInvalidPath,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patcher := scimpatch.NewPatcher(
+				schema.CoreUserSchema(),
+				[]schema.Schema{
+					schema.ExtensionEnterpriseUser(),
+				}, nil)
+
+			before := fmt.Sprint(tc.data)
+			_, _, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if err == nil {
+				t.Fatalf("Apply() not returned error")
+			}
+			scimError, ok := err.(errors.ScimError)
+			if !ok {
+				t.Fatalf("Apply() not returned ScimError: %v", err)
+			}
+			if scimError.ScimType != tc.expected {
+				t.Errorf("Apply() not returned Expected ScimError: %v", scimError)
+			}
+			if fmt.Sprint(tc.data) != before {
+				t.Errorf("data must not be changed:\n    actual  : %v\n    expected: %v", tc.data, before)
+			}
+		})
+	}
+}
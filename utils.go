@@ -1,6 +1,9 @@
 package scimpatch
 
 import (
+	"reflect"
+	"strings"
+
 	"github.com/elimity-com/scim"
 	"github.com/elimity-com/scim/schema"
 )
@@ -8,6 +11,7 @@ import (
 var (
 	attributeMutabilityImmutable = "immutable"
 	attributeMutabilityReadOnly  = "readOnly"
+	attributeMutabilityWriteOnly = "writeOnly"
 )
 
 func cannotBePatched(op string, attr schema.CoreAttribute) bool {
@@ -27,24 +31,93 @@ func areEveryItemsMap(s interface{}) ([]map[string]interface{}, bool) {
 	case []map[string]interface{}:
 		return typed, true
 	case []interface{}:
-		maps := []map[string]interface{}{}
+		maps := make([]map[string]interface{}, 0, len(typed))
 		for _, item := range typed {
-			if map_, ok := item.(map[string]interface{}); ok {
+			if map_, ok := asMap(item); ok {
 				maps = append(maps, map_)
 			} else {
 				return nil, false
 			}
 		}
 		return maps, true
-	default:
+	case nil:
+		return nil, false
+	}
+	// []scim.ResourceAttributes などの map を要素とする名前付きの型のスライス
+	v := reflect.ValueOf(s)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Map {
+		return nil, false
+	}
+	maps := make([]map[string]interface{}, 0, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		map_, ok := asMap(v.Index(i).Interface())
+		if !ok {
+			return nil, false
+		}
+		maps = append(maps, map_)
+	}
+	return maps, true
+}
+
+var mapType = reflect.TypeOf(map[string]interface{}{})
+
+// asMap は value を map[string]interface{} として取得します。
+// scim.ResourceAttributes などの map[string]interface{} を基底とする名前付きの型も変換します。
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		return typed, true
+	case scim.ResourceAttributes:
+		return typed, true
+	case nil:
 		return nil, false
 	}
+	v := reflect.ValueOf(value)
+	if v.Kind() == reflect.Map && v.Type().ConvertibleTo(mapType) {
+		return v.Convert(mapType).Interface().(map[string]interface{}), true
+	}
+	return nil, false
+}
+
+// normalizeValue は名前付きの map 型や map を要素とするスライスを、Operator が扱う型に変換します。
+func normalizeValue(value interface{}) interface{} {
+	switch value.(type) {
+	case nil, string, bool, float64, map[string]interface{}, []map[string]interface{}, []interface{}:
+		return value
+	}
+	if m, ok := asMap(value); ok {
+		return m
+	}
+	if maps, ok := areEveryItemsMap(value); ok {
+		return maps
+	}
+	return value
+}
+
+// eqValue は v1 と v2 が等しいかを確認します。
+// map やスライスなど == で比較すると panic する型の場合は reflect.DeepEqual で比較します。
+func eqValue(v1 interface{}, v2 interface{}) bool {
+	if s1, ok := v1.(string); ok {
+		s2, ok := v2.(string)
+		return ok && s1 == s2
+	}
+	if v1 == nil || v2 == nil {
+		return v1 == nil && v2 == nil
+	}
+	t := reflect.TypeOf(v1)
+	if t != reflect.TypeOf(v2) {
+		return false
+	}
+	if t.Comparable() && t.Kind() != reflect.Interface && t.Kind() != reflect.Struct && t.Kind() != reflect.Array {
+		return v1 == v2
+	}
+	return reflect.DeepEqual(v1, v2)
 }
 
 func mergeMap(mergee map[string]interface{}, merger map[string]interface{}) (map[string]interface{}, bool) {
 	merged := false
 	for mergerKey, mergerValue := range merger {
-		if mergeeValue, ok := mergee[mergerKey]; !ok || mergeeValue != mergerValue {
+		if mergeeValue, ok := mergee[mergerKey]; !ok || !eqValue(mergeeValue, mergerValue) {
 			mergee[mergerKey] = mergerValue
 			merged = true
 		}
@@ -57,7 +130,7 @@ func eqMap(m1 map[string]interface{}, m2 map[string]interface{}) bool {
 		return false
 	}
 	for m1k, m1v := range m1 {
-		if m2v, ok := m2[m1k]; !ok || m2v != m1v {
+		if m2v, ok := m2[m1k]; !ok || !eqValue(m2v, m1v) {
 			return false
 		}
 	}
@@ -73,11 +146,86 @@ func containsMap(slice []map[string]interface{}, item map[string]interface{}) bo
 	return false
 }
 
+// containsSubMap は item が slice のいずれかの要素の属性をすべて同じ値で保持しているかを確認します
+func containsSubMap(slice []map[string]interface{}, item map[string]interface{}) bool {
+	for _, v := range slice {
+		if len(v) != 0 && isSubMap(v, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSubMap は m が sub の属性をすべて同じ値で保持しているかを確認します
+func isSubMap(sub map[string]interface{}, m map[string]interface{}) bool {
+	for k, v := range sub {
+		if mv, ok := m[k]; !ok || !eqValue(mv, v) {
+			return false
+		}
+	}
+	return true
+}
+
 func containsItem(slice []interface{}, item interface{}) bool {
 	for _, v := range slice {
-		if v == item {
+		if eqValue(v, item) {
 			return true
 		}
 	}
 	return false
 }
+
+// copyMap は map を再帰的に複製します。
+// Patcher は受け取った map を直接更新するため、変更前の状態を保持したい場合に利用します。
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		copied[k] = copyValue(v)
+	}
+	return copied
+}
+
+// copyValue は map やスライスを含む値を再帰的に複製します。
+func copyValue(v interface{}) interface{} {
+	switch typed := v.(type) {
+	case map[string]interface{}:
+		return copyMap(typed)
+	case []map[string]interface{}:
+		copied := make([]map[string]interface{}, len(typed))
+		for i, item := range typed {
+			copied[i] = copyMap(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(typed))
+		for i, item := range typed {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	}
+	// scim.ResourceAttributes などの名前付きの型は、複製した map[string]interface{} として返却します
+	switch normalized := normalizeValue(v).(type) {
+	case map[string]interface{}:
+		return copyMap(normalized)
+	case []map[string]interface{}:
+		return copyValue(normalized)
+	}
+	return v
+}
+
+// lookupKey は map から大文字小文字を区別せずに key に対応する値を取得します。
+// SCIM の属性名は大文字小文字を区別しないため、完全一致しない場合は EqualFold で比較します。
+func lookupKey(m map[string]interface{}, key string) (string, interface{}, bool) {
+	if v, ok := m[key]; ok {
+		return key, v, true
+	}
+	for k, v := range m {
+		if strings.EqualFold(k, key) {
+			return k, v, true
+		}
+	}
+	return "", nil, false
+}
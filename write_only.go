@@ -0,0 +1,185 @@
+package scimpatch
+
+import (
+	"context"
+	"strings"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/schema"
+	"github.com/scim2/filter-parser/v2"
+)
+
+// RedactedValue は writeOnly の属性の値の代わりにログ等へ出力される値です。
+const RedactedValue = "[REDACTED]"
+
+// WriteOnlySink は writeOnly の属性 (e.g. password) に対する add, replace の value を受け取り、
+// data に保存する値 (e.g. ハッシュ化した値) を返却する関数です。
+// nil を返却した場合、その属性は data に保存されません。エラーを返却した場合、op は適用されずそのエラーが返却されます。
+// PatcherOpts.WriteOnlySink が設定されていない場合、writeOnly の属性の value はそのまま保存されます。
+type WriteOnlySink func(ctx context.Context, op HookOperation) (interface{}, error)
+
+// isWriteOnly は属性が writeOnly であるかを判断します。
+func isWriteOnly(attr schema.CoreAttribute) bool {
+	return attr.Mutability() == attributeMutabilityWriteOnly
+}
+
+// writeOnlyAttribute は属性もしくはサブ属性が writeOnly であるかを判断します。
+func (p *Patcher) writeOnlyAttribute(schemaURI string, attrName string, subAttrName string) bool {
+	s, ok := p.schemas[schemaURI]
+	if !ok {
+		return false
+	}
+	attr, ok := s.Attributes.ContainsAttribute(attrName)
+	if !ok {
+		return false
+	}
+	if isWriteOnly(attr) {
+		return true
+	}
+	if subAttrName == "" {
+		return false
+	}
+	for _, subAttr := range attr.SubAttributes() {
+		if strings.EqualFold(subAttr.Name(), subAttrName) {
+			return isWriteOnly(subAttr)
+		}
+	}
+	return false
+}
+
+// writeOnlyPath は path が writeOnly の属性を示しているかを判断します。
+func (p *Patcher) writeOnlyPath(path *filter.Path) bool {
+	attrName := path.AttributePath.AttributeName
+	subAttrName := path.SubAttributeName()
+	if path.AttributePath.SubAttribute != nil {
+		subAttrName = *path.AttributePath.SubAttribute
+	}
+	return p.writeOnlyAttribute(p.schemaURIOf(path.AttributePath.URIPrefix, attrName), attrName, subAttrName)
+}
+
+// writeOnlyKey は path 未指定の op の value に含まれる key (ドット区切りを含む) が writeOnly の属性であるかを判断します。
+func (p *Patcher) writeOnlyKey(schemaURI string, key string) bool {
+	attrName, subAttrName, _ := strings.Cut(key, ".")
+	if schemaURI == "" {
+		schemaURI = p.schemaURIOf(nil, attrName)
+	}
+	return p.writeOnlyAttribute(schemaURI, attrName, subAttrName)
+}
+
+// DiscardWriteOnly は writeOnly の属性の value を保存せずに破棄する WriteOnlySink です。
+// 外部で値を保存しない場合に、平文のまま保存されることを防ぐために PatcherOpts.WriteOnlySink に指定します。
+func DiscardWriteOnly(context.Context, HookOperation) (interface{}, error) {
+	return nil, nil
+}
+
+// routeWriteOnly は writeOnly の属性に対する value を WriteOnlySink に渡し、返却された値で置き換えた op を返却します。
+// path が指定された op の属性が保存されない場合は、consumed として true を返却します。
+func (p *Patcher) routeWriteOnly(ctx context.Context, op scim.PatchOperation, data map[string]interface{}) (scim.PatchOperation, bool, error) {
+	if p.writeOnlySink == nil || op.Op == scim.PatchOperationRemove {
+		return op, false, nil
+	}
+
+	if op.Path != nil {
+		if !p.writeOnlyPath(op.Path) {
+			return op, false, nil
+		}
+		attrName := op.Path.AttributePath.AttributeName
+		stored, err := p.writeOnlySink(ctx, HookOperation{
+			Op:           op.Op,
+			Path:         op.Path,
+			SchemaURI:    p.schemaURIOf(op.Path.AttributePath.URIPrefix, attrName),
+			Attribute:    attrName,
+			SubAttribute: op.Path.SubAttributeName(),
+			Value:        op.Value,
+			Data:         data,
+		})
+		if err != nil {
+			return op, false, err
+		}
+		if stored == nil {
+			return op, true, nil
+		}
+		op.Value = stored
+		return op, false, nil
+	}
+
+	newMap, ok := asMap(op.Value)
+	if !ok {
+		return op, false, nil
+	}
+	sink := func(schemaURI string, key string, value interface{}) (interface{}, error) {
+		return p.writeOnlySink(ctx, newAttributeHookOperation(op.Op, schemaURI, key, value, data))
+	}
+	routed := make(map[string]interface{}, len(newMap))
+	for key, value := range newMap {
+		if extensionID, ok := p.extensionKey(key); ok {
+			if extMap, ok := asMap(value); ok {
+				routedExt := make(map[string]interface{}, len(extMap))
+				for extKey, extValue := range extMap {
+					if p.writeOnlyKey(extensionID, extKey) {
+						stored, err := sink(extensionID, extKey, extValue)
+						if err != nil {
+							return op, false, err
+						}
+						if stored == nil {
+							continue
+						}
+						extValue = stored
+					}
+					routedExt[extKey] = extValue
+				}
+				routed[key] = routedExt
+				continue
+			}
+		}
+		if p.writeOnlyKey("", key) {
+			attrName, _, _ := strings.Cut(key, ".")
+			stored, err := sink(p.schemaURIOf(nil, attrName), key, value)
+			if err != nil {
+				return op, false, err
+			}
+			if stored == nil {
+				continue
+			}
+			value = stored
+		}
+		routed[key] = value
+	}
+	op.Value = routed
+	return op, false, nil
+}
+
+// redactedValue はログ等に出力するために、writeOnly の属性の値を RedactedValue で置き換えた op の value を返却します。
+func (p *Patcher) redactedValue(op scim.PatchOperation) interface{} {
+	if op.Path != nil {
+		if p.writeOnlyPath(op.Path) {
+			return RedactedValue
+		}
+		return op.Value
+	}
+	newMap, ok := asMap(op.Value)
+	if !ok {
+		return op.Value
+	}
+	redacted := make(map[string]interface{}, len(newMap))
+	for key, value := range newMap {
+		if extensionID, ok := p.extensionKey(key); ok {
+			if extMap, ok := asMap(value); ok {
+				redactedExt := make(map[string]interface{}, len(extMap))
+				for extKey, extValue := range extMap {
+					if p.writeOnlyKey(extensionID, extKey) {
+						extValue = RedactedValue
+					}
+					redactedExt[extKey] = extValue
+				}
+				redacted[key] = redactedExt
+				continue
+			}
+		}
+		if p.writeOnlyKey("", key) {
+			value = RedactedValue
+		}
+		redacted[key] = value
+	}
+	return redacted
+}
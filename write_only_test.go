@@ -0,0 +1,301 @@
+package scimpatch_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/elimity-com/scim"
+	"github.com/elimity-com/scim/errors"
+	"github.com/elimity-com/scim/optional"
+	"github.com/elimity-com/scim/schema"
+	scimpatch "github.com/ivixvi/scim-patch"
+)
+
+// hashSink は password をハッシュ化したとみなした値を返却する WriteOnlySink です
+// "weak" は拒否し、"external" は外部に保存したとみなして data には保存しません
+func hashSink(ctx context.Context, op scimpatch.HookOperation) (interface{}, error) {
+	switch op.Value {
+	case "weak":
+		err := errors.ScimErrorInvalidValue
+		err.Detail = "The password is too weak."
+		return nil, err
+	case "external":
+		return nil, nil
+	}
+	return fmt.Sprintf("hashed(%v)", op.Value), nil
+}
+
+// TestWriteOnlySink は writeOnly の属性の value が WriteOnlySink を経由して保存されることをテストします
+func TestWriteOnlySink(t *testing.T) {
+	testCases := []struct {
+		name            string
+		op              scim.PatchOperation
+		data            map[string]interface{}
+		expected        map[string]interface{}
+		expectedChanged bool
+		expectedError   bool
+	}{
+		{
+			name:            "path specified",
+			op:              scim.PatchOperation{Op: "replace", Path: path(`password`), Value: "secret"},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{"password": "hashed(secret)"},
+			expectedChanged: true,
+		},
+		{
+			name:            "path not specified",
+			op:              scim.PatchOperation{Op: "replace", Value: map[string]interface{}{"password": "secret", "title": "Manager"}},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{"password": "hashed(secret)", "title": "Manager"},
+			expectedChanged: true,
+		},
+		{
+			name:            "not stored",
+			op:              scim.PatchOperation{Op: "replace", Path: path(`password`), Value: "external"},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{},
+			expectedChanged: false,
+		},
+		{
+			name:            "not stored without path",
+			op:              scim.PatchOperation{Op: "add", Value: map[string]interface{}{"password": "external", "title": "Manager"}},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{"title": "Manager"},
+			expectedChanged: true,
+		},
+		{
+			name:          "rejected",
+			op:            scim.PatchOperation{Op: "replace", Path: path(`password`), Value: "weak"},
+			data:          map[string]interface{}{"password": "hashed(secret)"},
+			expected:      map[string]interface{}{"password": "hashed(secret)"},
+			expectedError: true,
+		},
+		{
+			name:            "remove is not routed",
+			op:              scim.PatchOperation{Op: "remove", Path: path(`password`)},
+			data:            map[string]interface{}{"password": "hashed(secret)"},
+			expected:        map[string]interface{}{},
+			expectedChanged: true,
+		},
+	}
+
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{WriteOnlySink: hashSink})
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, changed, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if (err != nil) != tc.expectedError {
+				t.Fatalf("error: %v", err)
+			}
+			if changed != tc.expectedChanged {
+				t.Errorf("changed:\n    actual  : %t\n    expected: %t", changed, tc.expectedChanged)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+// TestWriteOnlyWithoutSink は WriteOnlySink が設定されていない場合にはそのまま保存され、
+// DiscardWriteOnly を指定した場合には writeOnly の属性の value が保存されないことをテストします
+func TestWriteOnlyWithoutSink(t *testing.T) {
+	testCases := []struct {
+		name            string
+		sink            scimpatch.WriteOnlySink
+		op              scim.PatchOperation
+		data            map[string]interface{}
+		expected        map[string]interface{}
+		expectedChanged bool
+	}{
+		{
+			name:            "not set - path specified",
+			op:              scim.PatchOperation{Op: "replace", Path: path(`password`), Value: "secret"},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{"password": "secret"},
+			expectedChanged: true,
+		},
+		{
+			name:            "not set - path not specified",
+			op:              scim.PatchOperation{Op: "replace", Value: map[string]interface{}{"password": "secret", "title": "Manager"}},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{"password": "secret", "title": "Manager"},
+			expectedChanged: true,
+		},
+		{
+			name:            "discard - path specified",
+			sink:            scimpatch.DiscardWriteOnly,
+			op:              scim.PatchOperation{Op: "replace", Path: path(`password`), Value: "secret"},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{},
+			expectedChanged: false,
+		},
+		{
+			name:            "discard - path specified with stored value",
+			sink:            scimpatch.DiscardWriteOnly,
+			op:              scim.PatchOperation{Op: "add", Path: path(`password`), Value: "secret"},
+			data:            map[string]interface{}{"password": "hashed(old)"},
+			expected:        map[string]interface{}{"password": "hashed(old)"},
+			expectedChanged: false,
+		},
+		{
+			name:            "discard - path not specified",
+			sink:            scimpatch.DiscardWriteOnly,
+			op:              scim.PatchOperation{Op: "replace", Value: map[string]interface{}{"password": "secret", "title": "Manager"}},
+			data:            map[string]interface{}{},
+			expected:        map[string]interface{}{"title": "Manager"},
+			expectedChanged: true,
+		},
+		{
+			name:            "discard - remove",
+			sink:            scimpatch.DiscardWriteOnly,
+			op:              scim.PatchOperation{Op: "remove", Path: path(`password`)},
+			data:            map[string]interface{}{"password": "hashed(old)"},
+			expected:        map[string]interface{}{},
+			expectedChanged: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{WriteOnlySink: tc.sink})
+			actual, changed, err := patcher.Apply(context.TODO(), tc.op, tc.data)
+			if err != nil {
+				t.Fatalf("Apply() returned an unexpected error: %v", err)
+			}
+			if changed != tc.expectedChanged {
+				t.Errorf("changed:\n    actual  : %t\n    expected: %t", changed, tc.expectedChanged)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("result:\n    actual  : %v\n    expected: %v", actual, tc.expected)
+			}
+		})
+	}
+}
+
+// TestWriteOnlyChanges は Mapper.Apply が writeOnly の属性の変更の値を返却しないことをテストします
+func TestWriteOnlyChanges(t *testing.T) {
+	mapper, err := scimpatch.NewMapper([]scimpatch.Mapping{
+		{Path: "title", Field: "title"},
+		{Path: "password", Field: "password_hash"},
+	})
+	if err != nil {
+		t.Fatalf("NewMapper() returned an unexpected error: %v", err)
+	}
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, &scimpatch.PatcherOpts{WriteOnlySink: hashSink})
+
+	_, changes, err := mapper.Apply(context.TODO(), patcher, []scim.PatchOperation{
+		{Op: "replace", Value: map[string]interface{}{"password": "secret", "title": "Manager"}},
+	}, map[string]interface{}{"title": "Engineer"})
+	if err != nil {
+		t.Fatalf("Apply() returned an unexpected error: %v", err)
+	}
+	expected := []scimpatch.FieldChange{
+		{Field: "title", Path: "title", Old: "Engineer", New: "Manager"},
+		{Field: "password_hash", Path: "password", Redacted: true},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes:\n    actual  : %v\n    expected: %v", changes, expected)
+	}
+}
+
+// TestWriteOnlyChangesAndStatements は Mapper.Changes が Patcher のスキーマで writeOnly の属性の値を返却せず、
+// SQLWriter.Statements が保存されている値を書き込むことをテストします
+func TestWriteOnlyChangesAndStatements(t *testing.T) {
+	deviceSchema := schema.Schema{
+		ID:   "urn:ivixvi:schemas:Device",
+		Name: optional.NewString("Device"),
+		Attributes: []schema.CoreAttribute{
+			schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{Name: "name"})),
+			schema.SimpleCoreAttribute(schema.SimpleStringParams(schema.StringParams{
+				Name:       "secret",
+				Mutability: schema.AttributeMutabilityWriteOnly(),
+			})),
+		},
+	}
+	mapper, err := scimpatch.NewMapper([]scimpatch.Mapping{
+		{Path: "name", Field: "name"},
+		{Path: "secret", Field: "secret_hash"},
+	})
+	if err != nil {
+		t.Fatalf("NewMapper() returned an unexpected error: %v", err)
+	}
+	patcher := scimpatch.NewPatcher(deviceSchema, nil, &scimpatch.PatcherOpts{WriteOnlySink: hashSink})
+	before := map[string]interface{}{"name": "printer", "secret": "hashed(old)"}
+	after := map[string]interface{}{"name": "scanner", "secret": "hashed(new)"}
+
+	changes, err := mapper.Changes(patcher, before, after)
+	if err != nil {
+		t.Fatalf("Changes() returned an unexpected error: %v", err)
+	}
+	expected := []scimpatch.FieldChange{
+		{Field: "name", Path: "name", Old: "printer", New: "scanner"},
+		{Field: "secret_hash", Path: "secret", Redacted: true},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes:\n    actual  : %v\n    expected: %v", changes, expected)
+	}
+
+	writer, err := scimpatch.NewSQLWriter("devices", "id", mapper, nil, nil)
+	if err != nil {
+		t.Fatalf("NewSQLWriter() returned an unexpected error: %v", err)
+	}
+	statements, err := writer.Statements(patcher, "0001", before, after)
+	if err != nil {
+		t.Fatalf("Statements() returned an unexpected error: %v", err)
+	}
+	expectedStatements := []scimpatch.SQLStatement{
+		{Query: "UPDATE devices SET name = ?, secret_hash = ? WHERE id = ?", Args: []interface{}{"scanner", "hashed(new)", "0001"}},
+	}
+	if !reflect.DeepEqual(statements, expectedStatements) {
+		t.Errorf("statements:\n    actual  : %v\n    expected: %v", statements, expectedStatements)
+	}
+
+	if _, err := mapper.Changes(nil, before, after); err == nil {
+		t.Errorf("Changes() must return an error without a Patcher")
+	}
+	if _, err := writer.Statements(nil, "0001", before, after); err == nil {
+		t.Errorf("Statements() must return an error without a Patcher")
+	}
+}
+
+// recordingLogger は出力された内容を記録する PatcherLogger です
+type recordingLogger struct {
+	lines *[]string
+}
+
+func (l recordingLogger) Error(args ...interface{}) {
+	*l.lines = append(*l.lines, fmt.Sprintln(args...))
+}
+
+func (l recordingLogger) Debug(args ...interface{}) {
+	*l.lines = append(*l.lines, fmt.Sprintln(args...))
+}
+
+// TestWriteOnlyRedactedInLog は writeOnly の属性の値がログに出力されないことをテストします
+func TestWriteOnlyRedactedInLog(t *testing.T) {
+	var lines []string
+	ctx := scimpatch.AddLogger(context.TODO(), recordingLogger{lines: &lines})
+	patcher := scimpatch.NewPatcher(schema.CoreUserSchema(), nil, nil)
+	ops := []scim.PatchOperation{
+		{Op: "replace", Path: path(`password`), Value: "secret"},
+		{Op: "replace", Value: map[string]interface{}{"password": "secret", "title": "Manager"}},
+	}
+	if _, _, err := patcher.ApplyOperations(ctx, ops, map[string]interface{}{}); err != nil {
+		t.Fatalf("ApplyOperations() returned an unexpected error: %v", err)
+	}
+	redacted := 0
+	for _, line := range lines {
+		if strings.Contains(line, "secret") {
+			t.Errorf("the password is logged: %s", line)
+		}
+		if strings.Contains(line, scimpatch.RedactedValue) {
+			redacted++
+		}
+	}
+	if redacted != len(ops) {
+		t.Errorf("the password is not redacted:\n    actual  : %d\n    expected: %d\n    lines   : %v", redacted, len(ops), lines)
+	}
+}